@@ -0,0 +1,303 @@
+// Package assay sets what a refinery found in a stash lot against what was
+// declared when the lot's buys were weighed, and shares the difference out
+// over those buys so losses can be traced to who declared them.
+package assay
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What losses can be grouped by
+const (
+	ByAssociate = "associate"
+	ByScale     = "scale"
+	ByCustomer  = "customer"
+)
+
+var (
+	ErrUnknownGroup  = errors.New("unknown group, expected associate, scale or customer")
+	ErrInvalidAssay  = errors.New("fineness must be at most 1000 and the fine weight at most the gross weight")
+	ErrEmptyLot      = errors.New("nothing was declared in the stash")
+	ErrUnknownScale  = errors.New("the scale a buy was weighed on is not known")
+	ErrStashNotReady = errors.New("an open or voided stash cannot be assayed")
+)
+
+// precision of weights and percentages worked out
+const (
+	weightPlaces  = 4
+	percentPlaces = 2
+)
+
+var (
+	thousand = models.NewDecimal(1000, 0)
+	hundred  = models.NewDecimal(100, 0)
+)
+
+// LossTotal is the declared and assayed fine weight of the buys of one
+// associate, scale or customer.
+type LossTotal struct {
+	Key         string         `json:"key"`
+	Lines       int            `json:"lines"`
+	Declared    models.Decimal `json:"declared_fine_weight"`
+	Assayed     models.Decimal `json:"assayed_fine_weight"`
+	Loss        models.Decimal `json:"loss"`
+	LossPercent models.Decimal `json:"loss_percent"`
+}
+
+// Prepare works out the fine weight of an assay of stash when it was not
+// given, what the lot was declared at, the loss against it, and each buy's
+// share.
+func Prepare(ctx context.Context, assay *models.Assay, stash *models.Stash) error {
+	if stash.Void != nil || stash.Status == "" || stash.Status == "open" {
+		return ErrStashNotReady
+	}
+
+	if !assay.FineWeight.IsSet() && assay.GrossWeight.IsSet() && assay.Fineness.IsSet() {
+		fine, err := assay.GrossWeight.Mul(assay.Fineness).Div(thousand, weightPlaces)
+		if err != nil {
+			return err
+		}
+		assay.FineWeight = fine
+	}
+	if assay.Fineness.Cmp(thousand) > 0 || assay.FineWeight.Cmp(assay.GrossWeight) > 0 {
+		return ErrInvalidAssay
+	}
+
+	lines, err := declaredLines(ctx, stash)
+	if err != nil {
+		return err
+	}
+
+	declared := models.NewDecimal(0, 0)
+	for _, line := range lines {
+		declared = declared.Add(line.DeclaredFineWeight)
+	}
+	if declared.Sign() <= 0 {
+		return ErrEmptyLot
+	}
+
+	// the assayed fine weight is shared in proportion to what was declared,
+	// the last line taking what rounding leaves
+	remaining := assay.FineWeight
+	for i := range lines {
+		share := remaining
+		if i < len(lines)-1 {
+			share, err = assay.FineWeight.Mul(lines[i].DeclaredFineWeight).Div(declared, weightPlaces)
+			if err != nil {
+				return err
+			}
+		}
+		lines[i].AssayedFineWeight = share
+		lines[i].Loss = lines[i].DeclaredFineWeight.Sub(share)
+		remaining = remaining.Sub(share)
+	}
+
+	assay.StashID = stash.ID
+	assay.DeclaredWeight = stash.Weight
+	assay.DeclaredFineWeight = declared
+	assay.WeightLoss = stash.Weight.Sub(assay.GrossWeight)
+	assay.FineLoss = declared.Sub(assay.FineWeight)
+	assay.LossPercent, err = assay.FineLoss.Mul(hundred).Div(declared, percentPlaces)
+	if err != nil {
+		return err
+	}
+	assay.Lines = lines
+
+	return nil
+}
+
+// declaredLines lists the fine weight declared for each buy in a stash's
+// lot, and for the loose weight put in it, at the fineness it left stock at.
+func declaredLines(ctx context.Context, stash *models.Stash) ([]models.AssayLine, error) {
+	lines := []models.AssayLine{}
+	lotWeight := models.NewDecimal(0, 0)
+
+	if len(stash.Transactions) > 0 {
+		cursor, err := database.Database.Collection(models.Collection.Transaction).Find(ctx, bson.M{"_id": bson.M{"$in": stash.Transactions}})
+		if err != nil {
+			return nil, err
+		}
+		var transactions []models.Transaction
+		if err := cursor.All(ctx, &transactions); err != nil {
+			return nil, err
+		}
+
+		factors, err := scaleFactors(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, transaction := range transactions {
+			factor, ok := transaction.Factor, true
+			if !factor.IsSet() {
+				factor, ok = factors[transaction.Scale]
+			}
+			if !ok {
+				return nil, ErrUnknownScale
+			}
+			weight := transaction.Weight.Mul(factor)
+
+			var fine models.Decimal
+			if transaction.Purity != nil {
+				fine = transaction.Purity.FineWeight.Mul(factor)
+			} else {
+				fine, err = inventory.FineWeightOf(ctx, transaction.Mineral, transaction.Grade, weight)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			lotWeight = lotWeight.Add(weight)
+			lines = append(lines, models.AssayLine{
+				TransactionID:      transaction.ID,
+				AssociateID:        transaction.AssociateID,
+				CustomerID:         transaction.CustomerID,
+				Scale:              transaction.Scale,
+				DeclaredFineWeight: fine,
+			})
+		}
+	}
+
+	loose := stash.Weight.Sub(lotWeight)
+	if loose.Sign() <= 0 {
+		return lines, nil
+	}
+
+	weight, fine, err := issued(ctx, stash.ID)
+	if err != nil {
+		return nil, err
+	}
+	if weight.Sign() <= 0 {
+		return lines, nil
+	}
+
+	looseFine, err := loose.Mul(fine).Div(weight, weightPlaces)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(lines, models.AssayLine{DeclaredFineWeight: looseFine}), nil
+}
+
+// issued sums the weight and fine weight that left stock for a stash
+func issued(ctx context.Context, stashID primitive.ObjectID) (models.Decimal, models.Decimal, error) {
+	cursor, err := database.Database.Collection(models.Collection.StockMovement).Find(ctx, bson.M{"reference_id": stashID})
+	if err != nil {
+		return models.Decimal{}, models.Decimal{}, err
+	}
+
+	var movements []models.StockMovement
+	if err := cursor.All(ctx, &movements); err != nil {
+		return models.Decimal{}, models.Decimal{}, err
+	}
+
+	// reversals carry the opposite sign, so summing everything nets them out
+	weight, fine := models.NewDecimal(0, 0), models.NewDecimal(0, 0)
+	for _, movement := range movements {
+		weight = weight.Sub(movement.Weight)
+		fine = fine.Sub(movement.FineWeight)
+	}
+
+	return weight, fine, nil
+}
+
+func scaleFactors(ctx context.Context) (map[string]models.Decimal, error) {
+	cursor, err := database.Database.Collection(models.Collection.Scale).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var scales []models.Scale
+	if err := cursor.All(ctx, &scales); err != nil {
+		return nil, err
+	}
+
+	factors := make(map[string]models.Decimal, len(scales))
+	for _, scale := range scales {
+		factors[scale.Code] = scale.Factor
+	}
+
+	return factors, nil
+}
+
+// Losses totals the assay lines of the live assays matching filter by
+// associate, scale or customer, the largest loss percentage first.
+func Losses(ctx context.Context, filter bson.M, group string) ([]LossTotal, error) {
+	if group != ByAssociate && group != ByScale && group != ByCustomer {
+		return nil, ErrUnknownGroup
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.Assay).Find(ctx, database.ExcludeVoided(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	var assays []models.Assay
+	if err := cursor.All(ctx, &assays); err != nil {
+		return nil, err
+	}
+
+	totals := map[string]*LossTotal{}
+
+	for _, assay := range assays {
+		for _, line := range assay.Lines {
+			var key string
+			switch group {
+			case ByAssociate:
+				if !line.AssociateID.IsZero() {
+					key = line.AssociateID.Hex()
+				}
+			case ByScale:
+				key = line.Scale
+			case ByCustomer:
+				if !line.CustomerID.IsZero() {
+					key = line.CustomerID.Hex()
+				}
+			}
+
+			// loose weight cannot be traced to anyone
+			if key == "" {
+				continue
+			}
+
+			total, ok := totals[key]
+			if !ok {
+				zero := models.NewDecimal(0, 0)
+				total = &LossTotal{Key: key, Declared: zero, Assayed: zero, Loss: zero}
+				totals[key] = total
+			}
+			total.Lines++
+			total.Declared = total.Declared.Add(line.DeclaredFineWeight)
+			total.Assayed = total.Assayed.Add(line.AssayedFineWeight)
+			total.Loss = total.Loss.Add(line.Loss)
+		}
+	}
+
+	results := make([]LossTotal, 0, len(totals))
+	for _, total := range totals {
+		if total.Declared.Sign() > 0 {
+			total.LossPercent, err = total.Loss.Mul(hundred).Div(total.Declared, percentPlaces)
+			if err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, *total)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if c := results[i].LossPercent.Cmp(results[j].LossPercent); c != 0 {
+			return c > 0
+		}
+		return results[i].Key < results[j].Key
+	})
+
+	return results, nil
+}
@@ -0,0 +1,183 @@
+// Package catalogue keeps the minerals the business deals in and the grades
+// they are bought at, and checks that records describe what they hold the
+// way their mineral requires.
+package catalogue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How a mineral is graded
+const (
+	GradingFineness = "fineness" // by a purity grade, gold
+	GradingQuality  = "quality"  // by carat, colour and clarity, diamond
+)
+
+var (
+	ErrUnknownMineral  = errors.New("no active mineral")
+	ErrUnknownGrade    = errors.New("no active grade")
+	ErrGradeRequired   = errors.New("a grade is required")
+	ErrQualityRequired = errors.New("carat, colour and clarity are required")
+	ErrNoQuality       = errors.New("carat, colour and clarity only describe minerals graded by quality")
+	ErrInvalidPurity   = errors.New("karat must be at most 24 and fineness at most 1000")
+)
+
+var (
+	maxKarat    = models.NewDecimal(24, 0)
+	maxFineness = models.NewDecimal(1000, 0)
+)
+
+// Minerals lists the catalogue, only the active minerals when activeOnly is set.
+func Minerals(ctx context.Context, activeOnly bool) ([]models.Mineral, error) {
+	filter := bson.M{}
+	if activeOnly {
+		filter["active"] = true
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.Mineral).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "code", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	minerals := []models.Mineral{}
+	if err := cursor.All(ctx, &minerals); err != nil {
+		return nil, err
+	}
+
+	return minerals, nil
+}
+
+// MineralFor finds the active mineral with the given code.
+func MineralFor(ctx context.Context, code string) (*models.Mineral, error) {
+	var mineral models.Mineral
+
+	err := database.Database.Collection(models.Collection.Mineral).FindOne(ctx,
+		bson.M{"code": strings.ToLower(strings.TrimSpace(code)), "active": true},
+	).Decode(&mineral)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMineral, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &mineral, nil
+}
+
+// Grades lists the grades of a mineral, only the active ones when
+// activeOnly is set.
+func Grades(ctx context.Context, mineral string, activeOnly bool) ([]models.Grade, error) {
+	filter := bson.M{"mineral": mineral}
+	if activeOnly {
+		filter["active"] = true
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.Grade).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "fineness", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	grades := []models.Grade{}
+	if err := cursor.All(ctx, &grades); err != nil {
+		return nil, err
+	}
+
+	return grades, nil
+}
+
+// GradeFor finds the active grade of a mineral with the given code.
+func GradeFor(ctx context.Context, mineral string, code string) (*models.Grade, error) {
+	var grade models.Grade
+
+	err := database.Database.Collection(models.Collection.Grade).FindOne(ctx,
+		bson.M{"mineral": mineral, "code": strings.ToLower(strings.TrimSpace(code)), "active": true},
+	).Decode(&grade)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w %q for %s", ErrUnknownGrade, code, mineral)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &grade, nil
+}
+
+// PreparePurity fills in whichever of karat and fineness a grade was given
+// without and checks both are in range.
+func PreparePurity(grade *models.Grade) error {
+	switch {
+	case !grade.Fineness.IsSet() && grade.Karat.IsSet():
+		fineness, err := grade.Karat.Mul(maxFineness).Div(maxKarat, 1)
+		if err != nil {
+			return err
+		}
+		grade.Fineness = fineness
+	case grade.Fineness.IsSet() && !grade.Karat.IsSet():
+		karat, err := grade.Fineness.Mul(maxKarat).Div(maxFineness, 2)
+		if err != nil {
+			return err
+		}
+		grade.Karat = karat
+	}
+
+	if grade.Karat.IsSet() && (grade.Karat.Sign() <= 0 || grade.Karat.Cmp(maxKarat) > 0) {
+		return ErrInvalidPurity
+	}
+	if grade.Fineness.IsSet() && grade.Fineness.Cmp(maxFineness) > 0 {
+		return ErrInvalidPurity
+	}
+
+	return nil
+}
+
+// Describe checks that a record of mineral is described the way the mineral
+// is graded and returns the mineral and grade codes to store. A mineral
+// graded by fineness needs one of its grades; one graded by quality needs
+// the stone's quality, and its colour and clarity become the grade. When
+// gradeOptional is set a fineness grade may be left out.
+func Describe(ctx context.Context, mineral string, grade string, quality *models.Quality, gradeOptional bool) (string, string, error) {
+	found, err := MineralFor(ctx, mineral)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch found.Grading {
+	case GradingQuality:
+		if quality == nil {
+			if gradeOptional {
+				return found.Code, "", nil
+			}
+			return "", "", ErrQualityRequired
+		}
+		return found.Code, quality.Colour + "/" + quality.Clarity, nil
+	default:
+		if quality != nil {
+			return "", "", ErrNoQuality
+		}
+		if strings.TrimSpace(grade) == "" {
+			if gradeOptional {
+				return found.Code, "", nil
+			}
+			return "", "", ErrGradeRequired
+		}
+
+		foundGrade, err := GradeFor(ctx, found.Code, grade)
+		if err != nil {
+			return "", "", err
+		}
+		return found.Code, foundGrade.Code, nil
+	}
+}
@@ -0,0 +1,133 @@
+package catalogue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNoDensityTable   = errors.New("no density table")
+	ErrInvalidWeights   = errors.New("water weight must be below air weight")
+	ErrDensityTooLow    = errors.New("density is below the density table")
+	ErrDensityNotGraded = errors.New("only minerals graded by fineness can be density tested")
+)
+
+// precision of the worked out figures
+const (
+	densityPlaces    = 3
+	finenessPlaces   = 1
+	fineWeightPlaces = 4
+)
+
+// DensityTable lists the density table of a mineral, densest first.
+func DensityTable(ctx context.Context, mineral string) ([]models.DensityPoint, error) {
+	cursor, err := database.Database.Collection(models.Collection.Density).Find(ctx,
+		bson.M{"mineral": mineral},
+		options.Find().SetSort(bson.D{{Key: "density", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []models.DensityPoint{}
+	if err := cursor.All(ctx, &points); err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// FinenessAt reads the fineness of an alloy of the given density off a
+// table sorted densest first, interpolating between its lines. Anything
+// denser than the first line has its fineness.
+func FinenessAt(table []models.DensityPoint, density models.Decimal) (models.Decimal, error) {
+	if len(table) == 0 {
+		return models.Decimal{}, ErrNoDensityTable
+	}
+
+	if density.Cmp(table[0].Density) >= 0 {
+		return table[0].Fineness, nil
+	}
+
+	for i := 1; i < len(table); i++ {
+		upper, lower := table[i-1], table[i]
+		if density.Cmp(lower.Density) < 0 {
+			continue
+		}
+
+		// straight line between the two lines around the density
+		share, err := density.Sub(lower.Density).Div(upper.Density.Sub(lower.Density), 6)
+		if err != nil {
+			return models.Decimal{}, err
+		}
+		return lower.Fineness.Add(upper.Fineness.Sub(lower.Fineness).Mul(share)).Round(finenessPlaces), nil
+	}
+
+	return models.Decimal{}, fmt.Errorf("%w, its lowest line is %s", ErrDensityTooLow, table[len(table)-1].Density)
+}
+
+// MeasurePurity works out the density, fineness, karat and fine weight of a
+// density test of mineral; weight is the weight the fine weight is taken of.
+func MeasurePurity(ctx context.Context, mineral string, purity *models.Purity, weight models.Decimal) error {
+	found, err := MineralFor(ctx, mineral)
+	if err != nil {
+		return err
+	}
+	if found.Grading != GradingFineness {
+		return ErrDensityNotGraded
+	}
+
+	displaced := purity.AirWeight.Sub(purity.WaterWeight)
+	if displaced.Sign() <= 0 {
+		return ErrInvalidWeights
+	}
+
+	purity.Density, err = purity.AirWeight.Div(displaced, densityPlaces)
+	if err != nil {
+		return err
+	}
+
+	table, err := DensityTable(ctx, found.Code)
+	if err != nil {
+		return err
+	}
+
+	purity.Fineness, err = FinenessAt(table, purity.Density)
+	if err != nil {
+		return err
+	}
+
+	purity.Karat, err = purity.Fineness.Mul(maxKarat).Div(maxFineness, 2)
+	if err != nil {
+		return err
+	}
+
+	purity.FineWeight, err = weight.Mul(purity.Fineness).Div(maxFineness, fineWeightPlaces)
+	return err
+}
+
+// GradeForFineness finds the finest active grade of a mineral that an
+// alloy of the given fineness reaches.
+func GradeForFineness(ctx context.Context, mineral string, fineness models.Decimal) (*models.Grade, error) {
+	var grade models.Grade
+
+	err := database.Database.Collection(models.Collection.Grade).FindOne(ctx,
+		bson.M{"mineral": mineral, "active": true, "fineness": bson.M{"$lte": fineness}},
+		options.FindOne().SetSort(bson.D{{Key: "fineness", Value: -1}}),
+	).Decode(&grade)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w for %s at fineness %s", ErrUnknownGrade, mineral, fineness)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &grade, nil
+}
@@ -0,0 +1,104 @@
+// Command migrate upgrades documents written by older versions of the server.
+//
+//	go run ./cmd/migrate
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/lending"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	//connect to database
+	database.Init()
+
+	defer func() {
+		if err := database.Client.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+
+	// money and weight strings -> Decimal128
+	if err := database.MigrateDecimalFields(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// records without a branch -> default branch
+	branchID, err := database.MigrateDefaultBranch(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// records without a currency -> default currency
+	if err := database.MigrateDefaultCurrency(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// hard-coded scales -> scale collection
+	if err := database.MigrateDefaultScales(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// free-text minerals -> mineral catalogue
+	if err := database.MigrateMineralCatalogue(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// paper density chart -> density table
+	if err := database.MigrateDensityTable(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// one-shot stashes -> shipped stashes
+	if err := database.MigrateStashStatus(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// balance document -> opening ledger entry of the default branch
+	if err := ledger.PostOpeningBalance(context.TODO(), branchID); err != nil {
+		log.Fatal(err)
+	}
+
+	// past buys, sells and stashes -> opening stock
+	if err := inventory.PostOpeningStock(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// loose credit and payoff records -> loan accounts
+	if err := lending.OpenLegacyAccounts(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// loan accounts without a schedule -> empty schedule, next due date set
+	if err := database.MigrateLoanSchedule(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// loan accounts without pledges -> unsecured
+	if err := database.MigrateLoanPledges(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// serials of voided bars -> freed, serial index -> unique
+	if err := database.MigrateBarSerials(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	// buys, sells and pledges -> the factor of the scale they were weighed on
+	if err := database.MigrateScaleFactors(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("[ MIGRATE ] done")
+}
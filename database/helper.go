@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
@@ -16,6 +17,29 @@ func InsertDocument(collection string, data primitive.D) (*mongo.InsertOneResult
 	return Database.Collection(collection).InsertOne(context.TODO(), data)
 }
 
+// InsertDocumentContext is InsertDocument bound to ctx, typically the session
+// context handed out by WithTransaction.
+func InsertDocumentContext(ctx context.Context, collection string, data primitive.D) (*mongo.InsertOneResult, error) {
+	return Database.Collection(collection).InsertOne(ctx, data)
+}
+
+// WithTransaction runs fn in a multi-document transaction. Everything fn does
+// through the session context is committed together when it returns nil and
+// rolled back when it returns an error. Transient errors are retried by the
+// driver, so fn must be safe to run more than once.
+func WithTransaction(ctx context.Context, fn func(sessionContext mongo.SessionContext) error) error {
+	session, err := Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionContext)
+	})
+	return err
+}
+
 func InsertManyDocument(collection string, data []interface{}) (*mongo.InsertManyResult, error) {
 	return Database.Collection(collection).InsertMany(context.Background(), data)
 }
@@ -66,8 +90,15 @@ func FindDocumentsQuery(collection string, filter primitive.D, pageSize int, off
 }
 
 func DeleteDocument(collection string, id string) *mongo.SingleResult {
+	objectId, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		log.Printf("Invalid id: %v", err)
+		return nil
+	}
+
 	return Database.Collection(collection).FindOneAndDelete(context.TODO(), bson.D{{
-		Key: "_id", Value: id,
+		Key: "_id", Value: objectId,
 	}})
 }
 func DeleteDocuments(collection string, filter primitive.D) (*mongo.DeleteResult, error) {
@@ -150,19 +181,61 @@ func GetAllLoansWithAssociatesAndCustomers(filter bson.D, pageSize int, offset i
 	return loans, nil
 }
 
-func SumDocuments(collectionName string, filter bson.M, field string, result *primitive.Decimal128) error {
+// ErrUnparseable is returned by the sums when records hold an amount or
+// weight that is not a number; the decimal migration reports them to be fixed
+// by hand.
+var ErrUnparseable = errors.New("records hold amounts or weights that are not numbers")
+
+// decimalField converts a stored amount to Decimal128, treating strings left
+// over from before the decimal migration alike; a missing value is zero and
+// one that cannot be parsed is null, so $sum leaves it out.
+func decimalField(field string) bson.M {
+	return bson.M{"$convert": bson.M{
+		"input":   "$" + field,
+		"to":      "decimal",
+		"onError": nil,
+		"onNull":  models.NewDecimal(0, 0),
+	}}
+}
+
+// unparseable is 1 for a record whose field cannot be parsed as a number
+// and 0 otherwise, to be summed in a $group.
+func unparseable(field string) bson.M {
+	return bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{decimalField(field), nil}}, 1, 0}}
+}
+
+func checkParsed(unparseable int64, collectionName string) error {
+	if unparseable > 0 {
+		return fmt.Errorf("%w: %d in %s", ErrUnparseable, unparseable, collectionName)
+	}
+	return nil
+}
+
+// ExcludeVoided returns a copy of filter that also skips voided records,
+// unless filter already says something about them.
+func ExcludeVoided(filter bson.M) bson.M {
+	result := bson.M{"void": bson.M{"$exists": false}}
+	for key, value := range filter {
+		result[key] = value
+	}
+	return result
+}
+
+// SumDocuments sums field over the records matching filter, leaving out
+// voided ones.
+func SumDocuments(collectionName string, filter bson.M, field string, result *models.Decimal) error {
 	collection := Database.Collection(collectionName)
 
-	// Aggregation pipeline to convert the string amount to double and sum it
+	// Aggregation pipeline to sum the decimal amount
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: filter}},
-		{{Key: "$addFields", Value: bson.M{
-			"numericAmount": bson.M{"$toDouble": "$" + field}, // Convert string field to double
-		}}},
+		{{Key: "$match", Value: ExcludeVoided(filter)}},
 		{{Key: "$group", Value: bson.M{
-			"_id":   nil,
-			"total": bson.M{"$sum": "$numericAmount"}, // Sum the numeric amount
+			"_id":         nil,
+			"total":       bson.M{"$sum": decimalField(field)},
+			"unparseable": bson.M{"$sum": unparseable(field)},
 		}}},
+		// a group of nothing but unparseable values sums to the integer 0
+		{{Key: "$set", Value: bson.M{"total": bson.M{"$toDecimal": "$total"}}}},
 	}
 
 	cursor, err := collection.Aggregate(context.TODO(), pipeline)
@@ -173,101 +246,89 @@ func SumDocuments(collectionName string, filter bson.M, field string, result *pr
 
 	if cursor.Next(context.TODO()) {
 		var aggregationResult struct {
-			Total interface{} `bson:"total"`
+			Total       models.Decimal `bson:"total"`
+			Unparseable int64          `bson:"unparseable"`
 		}
 		err = cursor.Decode(&aggregationResult)
 		if err != nil {
 			return err
 		}
-
-		// Handle different types for the sum result (int32, int64, float64)
-		switch v := aggregationResult.Total.(type) {
-		case int32:
-			*result, err = primitive.ParseDecimal128(fmt.Sprintf("%d", v))
-		case int64:
-			*result, err = primitive.ParseDecimal128(fmt.Sprintf("%d", v))
-		case float64:
-			*result, err = primitive.ParseDecimal128(fmt.Sprintf("%f", v))
-		default:
-			return fmt.Errorf("unexpected type for sum result: %T", v)
-		}
-
-		if err != nil {
+		if err := checkParsed(aggregationResult.Unparseable, collectionName); err != nil {
 			return err
 		}
+
+		*result = aggregationResult.Total
 	} else {
 		// No result, set the sum to 0
-		*result, _ = primitive.ParseDecimal128("0")
+		*result = models.NewDecimal(0, 0)
 	}
 
 	return nil
 }
 
-// SumAllScaleTransactions sums the total amount for all scale types ("BB", "Mini", "GB") with additional filters.
-func SumAllScaleTransactions(collectionName string, filter bson.M, field string, results map[string]primitive.Decimal128) error {
-	collection := Database.Collection(collectionName)
+// GradeTotal is the volume and value of one mineral and grade bought or sold in one currency.
+type GradeTotal struct {
+	Mineral  string         `json:"mineral" bson:"mineral"`
+	Grade    string         `json:"grade" bson:"grade"`
+	Kind     string         `json:"kind" bson:"kind"`
+	Currency string         `json:"currency" bson:"currency"`
+	Count    int64          `json:"count" bson:"count"`
+	Weight   models.Decimal `json:"weight" bson:"weight"`
+	Amount   models.Decimal `json:"amount" bson:"amount"`
+}
 
-	// Aggregation pipeline to filter, convert the string amount to double, and group by scaleType to sum
+// SumByMineralGrade sums the weight and amount of transactions per mineral, grade, kind and currency
+// with additional filters, leaving out voided transactions.
+func SumByMineralGrade(ctx context.Context, filter bson.M) ([]GradeTotal, error) {
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: filter}}, // Apply the additional filters
-		{{Key: "$addFields", Value: bson.M{
-			"numericAmount": bson.M{"$toDouble": "$" + field}, // Convert string field to double
-		}}},
+		{{Key: "$match", Value: ExcludeVoided(filter)}},
 		{{Key: "$group", Value: bson.M{
-			"_id":   bson.M{"$toLower": "$scale"},     // Group by scale type (BB, Mini, GB)
-			"total": bson.M{"$sum": "$numericAmount"}, // Sum the numeric amount per scale
+			"_id": bson.M{
+				"mineral":  bson.M{"$toLower": "$mineral"},
+				"grade":    bson.M{"$ifNull": bson.A{"$grade", ""}},
+				"kind":     "$kind",
+				"currency": bson.M{"$ifNull": bson.A{"$currency", models.DefaultCurrency}},
+			},
+			"count":       bson.M{"$sum": 1},
+			"weight":      bson.M{"$sum": decimalField("weight")},
+			"amount":      bson.M{"$sum": decimalField("amount")},
+			"unparseable": bson.M{"$sum": bson.M{"$add": bson.A{unparseable("weight"), unparseable("amount")}}},
 		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"mineral":     "$_id.mineral",
+			"grade":       "$_id.grade",
+			"kind":        "$_id.kind",
+			"currency":    "$_id.currency",
+			"count":       1,
+			"weight":      bson.M{"$toDecimal": "$weight"},
+			"amount":      bson.M{"$toDecimal": "$amount"},
+			"unparseable": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "mineral", Value: 1}, {Key: "grade", Value: 1}, {Key: "kind", Value: 1}}}},
 	}
 
-	cursor, err := collection.Aggregate(context.TODO(), pipeline)
+	cursor, err := Database.Collection(models.Collection.Transaction).Aggregate(ctx, pipeline)
 	if err != nil {
-		return err
-	}
-	defer cursor.Close(context.TODO())
-
-	// Loop through all the results and store them in the results map
-	for cursor.Next(context.TODO()) {
-		var aggregationResult struct {
-			ID    string      `bson:"_id"`   // scale type (BB, Mini, GB)
-			Total interface{} `bson:"total"` // sum result
-		}
-
-		err := cursor.Decode(&aggregationResult)
-		if err != nil {
-			return err
-		}
-
-		// Handle different types for the sum result (int32, int64, float64)
-		var result primitive.Decimal128
-		switch v := aggregationResult.Total.(type) {
-		case int32:
-			result, err = primitive.ParseDecimal128(fmt.Sprintf("%d", v))
-		case int64:
-			result, err = primitive.ParseDecimal128(fmt.Sprintf("%d", v))
-		case float64:
-			result, err = primitive.ParseDecimal128(fmt.Sprintf("%f", v))
-		default:
-			return fmt.Errorf("unexpected type for sum result: %T", v)
-		}
-
-		if err != nil {
-			return err
-		}
-
-		// Store the result in the map with scale type as the key
-		results[aggregationResult.ID] = result
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	// Handle the case where no results are returned, initializing all to 0 if absent
-	if _, ok := results["bb"]; !ok {
-		results["bb"], _ = primitive.ParseDecimal128("0")
+	var rows []struct {
+		GradeTotal  `bson:",inline"`
+		Unparseable int64 `bson:"unparseable"`
 	}
-	if _, ok := results["mini"]; !ok {
-		results["mini"], _ = primitive.ParseDecimal128("0")
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
 	}
-	if _, ok := results["gb"]; !ok {
-		results["gb"], _ = primitive.ParseDecimal128("0")
+
+	totals := make([]GradeTotal, 0, len(rows))
+	for _, row := range rows {
+		if err := checkParsed(row.Unparseable, models.Collection.Transaction); err != nil {
+			return nil, err
+		}
+		totals = append(totals, row.GradeTotal)
 	}
 
-	return nil
+	return totals, nil
 }
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists the indexes the server relies on, per collection.
+var indexes = map[string][]mongo.IndexModel{
+	// expired idempotency records are removed by MongoDB itself
+	models.Collection.Idempotency: {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	models.Collection.Scale: {
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	models.Collection.Mineral: {
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	models.Collection.BoardRate: {
+		{Keys: bson.D{{Key: "mineral", Value: 1}, {Key: "scale", Value: 1}, {Key: "effective_from", Value: -1}}},
+	},
+	models.Collection.Grade: {
+		{Keys: bson.D{{Key: "mineral", Value: 1}, {Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	models.Collection.Stash: {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_date", Value: -1}}},
+	},
+	models.Collection.Stock: {
+		{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "mineral", Value: 1}, {Key: "grade", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	models.Collection.StockMovement: {
+		{Keys: bson.D{{Key: "reference_id", Value: 1}}},
+		{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	models.Collection.Assay: {
+		{Keys: bson.D{{Key: "stash_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	},
+	models.Collection.MeltBatch: {
+		// a voided bar gives its serial up, so only live bars carry one
+		{Keys: bson.D{{Key: "serial", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	models.Collection.Loan: {
+		{Keys: bson.D{{Key: "account_id", Value: 1}}},
+	},
+	models.Collection.LoanAccount: {
+		{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "disbursed_at", Value: -1}}},
+		{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	// one close per associate, currency and day
+	models.Collection.TillClose: {
+		{Keys: bson.D{{Key: "associate_id", Value: 1}, {Key: "currency", Value: 1}, {Key: "date", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	models.Collection.Density: {
+		{Keys: bson.D{{Key: "mineral", Value: 1}, {Key: "density", Value: -1}}},
+	},
+}
+
+// EnsureIndexes creates any missing index; existing ones are left alone.
+func EnsureIndexes(ctx context.Context) error {
+	for collection, models := range indexes {
+		if _, err := Database.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
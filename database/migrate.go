@@ -0,0 +1,472 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DecimalFields lists, per collection, the money and weight fields that used
+// to be stored as strings.
+var DecimalFields = map[string][]string{
+	models.Collection.Transaction:   {"amount", "weight", "rate"},
+	models.Collection.Balance:       {"amount", "spent"},
+	models.Collection.Fund:          {"amount"},
+	models.Collection.Loan:          {"amount"},
+	models.Collection.Stash:         {"amount", "weight"},
+	models.Collection.Miscellaneous: {"amount"},
+}
+
+// MigrateDecimalFields converts string amounts and weights to Decimal128 in
+// place. Empty strings become 0; values that cannot be parsed are left as
+// strings and reported so they can be fixed by hand.
+func MigrateDecimalFields(ctx context.Context) error {
+	for collection, fields := range DecimalFields {
+		for _, field := range fields {
+			filter := bson.M{field: bson.M{"$type": "string"}}
+
+			// Pipeline update so each document is converted from its own value
+			update := mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{
+					field: bson.M{"$cond": bson.A{
+						bson.M{"$eq": bson.A{"$" + field, ""}},
+						models.NewDecimal(0, 0),
+						bson.M{"$convert": bson.M{
+							"input":   "$" + field,
+							"to":      "decimal",
+							"onError": "$" + field,
+						}},
+					}},
+				}}},
+			}
+
+			result, err := Database.Collection(collection).UpdateMany(ctx, filter, update)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s.%s: %w", collection, field, err)
+			}
+
+			remaining, err := Database.Collection(collection).CountDocuments(ctx, filter)
+			if err != nil {
+				return fmt.Errorf("failed to count %s.%s: %w", collection, field, err)
+			}
+
+			log.Printf("[ MIGRATE ] %s.%s: converted %d, unparseable %d", collection, field, result.ModifiedCount, remaining)
+		}
+	}
+
+	return nil
+}
+
+// BranchStamped lists the collections whose documents belong to a branch.
+var BranchStamped = []string{
+	models.Collection.Transaction,
+	models.Collection.Fund,
+	models.Collection.Loan,
+	models.Collection.Stash,
+	models.Collection.Miscellaneous,
+	models.Collection.Ledger,
+	models.Collection.Associate,
+}
+
+// MigrateDefaultBranch creates the "Main" branch if there are no branches yet
+// and stamps it on every document written before branches existed. It returns
+// the id of the default branch.
+func MigrateDefaultBranch(ctx context.Context) (primitive.ObjectID, error) {
+	var branch models.Branch
+
+	err := Database.Collection(models.Collection.Branch).FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})).Decode(&branch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		branch = models.Branch{
+			ID:        primitive.NewObjectID(),
+			Name:      "Main",
+			Code:      "MAIN",
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if _, err := Database.Collection(models.Collection.Branch).InsertOne(ctx, branch); err != nil {
+			return primitive.NilObjectID, fmt.Errorf("failed to create default branch: %w", err)
+		}
+		log.Printf("[ MIGRATE ] created branch %s (%s)", branch.Name, branch.ID.Hex())
+	} else if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	filter := bson.M{"branch_id": bson.M{"$in": bson.A{nil, primitive.NilObjectID}}}
+	update := bson.M{"$set": bson.M{"branch_id": branch.ID}}
+
+	for _, collection := range BranchStamped {
+		result, err := Database.Collection(collection).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("failed to stamp branch on %s: %w", collection, err)
+		}
+		log.Printf("[ MIGRATE ] %s: stamped branch on %d", collection, result.ModifiedCount)
+	}
+
+	return branch.ID, nil
+}
+
+// CurrencyStamped lists the collections whose documents carry a currency.
+var CurrencyStamped = []string{
+	models.Collection.Transaction,
+	models.Collection.Fund,
+	models.Collection.Loan,
+	models.Collection.Stash,
+	models.Collection.Miscellaneous,
+	models.Collection.FloatTransfer,
+	models.Collection.Ledger,
+	models.Collection.Balance,
+	models.Collection.Float,
+	models.Collection.BalanceHistory,
+	models.Collection.TillClose,
+}
+
+// MigrateDefaultCurrency stamps the default currency on every document
+// written before amounts carried one. The legacy balance document, which
+// has no branch, is left as it was.
+func MigrateDefaultCurrency(ctx context.Context) error {
+	update := bson.M{"$set": bson.M{"currency": models.DefaultCurrency}}
+
+	for _, collection := range CurrencyStamped {
+		filter := bson.M{"currency": bson.M{"$in": bson.A{nil, ""}}}
+		if collection == models.Collection.Balance {
+			filter["branch_id"] = bson.M{"$exists": true}
+		}
+
+		result, err := Database.Collection(collection).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to stamp currency on %s: %w", collection, err)
+		}
+		log.Printf("[ MIGRATE ] %s: stamped currency on %d", collection, result.ModifiedCount)
+	}
+
+	return nil
+}
+
+// MigrateDefaultScales adds the bb, mini and gb scales with the margins the
+// server used to hard-code when no scale exists yet.
+func MigrateDefaultScales(ctx context.Context) error {
+	count, err := Database.Collection(models.Collection.Scale).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	defaults := []struct {
+		code   string
+		name   string
+		margin string
+	}{
+		{"bb", "BB", "0.1581"},
+		{"mini", "Mini", "0.0928"},
+		{"gb", "GB", "0"},
+	}
+
+	for _, scale := range defaults {
+		_, err := Database.Collection(models.Collection.Scale).InsertOne(ctx, models.Scale{
+			ID:        primitive.NewObjectID(),
+			Code:      scale.code,
+			Name:      scale.name,
+			Unit:      "g",
+			Factor:    models.NewDecimal(1, 0),
+			Margin:    models.MustParseDecimal(scale.margin),
+			Active:    true,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create scale %s: %w", scale.code, err)
+		}
+		log.Printf("[ MIGRATE ] created scale %s", scale.code)
+	}
+
+	return nil
+}
+
+// MigrateMineralCatalogue adds gold, with its common karat grades, and
+// diamond to an empty catalogue, and turns the free-text minerals of older
+// transactions and stashes into catalogue codes.
+func MigrateMineralCatalogue(ctx context.Context) error {
+	count, err := Database.Collection(models.Collection.Mineral).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		minerals := []models.Mineral{
+			{ID: primitive.NewObjectID(), Code: "gold", Name: "Gold", Unit: "g", Grading: "fineness", Active: true, CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			{ID: primitive.NewObjectID(), Code: "diamond", Name: "Diamond", Unit: "ct", Grading: "quality", Active: true, CreatedAt: time.Now(), UpdatedAt: time.Now()},
+		}
+		for _, mineral := range minerals {
+			if _, err := Database.Collection(models.Collection.Mineral).InsertOne(ctx, mineral); err != nil {
+				return fmt.Errorf("failed to create mineral %s: %w", mineral.Code, err)
+			}
+			log.Printf("[ MIGRATE ] created mineral %s", mineral.Code)
+		}
+
+		grades := []struct {
+			code     string
+			karat    int64
+			fineness int64
+		}{
+			{"24k", 24, 999},
+			{"22k", 22, 916},
+			{"21k", 21, 875},
+			{"18k", 18, 750},
+			{"14k", 14, 585},
+			{"9k", 9, 375},
+		}
+		for _, grade := range grades {
+			_, err := Database.Collection(models.Collection.Grade).InsertOne(ctx, models.Grade{
+				ID:        primitive.NewObjectID(),
+				Mineral:   "gold",
+				Code:      grade.code,
+				Name:      fmt.Sprintf("%d karat", grade.karat),
+				Karat:     models.NewDecimal(grade.karat, 0),
+				Fineness:  models.NewDecimal(grade.fineness, 0),
+				Active:    true,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create grade %s: %w", grade.code, err)
+			}
+		}
+		log.Printf("[ MIGRATE ] created %d gold grades", len(grades))
+	}
+
+	normalize := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"mineral": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$mineral"}}}}}},
+	}
+
+	for _, collection := range []string{models.Collection.Transaction, models.Collection.Stash} {
+		result, err := Database.Collection(collection).UpdateMany(ctx, bson.M{"mineral": bson.M{"$type": "string"}}, normalize)
+		if err != nil {
+			return fmt.Errorf("failed to normalize minerals on %s: %w", collection, err)
+		}
+		log.Printf("[ MIGRATE ] %s: normalized mineral on %d", collection, result.ModifiedCount)
+	}
+
+	return nil
+}
+
+// MigrateDensityTable seeds the gold density table used by density tests
+// when it is empty.
+func MigrateDensityTable(ctx context.Context) error {
+	count, err := Database.Collection(models.Collection.Density).CountDocuments(ctx, bson.M{"mineral": "gold"})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	// density in g/cm³ (3 places) -> fineness of yellow gold
+	points := []struct {
+		density  int64
+		fineness int64
+	}{
+		{19320, 999},
+		{17800, 916},
+		{17000, 875},
+		{15600, 750},
+		{13400, 585},
+		{11300, 375},
+	}
+	for _, point := range points {
+		_, err := Database.Collection(models.Collection.Density).InsertOne(ctx, models.DensityPoint{
+			ID:        primitive.NewObjectID(),
+			Mineral:   "gold",
+			Density:   models.NewDecimal(point.density, -3),
+			Fineness:  models.NewDecimal(point.fineness, 0),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create density point %d: %w", point.density, err)
+		}
+	}
+	log.Printf("[ MIGRATE ] created %d gold density points", len(points))
+
+	return nil
+}
+
+// MigrateStashStatus puts the stashes made before stashes had a workflow at
+// shipped: they were dispatched when made, so they can still be received
+// and settled.
+func MigrateStashStatus(ctx context.Context) error {
+	result, err := Database.Collection(models.Collection.Stash).UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"status":          "shipped",
+				"transaction_ids": bson.A{},
+				"shipped_weight":  "$weight",
+				"steps":           bson.A{bson.M{"status": "shipped", "weight": "$weight", "associate_id": "$associate_id", "at": "$created_date"}},
+			}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set stash status: %w", err)
+	}
+	log.Printf("[ MIGRATE ] stash: set status on %d", result.ModifiedCount)
+
+	return nil
+}
+
+// MigrateLoanSchedule gives loan accounts opened before schedules were kept
+// an empty schedule and the due date of the loan as the next one, and counts
+// what they repaid as principal.
+func MigrateLoanSchedule(ctx context.Context) error {
+	zero := models.NewDecimal(0, 0)
+
+	result, err := Database.Collection(models.Collection.LoanAccount).UpdateMany(ctx,
+		bson.M{"schedule": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"schedule":       bson.A{},
+				"fees":           zero,
+				"interest":       zero,
+				"fees_paid":      zero,
+				"interest_paid":  zero,
+				"principal_paid": "$repaid",
+				"next_due_at":    bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", "active"}}, "$due_at", "$$REMOVE"}},
+			}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set loan schedules: %w", err)
+	}
+	log.Printf("[ MIGRATE ] loan_account: set schedule on %d", result.ModifiedCount)
+
+	return nil
+}
+
+// MigrateLoanPledges gives loan accounts opened before collateral was kept
+// an empty list of pledges.
+func MigrateLoanPledges(ctx context.Context) error {
+	result, err := Database.Collection(models.Collection.LoanAccount).UpdateMany(ctx,
+		bson.M{"pledges": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pledges": bson.A{}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set loan pledges: %w", err)
+	}
+	log.Printf("[ MIGRATE ] loan_account: set pledges on %d", result.ModifiedCount)
+
+	return nil
+}
+
+// MigrateBarSerials frees the serials of voided bars and drops the old
+// serial index that did not keep serials unique, so EnsureIndexes can make
+// a unique one. Live bars sharing a serial are reported to be fixed by hand.
+func MigrateBarSerials(ctx context.Context) error {
+	collection := Database.Collection(models.Collection.MeltBatch)
+
+	result, err := collection.UpdateMany(ctx,
+		bson.M{"void": bson.M{"$exists": true}, "serial": bson.M{"$exists": true}},
+		bson.M{"$rename": bson.M{"serial": "voided_serial"}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to free voided bar serials: %w", err)
+	}
+	log.Printf("[ MIGRATE ] melt_batch: freed the serial of %d voided bars", result.ModifiedCount)
+
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list melt batch indexes: %w", err)
+	}
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index["name"] != "serial_1" || index["unique"] == true {
+			continue
+		}
+		if _, err := collection.Indexes().DropOne(ctx, "serial_1"); err != nil {
+			return fmt.Errorf("failed to drop the melt batch serial index: %w", err)
+		}
+		log.Printf("[ MIGRATE ] melt_batch: dropped the serial index that was not unique")
+	}
+
+	cursor, err = collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"serial": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$serial", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to look for shared bar serials: %w", err)
+	}
+
+	var shared []bson.M
+	if err := cursor.All(ctx, &shared); err != nil {
+		return err
+	}
+	for _, serial := range shared {
+		log.Printf("[ MIGRATE ] melt_batch: serial %v is on %v live bars, give all but one a new serial", serial["_id"], serial["count"])
+	}
+
+	return nil
+}
+
+// MigrateScaleFactors stamps the buys, sells and pledges recorded before
+// the factor they were weighed with was kept with their scale's current
+// factor, so a later change to a scale's factor leaves them as they are.
+// Bar sales are weighed in the mineral's unit and get a factor of 1.
+func MigrateScaleFactors(ctx context.Context) error {
+	result, err := Database.Collection(models.Collection.Transaction).UpdateMany(ctx,
+		bson.M{"bar": bson.M{"$exists": true}, "factor": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"factor": models.NewDecimal(1, 0)}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set the factor of bar sales: %w", err)
+	}
+	log.Printf("[ MIGRATE ] transaction: set the factor on %d bar sales", result.ModifiedCount)
+
+	cursor, err := Database.Collection(models.Collection.Scale).Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	var scales []models.Scale
+	if err := cursor.All(ctx, &scales); err != nil {
+		return err
+	}
+
+	for _, scale := range scales {
+		result, err := Database.Collection(models.Collection.Transaction).UpdateMany(ctx,
+			bson.M{"scale": scale.Code, "factor": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"factor": scale.Factor}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to set the factor of %s transactions: %w", scale.Code, err)
+		}
+		log.Printf("[ MIGRATE ] transaction: set the %s factor on %d", scale.Code, result.ModifiedCount)
+
+		result, err = Database.Collection(models.Collection.LoanAccount).UpdateMany(ctx,
+			bson.M{"pledges": bson.M{"$elemMatch": bson.M{"scale": scale.Code, "factor": bson.M{"$exists": false}}}},
+			bson.M{"$set": bson.M{"pledges.$[pledge].factor": scale.Factor}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: bson.A{
+				bson.M{"pledge.scale": scale.Code, "pledge.factor": bson.M{"$exists": false}},
+			}}),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to set the factor of %s pledges: %w", scale.Code, err)
+		}
+		log.Printf("[ MIGRATE ] loan_account: set the %s factor on pledges of %d", scale.Code, result.ModifiedCount)
+	}
+
+	return nil
+}
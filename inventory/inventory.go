@@ -0,0 +1,366 @@
+// Package inventory keeps track of the minerals physically held at each
+// branch. Every buy, sell and stash moves stock by weight and cost through a
+// stock movement; the stock documents are only a cache of those movements.
+// Cost is carried at the weighted average of what the stock was bought for.
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/catalogue"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of stock movement
+const (
+	KindBuy     = "buy"
+	KindSell    = "sell"
+	KindStash   = "stash"
+	KindMelt    = "melt"    // weight melted down, and the bar it became
+	KindForfeit = "forfeit" // collateral taken for a loan in default
+)
+
+// BarGrade is the grade a bar is stocked under, so each bar is held and
+// sold on its own.
+func BarGrade(serial string) string {
+	return "bar:" + serial
+}
+
+// ErrInsufficientStock is returned when a movement would take stock below zero.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// precision of weights and costs moved
+const (
+	weightPlaces = 4
+	costPlaces   = 2
+)
+
+// Source identifies the business document behind a stock movement.
+type Source struct {
+	AssociateID   primitive.ObjectID
+	BranchID      primitive.ObjectID
+	ReferenceID   primitive.ObjectID
+	ReferenceType string
+	OccurredAt    time.Time // when the business event happened, now when unset
+}
+
+// FineWeightOf is the pure content of weight of a mineral grade: the weight
+// at the grade's fineness, or the weight itself for minerals not graded by
+// fineness and records without a grade.
+func FineWeightOf(ctx context.Context, mineral string, grade string, weight models.Decimal) (models.Decimal, error) {
+	found, err := catalogue.MineralFor(ctx, mineral)
+	if err != nil {
+		return models.Decimal{}, err
+	}
+	if found.Grading != catalogue.GradingFineness || grade == "" {
+		return weight, nil
+	}
+
+	foundGrade, err := catalogue.GradeFor(ctx, found.Code, grade)
+	if err != nil {
+		return models.Decimal{}, err
+	}
+
+	return weight.Mul(foundGrade.Fineness).Div(models.NewDecimal(1000, 0), weightPlaces)
+}
+
+// Receive adds weight of a mineral grade bought for cost in currency to the
+// stock of the source's branch. The cost is carried in the default currency,
+// converted at the rate in force when the stock came in.
+func Receive(ctx context.Context, kind string, mineral string, grade string, weight models.Decimal, fineWeight models.Decimal, cost models.Decimal, currency string, source Source) (*models.StockMovement, error) {
+	movement := newMovement(kind, mineral, grade, source)
+
+	if currency != "" && currency != models.DefaultCurrency {
+		rate, err := ledger.RateAt(ctx, currency, models.DefaultCurrency, movement.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		cost = cost.Mul(rate)
+	}
+
+	movement.Weight = weight
+	movement.FineWeight = fineWeight
+	movement.Cost = cost.Round(costPlaces)
+
+	if err := apply(ctx, movement); err != nil {
+		return nil, err
+	}
+
+	return movement, nil
+}
+
+// Issue takes weight of a mineral grade out of the stock of the source's
+// branch, along with its share of the fine weight and cost.
+func Issue(ctx context.Context, kind string, mineral string, grade string, weight models.Decimal, source Source) (*models.StockMovement, error) {
+	stock, err := StockFor(ctx, source.BranchID, mineral, grade)
+	if err != nil {
+		return nil, err
+	}
+	if stock.Weight.Cmp(weight) < 0 {
+		return nil, fmt.Errorf("%w: %s of %s %s held, %s asked for", ErrInsufficientStock, stock.Weight, mineral, grade, weight)
+	}
+
+	movement := newMovement(kind, mineral, grade, source)
+	movement.Weight = weight.Neg()
+
+	// the last of the stock takes all that is left so nothing is stranded
+	// by rounding
+	if stock.Weight.Cmp(weight) == 0 {
+		movement.FineWeight = stock.FineWeight.Neg()
+		movement.Cost = stock.Cost.Neg()
+	} else {
+		fineWeight, err := stock.FineWeight.Mul(weight).Div(stock.Weight, weightPlaces)
+		if err != nil {
+			return nil, err
+		}
+		cost, err := stock.Cost.Mul(weight).Div(stock.Weight, costPlaces)
+		if err != nil {
+			return nil, err
+		}
+		movement.FineWeight = fineWeight.Neg()
+		movement.Cost = cost.Neg()
+	}
+
+	if err := apply(ctx, movement); err != nil {
+		return nil, err
+	}
+
+	return movement, nil
+}
+
+// IssueMixed takes weight of a mineral out of the stock of the source's
+// branch when its grades are not told apart, drawing on every grade held in
+// proportion to its weight. Bars are only ever taken whole, so are left out.
+func IssueMixed(ctx context.Context, kind string, mineral string, weight models.Decimal, source Source) ([]models.StockMovement, error) {
+	stocks, err := Stocks(ctx, bson.M{
+		"branch_id": source.BranchID,
+		"mineral":   mineral,
+		"grade":     bson.M{"$not": primitive.Regex{Pattern: "^" + BarGrade("")}},
+		"weight":    bson.M{"$gt": models.NewDecimal(0, 0)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	held := models.NewDecimal(0, 0)
+	for _, stock := range stocks {
+		held = held.Add(stock.Weight)
+	}
+	if held.Cmp(weight) < 0 {
+		return nil, fmt.Errorf("%w: %s of %s held, %s asked for", ErrInsufficientStock, held, mineral, weight)
+	}
+
+	movements := []models.StockMovement{}
+	remaining := weight
+
+	for i, stock := range stocks {
+		share := remaining
+		if i < len(stocks)-1 {
+			share, err = weight.Mul(stock.Weight).Div(held, weightPlaces)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if share.Cmp(stock.Weight) > 0 {
+			share = stock.Weight
+		}
+		if share.Sign() <= 0 {
+			continue
+		}
+
+		movement, err := Issue(ctx, kind, mineral, stock.Grade, share, source)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, *movement)
+		remaining = remaining.Sub(share)
+	}
+
+	return movements, nil
+}
+
+// Reverse takes back every stock movement a document made, as when it is
+// voided.
+func Reverse(ctx context.Context, referenceID primitive.ObjectID, associateID primitive.ObjectID) ([]models.StockMovement, error) {
+	cursor, err := database.Database.Collection(models.Collection.StockMovement).Find(ctx, bson.M{
+		"reference_id": referenceID,
+		"reversal_of":  bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var movements []models.StockMovement
+	if err := cursor.All(ctx, &movements); err != nil {
+		return nil, err
+	}
+
+	reversals := []models.StockMovement{}
+
+	for _, movement := range movements {
+		reversal := movement
+		reversal.ID = primitive.NewObjectID()
+		reversal.Weight = movement.Weight.Neg()
+		reversal.FineWeight = movement.FineWeight.Neg()
+		reversal.Cost = movement.Cost.Neg()
+		reversal.AssociateID = associateID
+		reversal.ReversalOf = movement.ID
+		reversal.CreatedAt = time.Now()
+
+		if err := apply(ctx, &reversal); err != nil {
+			return nil, err
+		}
+		reversals = append(reversals, reversal)
+	}
+
+	return reversals, nil
+}
+
+// PostCost moves the cost of stock that left a branch out of Inventory in
+// the ledger under event, in the default currency the cost is carried in.
+// Run it in the same transaction as the movements.
+func PostCost(ctx context.Context, event string, source Source, movements ...models.StockMovement) error {
+	cost := models.NewDecimal(0, 0)
+	for _, movement := range movements {
+		cost = cost.Sub(movement.Cost)
+	}
+	if cost.Sign() <= 0 {
+		return nil
+	}
+
+	_, err := ledger.Record(ctx, event, cost, ledger.Source{
+		AssociateID:   source.AssociateID,
+		BranchID:      source.BranchID,
+		ReferenceID:   source.ReferenceID,
+		ReferenceType: source.ReferenceType,
+		OccurredAt:    source.OccurredAt,
+		Currency:      models.DefaultCurrency,
+	})
+	return err
+}
+
+// CostOf is the cost a document took out of stock, net of any reversals.
+func CostOf(ctx context.Context, referenceID primitive.ObjectID) (models.Decimal, error) {
+	cursor, err := database.Database.Collection(models.Collection.StockMovement).Find(ctx, bson.M{"reference_id": referenceID})
+	if err != nil {
+		return models.Decimal{}, err
+	}
+
+	var movements []models.StockMovement
+	if err := cursor.All(ctx, &movements); err != nil {
+		return models.Decimal{}, err
+	}
+
+	cost := models.NewDecimal(0, 0)
+	for _, movement := range movements {
+		cost = cost.Sub(movement.Cost)
+	}
+
+	return cost, nil
+}
+
+// StockFor returns the stock of one mineral grade at a branch, nothing held
+// when it has never been stocked.
+func StockFor(ctx context.Context, branchID primitive.ObjectID, mineral string, grade string) (*models.Stock, error) {
+	var stock models.Stock
+
+	err := database.Database.Collection(models.Collection.Stock).FindOne(ctx, stockKey(branchID, mineral, grade)).Decode(&stock)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		zero := models.NewDecimal(0, 0)
+		return &models.Stock{BranchID: branchID, Mineral: mineral, Grade: grade, Weight: zero, FineWeight: zero, Cost: zero, Currency: models.DefaultCurrency}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &stock, nil
+}
+
+// Stocks lists the stock matching filter by branch, mineral and grade.
+func Stocks(ctx context.Context, filter bson.M) ([]models.Stock, error) {
+	cursor, err := database.Database.Collection(models.Collection.Stock).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "branch_id", Value: 1}, {Key: "mineral", Value: 1}, {Key: "grade", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	stocks := []models.Stock{}
+	if err := cursor.All(ctx, &stocks); err != nil {
+		return nil, err
+	}
+
+	return stocks, nil
+}
+
+func newMovement(kind string, mineral string, grade string, source Source) *models.StockMovement {
+	occurredAt := source.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+
+	return &models.StockMovement{
+		ID:            primitive.NewObjectID(),
+		Kind:          kind,
+		BranchID:      source.BranchID,
+		Mineral:       mineral,
+		Grade:         grade,
+		Currency:      models.DefaultCurrency,
+		ReferenceID:   source.ReferenceID,
+		ReferenceType: source.ReferenceType,
+		AssociateID:   source.AssociateID,
+		CreatedAt:     occurredAt,
+	}
+}
+
+func stockKey(branchID primitive.ObjectID, mineral string, grade string) bson.D {
+	return bson.D{{Key: "branch_id", Value: branchID}, {Key: "mineral", Value: mineral}, {Key: "grade", Value: grade}}
+}
+
+// apply moves the cached stock by a movement and stores it. Stock going out
+// is checked and taken in a single conditional update, like the till, so
+// two sales can never take the same weight.
+func apply(ctx context.Context, movement *models.StockMovement) error {
+	filter := stockKey(movement.BranchID, movement.Mineral, movement.Grade)
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{
+			{Key: "weight", Value: movement.Weight},
+			{Key: "fine_weight", Value: movement.FineWeight},
+			{Key: "cost", Value: movement.Cost},
+		}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "currency", Value: movement.Currency}, {Key: "created_at", Value: time.Now()}}},
+	}
+
+	if movement.Weight.Sign() >= 0 {
+		_, err := database.Database.Collection(models.Collection.Stock).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to update stock: %w", err)
+		}
+	} else {
+		filter = append(filter, bson.E{Key: "weight", Value: bson.M{"$gte": movement.Weight.Neg()}})
+
+		result, err := database.Database.Collection(models.Collection.Stock).UpdateOne(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to update stock: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w of %s %s", ErrInsufficientStock, movement.Mineral, movement.Grade)
+		}
+	}
+
+	if _, err := database.Database.Collection(models.Collection.StockMovement).InsertOne(ctx, movement); err != nil {
+		return fmt.Errorf("failed to record stock movement: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package inventory
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// past is a buy, sell or stash recorded before stock was kept
+type past struct {
+	kind          string
+	at            time.Time
+	branchID      primitive.ObjectID
+	associateID   primitive.ObjectID
+	referenceID   primitive.ObjectID
+	referenceType string
+	mineral       string
+	grade         string
+	weight        models.Decimal
+	fineWeight    models.Decimal
+	amount        models.Decimal
+	currency      string
+}
+
+// PostOpeningStock builds the stock from the buys, sells and stashes
+// recorded before stock was kept, replaying them in the order they happened.
+// Weight that left without ever having been bought is logged and skipped.
+// It does nothing once stock has moved.
+func PostOpeningStock(ctx context.Context) error {
+	count, err := database.Database.Collection(models.Collection.StockMovement).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	factors := map[string]models.Decimal{}
+	cursor, err := database.Database.Collection(models.Collection.Scale).Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var scales []models.Scale
+	if err := cursor.All(ctx, &scales); err != nil {
+		return err
+	}
+	for _, scale := range scales {
+		factors[scale.Code] = scale.Factor
+	}
+
+	records := []past{}
+
+	cursor, err = database.Database.Collection(models.Collection.Transaction).Find(ctx, database.ExcludeVoided(bson.M{}))
+	if err != nil {
+		return err
+	}
+	var transactions []models.Transaction
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return err
+	}
+	for _, transaction := range transactions {
+		weight := transaction.Weight
+		if transaction.Factor.IsSet() {
+			weight = weight.Mul(transaction.Factor)
+		} else if factor, ok := factors[transaction.Scale]; ok {
+			weight = weight.Mul(factor)
+		}
+
+		fineWeight, err := FineWeightOf(ctx, transaction.Mineral, transaction.Grade, weight)
+		if err != nil {
+			fineWeight = weight
+		}
+		if transaction.Purity != nil && transaction.Purity.FineWeight.IsSet() {
+			fineWeight = transaction.Purity.FineWeight
+		}
+
+		records = append(records, past{
+			kind:          transaction.Kind,
+			at:            transaction.CreatedAt,
+			branchID:      transaction.BranchID,
+			associateID:   transaction.AssociateID,
+			referenceID:   transaction.ID,
+			referenceType: models.Collection.Transaction,
+			mineral:       transaction.Mineral,
+			grade:         transaction.Grade,
+			weight:        weight,
+			fineWeight:    fineWeight,
+			amount:        transaction.Amount,
+			currency:      transaction.Currency,
+		})
+	}
+
+	cursor, err = database.Database.Collection(models.Collection.Stash).Find(ctx, database.ExcludeVoided(bson.M{}))
+	if err != nil {
+		return err
+	}
+	var stashes []models.Stash
+	if err := cursor.All(ctx, &stashes); err != nil {
+		return err
+	}
+	for _, stash := range stashes {
+		records = append(records, past{
+			kind:          KindStash,
+			at:            stash.CreatedAt,
+			branchID:      stash.BranchID,
+			associateID:   stash.AssociateID,
+			referenceID:   stash.ID,
+			referenceType: models.Collection.Stash,
+			mineral:       stash.Mineral,
+			grade:         stash.Grade,
+			weight:        stash.Weight,
+		})
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].at.Before(records[j].at) })
+
+	skipped := 0
+
+	for _, record := range records {
+		if !record.weight.IsSet() || record.weight.Sign() <= 0 {
+			continue
+		}
+
+		source := Source{
+			AssociateID:   record.associateID,
+			BranchID:      record.branchID,
+			ReferenceID:   record.referenceID,
+			ReferenceType: record.referenceType,
+			OccurredAt:    record.at,
+		}
+
+		if record.kind == KindBuy {
+			if _, err := Receive(ctx, KindBuy, record.mineral, record.grade, record.weight, record.fineWeight, record.amount, record.currency, source); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var issueErr error
+		if record.kind == KindStash && record.grade == "" {
+			_, issueErr = IssueMixed(ctx, KindStash, record.mineral, record.weight, source)
+		} else {
+			_, issueErr = Issue(ctx, record.kind, record.mineral, record.grade, record.weight, source)
+		}
+		if issueErr != nil {
+			log.Printf("[ MIGRATE ] %s %s: %v, skipped", record.referenceType, record.referenceID.Hex(), issueErr)
+			skipped++
+		}
+	}
+
+	log.Printf("[ MIGRATE ] opening stock built from %d records, %d skipped", len(records), skipped)
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccountTotal is the sum of all lines posted to one account in one currency.
+type AccountTotal struct {
+	Account  string         `json:"account" bson:"account"`
+	Currency string         `json:"currency" bson:"currency"`
+	Debit    models.Decimal `json:"debit" bson:"debit"`
+	Credit   models.Decimal `json:"credit" bson:"credit"`
+	Balance  models.Decimal `json:"balance" bson:"-"` // debit - credit
+}
+
+// ErrInsufficientFunds is returned when an entry would take the till below zero.
+var ErrInsufficientFunds = errors.New("insufficient balance available")
+
+// applyToBalance moves the cached balance of the entry's branch and currency
+// by its cash lines: amount is the cash on hand and spent the running total
+// paid out.
+func applyToBalance(ctx context.Context, entry *models.JournalEntry) error {
+	delta := models.NewDecimal(0, 0)
+	spent := models.NewDecimal(0, 0)
+	touched := false
+
+	for _, line := range entry.Lines {
+		if line.Account != Cash {
+			continue
+		}
+		touched = true
+		delta = delta.Add(line.Debit).Sub(line.Credit)
+		spent = spent.Add(line.Credit)
+	}
+
+	if !touched {
+		return nil
+	}
+
+	filter := bson.D{{Key: "branch_id", Value: entry.BranchID}, {Key: "currency", Value: entry.Currency}}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "amount", Value: delta}, {Key: "spent", Value: spent}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "created_at", Value: time.Now()}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	if delta.Sign() >= 0 {
+		opts.SetUpsert(true)
+	} else {
+		// Paying out: check and decrement in a single atomic update so
+		// concurrent payments can never both spend the same cash.
+		filter = append(filter, bson.E{Key: "amount", Value: bson.M{"$gte": delta.Neg()}})
+	}
+
+	var balance models.Balance
+	err := database.Database.Collection(models.Collection.Balance).FindOneAndUpdate(ctx, filter, update, opts).Decode(&balance)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInsufficientFunds
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	return recordHistory(ctx, models.BalanceHistory{
+		BranchID:      entry.BranchID,
+		Currency:      entry.Currency,
+		Before:        balance.Amount.Sub(delta),
+		After:         balance.Amount,
+		Delta:         delta,
+		Event:         entry.Event,
+		ReferenceID:   entry.ReferenceID,
+		ReferenceType: entry.ReferenceType,
+		AssociateID:   entry.AssociateID,
+	})
+}
+
+// AccountTotals sums debits and credits per account and currency for entries
+// matching filter.
+func AccountTotals(ctx context.Context, filter bson.M) ([]AccountTotal, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"account":  "$lines.account",
+				"currency": bson.M{"$ifNull": bson.A{"$currency", models.DefaultCurrency}},
+			},
+			"debit":  bson.M{"$sum": "$lines.debit"},
+			"credit": bson.M{"$sum": "$lines.credit"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"account":  "$_id.account",
+			"currency": "$_id.currency",
+			"debit":    1,
+			"credit":   1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "account", Value: 1}, {Key: "currency", Value: 1}}}},
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.Ledger).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []AccountTotal{}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	for i := range totals {
+		totals[i].Balance = totals[i].Debit.Sub(totals[i].Credit)
+	}
+
+	return totals, nil
+}
+
+// AccountTotalFor returns the totals of a single account in one currency,
+// zero if it has no lines.
+func AccountTotalFor(ctx context.Context, account string, currency string, filter bson.M) (AccountTotal, error) {
+	totals, err := AccountTotals(ctx, filter)
+	if err != nil {
+		return AccountTotal{}, err
+	}
+
+	for _, total := range totals {
+		if total.Account == account && total.Currency == currency {
+			return total, nil
+		}
+	}
+
+	zero := models.NewDecimal(0, 0)
+	return AccountTotal{Account: account, Currency: currency, Debit: zero, Credit: zero, Balance: zero}, nil
+}
+
+// BranchBalance returns the cached balance of a branch in one currency,
+// building it from the ledger the first time it is asked for.
+func BranchBalance(ctx context.Context, branchID primitive.ObjectID, currency string) (*models.Balance, error) {
+	var balance models.Balance
+
+	err := database.Database.Collection(models.Collection.Balance).FindOne(ctx, bson.D{{Key: "branch_id", Value: branchID}, {Key: "currency", Value: currency}}).Decode(&balance)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return RebuildCurrency(ctx, branchID, currency)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &balance, nil
+}
+
+// BranchBalances returns the cached balances of a branch, one per currency,
+// building them from the ledger the first time they are asked for.
+func BranchBalances(ctx context.Context, branchID primitive.ObjectID) ([]models.Balance, error) {
+	cursor, err := database.Database.Collection(models.Collection.Balance).Find(ctx, bson.M{"branch_id": branchID})
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []models.Balance{}
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, err
+	}
+
+	if len(balances) == 0 {
+		return Rebuild(ctx, branchID)
+	}
+
+	return balances, nil
+}
+
+// AllBalances returns the cached balances of every branch.
+func AllBalances(ctx context.Context) ([]models.Balance, error) {
+	cursor, err := database.Database.Collection(models.Collection.Balance).Find(ctx, bson.M{"branch_id": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []models.Balance{}
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+// Rebuild recomputes the cached balances of a branch, one per currency its
+// cash account holds, from the ledger.
+func Rebuild(ctx context.Context, branchID primitive.ObjectID) ([]models.Balance, error) {
+	if branchID.IsZero() {
+		return nil, ErrNoBranch
+	}
+
+	cash, err := AccountTotals(ctx, bson.M{"branch_id": branchID, "lines.account": Cash})
+	if err != nil {
+		return nil, err
+	}
+
+	currencies := []string{}
+	for _, total := range cash {
+		if total.Account == Cash {
+			currencies = append(currencies, total.Currency)
+		}
+	}
+	if len(currencies) == 0 {
+		currencies = append(currencies, models.DefaultCurrency)
+	}
+
+	balances := make([]models.Balance, 0, len(currencies))
+	for _, currency := range currencies {
+		balance, err := RebuildCurrency(ctx, branchID, currency)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, *balance)
+	}
+
+	return balances, nil
+}
+
+// RebuildCurrency recomputes the cached balance of a branch in one currency
+// from its cash account.
+func RebuildCurrency(ctx context.Context, branchID primitive.ObjectID, currency string) (*models.Balance, error) {
+	if branchID.IsZero() {
+		return nil, ErrNoBranch
+	}
+
+	cash, err := AccountTotalFor(ctx, Cash, currency, bson.M{"branch_id": branchID})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{{Key: "branch_id", Value: branchID}, {Key: "currency", Value: currency}}
+
+	var before models.Balance
+	err = database.Database.Collection(models.Collection.Balance).FindOne(ctx, filter).Decode(&before)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if !before.Amount.IsSet() {
+		before.Amount = models.NewDecimal(0, 0)
+	}
+
+	_, err = database.Database.Collection(models.Collection.Balance).UpdateOne(ctx,
+		filter,
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "amount", Value: cash.Balance},
+				{Key: "spent", Value: cash.Credit},
+				{Key: "updated_at", Value: time.Now()},
+			}},
+			{Key: "$setOnInsert", Value: bson.D{{Key: "created_at", Value: time.Now()}}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if delta := cash.Balance.Sub(before.Amount); delta.Sign() != 0 {
+		err = recordHistory(ctx, models.BalanceHistory{
+			BranchID: branchID,
+			Currency: currency,
+			Before:   before.Amount,
+			After:    cash.Balance,
+			Delta:    delta,
+			Event:    EventRebuild,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var balance models.Balance
+	err = database.Database.Collection(models.Collection.Balance).FindOne(ctx, filter).Decode(&balance)
+	return &balance, err
+}
+
+// PostOpeningBalance carries the single balance document kept before the
+// ledger existed, named by the BALANCE_ID environment variable, into the
+// ledger of branchID as an opening entry. Back then amount held every inflow
+// and spent every outflow, so the cash on hand was their difference.
+// It does nothing once the ledger has entries.
+func PostOpeningBalance(ctx context.Context, branchID primitive.ObjectID) error {
+	count, err := database.Database.Collection(models.Collection.Ledger).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	balanceID, err := primitive.ObjectIDFromHex(os.Getenv("BALANCE_ID"))
+	if err != nil {
+		// no legacy balance to carry over
+		return nil
+	}
+
+	var balance models.Balance
+	err = database.Database.Collection(models.Collection.Balance).FindOne(ctx, bson.D{{Key: "_id", Value: balanceID}}).Decode(&balance)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	cash := balance.Amount.Sub(balance.Spent)
+	if cash.Sign() != 0 {
+		entry, err := NewEntry(EventOpening, cash.Abs(), Source{
+			BranchID:      branchID,
+			ReferenceID:   balanceID,
+			ReferenceType: models.Collection.Balance,
+			Description:   "Opening balance carried over from the balance document",
+		})
+		if err != nil {
+			return err
+		}
+
+		if cash.Sign() < 0 {
+			// an overdrawn till credits cash instead
+			entry.Lines[0].Debit, entry.Lines[0].Credit = entry.Lines[0].Credit, entry.Lines[0].Debit
+			entry.Lines[1].Debit, entry.Lines[1].Credit = entry.Lines[1].Credit, entry.Lines[1].Debit
+		}
+
+		if err := Validate(entry); err != nil {
+			return err
+		}
+		if _, err := database.Database.Collection(models.Collection.Ledger).InsertOne(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	_, err = Rebuild(ctx, branchID)
+	return err
+}
@@ -0,0 +1,230 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInsufficientFloat is returned when an entry would take an associate's
+// float below zero.
+var ErrInsufficientFloat = errors.New("insufficient float available")
+
+// applyToFloat moves the cached float of every holder named on the entry's
+// float lines. Money coming in counts as issued; money going out counts as
+// returned on a float return and as spent otherwise. Reversals undo the
+// counter their original entry moved.
+func applyToFloat(ctx context.Context, entry *models.JournalEntry) error {
+	for _, line := range entry.Lines {
+		if line.Account != Float {
+			continue
+		}
+
+		delta := line.Debit.Sub(line.Credit)
+
+		// a reversal takes back what its original entry added, so judge the
+		// line by the side it had there and count it negatively
+		debit, credit := line.Debit, line.Credit
+		if !entry.ReversalOf.IsZero() {
+			debit, credit = credit.Neg(), debit.Neg()
+		}
+
+		inc := bson.D{{Key: "amount", Value: delta}}
+
+		switch {
+		case debit.Sign() != 0:
+			inc = append(inc, bson.E{Key: "issued", Value: debit})
+		case entry.Event == EventFloatReturn:
+			inc = append(inc, bson.E{Key: "returned", Value: credit})
+		default:
+			inc = append(inc, bson.E{Key: "spent", Value: credit})
+		}
+
+		filter := bson.D{{Key: "associate_id", Value: line.HolderID}, {Key: "currency", Value: entry.Currency}}
+		update := bson.D{
+			{Key: "$inc", Value: inc},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+			{Key: "$setOnInsert", Value: bson.D{{Key: "created_at", Value: time.Now()}}},
+		}
+
+		if delta.Sign() >= 0 {
+			_, err := database.Database.Collection(models.Collection.Float).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+			if err != nil {
+				return fmt.Errorf("failed to update float: %w", err)
+			}
+			continue
+		}
+
+		// Same single conditional update as the till so two buys can never
+		// spend the same float.
+		filter = append(filter, bson.E{Key: "amount", Value: bson.M{"$gte": delta.Neg()}})
+
+		result, err := database.Database.Collection(models.Collection.Float).UpdateOne(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to update float: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return ErrInsufficientFloat
+		}
+	}
+
+	return nil
+}
+
+// AssociateFloat returns the cached float of an associate in one currency,
+// building it from the ledger the first time it is asked for.
+func AssociateFloat(ctx context.Context, associateID primitive.ObjectID, currency string) (*models.Float, error) {
+	var float models.Float
+
+	filter := bson.D{{Key: "associate_id", Value: associateID}, {Key: "currency", Value: currency}}
+
+	err := database.Database.Collection(models.Collection.Float).FindOne(ctx, filter).Decode(&float)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		floats, err := RebuildFloat(ctx, associateID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range floats {
+			if floats[i].Currency == currency {
+				return &floats[i], nil
+			}
+		}
+
+		zero := models.NewDecimal(0, 0)
+		return &models.Float{AssociateID: associateID, Currency: currency, Amount: zero, Issued: zero, Spent: zero, Returned: zero}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &float, nil
+}
+
+// AssociateFloats returns the cached floats of an associate, one per
+// currency, building them from the ledger the first time they are asked for.
+func AssociateFloats(ctx context.Context, associateID primitive.ObjectID) ([]models.Float, error) {
+	cursor, err := database.Database.Collection(models.Collection.Float).Find(ctx, bson.M{"associate_id": associateID})
+	if err != nil {
+		return nil, err
+	}
+
+	floats := []models.Float{}
+	if err := cursor.All(ctx, &floats); err != nil {
+		return nil, err
+	}
+
+	if len(floats) == 0 {
+		return RebuildFloat(ctx, associateID)
+	}
+
+	return floats, nil
+}
+
+// RebuildFloat recomputes the cached floats of an associate, one per
+// currency, from the float lines they hold.
+func RebuildFloat(ctx context.Context, associateID primitive.ObjectID) ([]models.Float, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"lines.holder_id": associateID}}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$match", Value: bson.M{"lines.account": Float, "lines.holder_id": associateID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"event":    "$event",
+				"currency": bson.M{"$ifNull": bson.A{"$currency", models.DefaultCurrency}},
+				"reversal": bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$reversal_of", false}}, false}},
+			},
+			"debit":  bson.M{"$sum": "$lines.debit"},
+			"credit": bson.M{"$sum": "$lines.credit"},
+		}}},
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.Ledger).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Group struct {
+			Event    string `bson:"event"`
+			Currency string `bson:"currency"`
+			Reversal bool   `bson:"reversal"`
+		} `bson:"_id"`
+		Debit  models.Decimal `bson:"debit"`
+		Credit models.Decimal `bson:"credit"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	zero := models.NewDecimal(0, 0)
+	byCurrency := map[string]*models.Float{}
+	currencies := []string{}
+
+	for _, total := range totals {
+		float, ok := byCurrency[total.Group.Currency]
+		if !ok {
+			float = &models.Float{Currency: total.Group.Currency, Issued: zero, Spent: zero, Returned: zero}
+			byCurrency[total.Group.Currency] = float
+			currencies = append(currencies, total.Group.Currency)
+		}
+
+		debit, credit := total.Debit, total.Credit
+		if total.Group.Reversal {
+			debit, credit = credit.Neg(), debit.Neg()
+		}
+
+		float.Issued = float.Issued.Add(debit)
+		if total.Group.Event == EventFloatReturn {
+			float.Returned = float.Returned.Add(credit)
+		} else {
+			float.Spent = float.Spent.Add(credit)
+		}
+	}
+
+	if len(currencies) == 0 {
+		byCurrency[models.DefaultCurrency] = &models.Float{Currency: models.DefaultCurrency, Issued: zero, Spent: zero, Returned: zero}
+		currencies = append(currencies, models.DefaultCurrency)
+	}
+
+	floats := make([]models.Float, 0, len(currencies))
+
+	for _, currency := range currencies {
+		totals := byCurrency[currency]
+		filter := bson.D{{Key: "associate_id", Value: associateID}, {Key: "currency", Value: currency}}
+
+		_, err = database.Database.Collection(models.Collection.Float).UpdateOne(ctx,
+			filter,
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "amount", Value: totals.Issued.Sub(totals.Spent).Sub(totals.Returned)},
+					{Key: "issued", Value: totals.Issued},
+					{Key: "spent", Value: totals.Spent},
+					{Key: "returned", Value: totals.Returned},
+					{Key: "updated_at", Value: time.Now()},
+				}},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "created_at", Value: time.Now()}}},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var float models.Float
+		if err := database.Database.Collection(models.Collection.Float).FindOne(ctx, filter).Decode(&float); err != nil {
+			return nil, err
+		}
+		floats = append(floats, float)
+	}
+
+	return floats, nil
+}
@@ -0,0 +1,148 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrNoRate is returned when no exchange rate was in force for a conversion.
+var ErrNoRate = errors.New("no exchange rate")
+
+// inversePlaces is the precision of a rate derived by inverting a recorded one
+const inversePlaces = 10
+
+// RateBook converts amounts into one currency using every recorded rate
+// to or from it, so a report can convert many records with one query.
+type RateBook struct {
+	to    string
+	rates map[string][]bookRate // by the other currency, oldest first
+}
+
+type bookRate struct {
+	from time.Time
+	rate models.Decimal // one unit of the other currency in the book's currency
+}
+
+// LoadRateBook reads the rates that convert into currency.
+func LoadRateBook(ctx context.Context, currency string) (*RateBook, error) {
+	cursor, err := database.Database.Collection(models.Collection.ExchangeRate).Find(ctx, bson.M{
+		"$or": bson.A{bson.M{"quote": currency}, bson.M{"base": currency}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []models.ExchangeRate
+	if err := cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+
+	book := &RateBook{to: currency, rates: map[string][]bookRate{}}
+
+	for _, rate := range rates {
+		if rate.Quote == currency {
+			book.rates[rate.Base] = append(book.rates[rate.Base], bookRate{from: rate.EffectiveFrom, rate: rate.Rate})
+			continue
+		}
+
+		// a rate recorded the other way round is used inverted
+		inverse, err := models.NewDecimal(1, 0).Div(rate.Rate, inversePlaces)
+		if err != nil {
+			return nil, err
+		}
+		book.rates[rate.Quote] = append(book.rates[rate.Quote], bookRate{from: rate.EffectiveFrom, rate: inverse})
+	}
+
+	for currency := range book.rates {
+		sort.SliceStable(book.rates[currency], func(i, j int) bool {
+			return book.rates[currency][i].from.Before(book.rates[currency][j].from)
+		})
+	}
+
+	return book, nil
+}
+
+// Rate returns the value of one unit of from in the book's currency at the
+// given moment.
+func (b *RateBook) Rate(from string, at time.Time) (models.Decimal, error) {
+	if from == "" {
+		from = models.DefaultCurrency
+	}
+	if from == b.to {
+		return models.NewDecimal(1, 0), nil
+	}
+
+	rates := b.rates[from]
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].from.After(at) })
+	if i == 0 {
+		return models.Decimal{}, fmt.Errorf("%w from %s to %s on %s", ErrNoRate, from, b.to, at.Format("2006-01-02"))
+	}
+
+	return rates[i-1].rate, nil
+}
+
+// Convert turns an amount in from into the book's currency at the rate in
+// force at the given moment.
+func (b *RateBook) Convert(amount models.Decimal, from string, at time.Time) (models.Decimal, error) {
+	rate, err := b.Rate(from, at)
+	if err != nil {
+		return models.Decimal{}, err
+	}
+	return amount.Mul(rate), nil
+}
+
+// RateAt returns the value of one unit of from in to at the given moment.
+func RateAt(ctx context.Context, from string, to string, at time.Time) (models.Decimal, error) {
+	book, err := LoadRateBook(ctx, to)
+	if err != nil {
+		return models.Decimal{}, err
+	}
+	return book.Rate(from, at)
+}
+
+// ConvertedSum sums field over the records of collection matching filter,
+// voided ones left out, each converted into the book's currency at the rate
+// in force on its dateField.
+func ConvertedSum(ctx context.Context, book *RateBook, collection string, filter bson.M, field string, dateField string) (models.Decimal, error) {
+	cursor, err := database.Database.Collection(collection).Find(ctx, database.ExcludeVoided(filter))
+	if err != nil {
+		return models.Decimal{}, err
+	}
+
+	var records []bson.Raw
+	if err := cursor.All(ctx, &records); err != nil {
+		return models.Decimal{}, err
+	}
+
+	total := models.NewDecimal(0, 0)
+
+	for _, record := range records {
+		var amount models.Decimal
+		if value, err := record.LookupErr(field); err == nil {
+			if err := amount.UnmarshalBSONValue(value.Type, value.Value); err != nil {
+				return models.Decimal{}, err
+			}
+		}
+		if !amount.IsSet() {
+			continue
+		}
+
+		currency, _ := record.Lookup("currency").StringValueOK()
+		at, _ := record.Lookup(dateField).TimeOK()
+
+		converted, err := book.Convert(amount, currency, at)
+		if err != nil {
+			return models.Decimal{}, err
+		}
+		total = total.Add(converted)
+	}
+
+	return total, nil
+}
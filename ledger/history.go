@@ -0,0 +1,97 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventRebuild marks balance history written when a cached balance is
+// recomputed from the ledger. It is never posted as a journal entry.
+const EventRebuild = "rebuild"
+
+// BalancePoint is a branch balance at one moment
+type BalancePoint struct {
+	At     time.Time      `json:"at"`
+	Amount models.Decimal `json:"amount"`
+}
+
+func recordHistory(ctx context.Context, history models.BalanceHistory) error {
+	history.ID = primitive.NewObjectID()
+	history.CreatedAt = time.Now()
+
+	_, err := database.Database.Collection(models.Collection.BalanceHistory).InsertOne(ctx, history)
+	return err
+}
+
+// BalanceAt returns the cached balance a branch had in a currency at the
+// given moment, zero before its first change.
+func BalanceAt(ctx context.Context, branchID primitive.ObjectID, currency string, at time.Time) (models.Decimal, error) {
+	var history models.BalanceHistory
+
+	err := database.Database.Collection(models.Collection.BalanceHistory).FindOne(ctx,
+		bson.M{"branch_id": branchID, "currency": currency, "created_at": bson.M{"$lte": at}},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}),
+	).Decode(&history)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.NewDecimal(0, 0), nil
+	}
+	if err != nil {
+		return models.Decimal{}, err
+	}
+
+	return history.After, nil
+}
+
+// BalanceHistories lists the changes of a branch balance in a currency
+// between from and to.
+func BalanceHistories(ctx context.Context, branchID primitive.ObjectID, currency string, from time.Time, to time.Time) ([]models.BalanceHistory, error) {
+	cursor, err := database.Database.Collection(models.Collection.BalanceHistory).Find(ctx,
+		bson.M{"branch_id": branchID, "currency": currency, "created_at": bson.M{"$gt": from, "$lte": to}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	histories := []models.BalanceHistory{}
+	if err := cursor.All(ctx, &histories); err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+// BalanceSeries samples a branch balance in a currency at from and then every
+// step until to.
+func BalanceSeries(ctx context.Context, branchID primitive.ObjectID, currency string, from time.Time, to time.Time, step time.Duration) ([]BalancePoint, error) {
+	amount, err := BalanceAt(ctx, branchID, currency, from)
+	if err != nil {
+		return nil, err
+	}
+
+	histories, err := BalanceHistories(ctx, branchID, currency, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []BalancePoint{}
+	next := 0
+
+	for at := from; !at.After(to); at = at.Add(step) {
+		for next < len(histories) && !histories[next].CreatedAt.After(at) {
+			amount = histories[next].After
+			next++
+		}
+		points = append(points, BalancePoint{At: at, Amount: amount})
+	}
+
+	return points, nil
+}
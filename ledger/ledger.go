@@ -0,0 +1,280 @@
+// Package ledger records every movement of money as balanced double-entry
+// journal entries. Each branch keeps its own accounts, one set per currency;
+// its balance documents are only a cache of the branch cash account, and each
+// associate's float documents a cache of the float lines they hold.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ledger accounts
+const (
+	Cash            = "cash"             // money in the till
+	Float           = "float"            // money handed to associates, per holder
+	Inventory       = "inventory"        // minerals bought and not yet sold
+	Stash           = "stash"            // minerals dispatched in a stash
+	LoansReceivable = "loans_receivable" // money advanced to customers
+	Expenses        = "expenses"         // miscellaneous spending
+	Capital         = "capital"          // funds put into the business
+	Sales           = "sales"            // proceeds of minerals sold
+	CostOfSales     = "cost_of_sales"    // what the minerals sold cost
+	LoanIncome      = "loan_income"      // interest and fees earned on loans
+)
+
+// Accounts lists every ledger account.
+var Accounts = []string{Cash, Float, Inventory, Stash, LoansReceivable, Expenses, Capital, Sales, CostOfSales, LoanIncome}
+
+// Business events that post to the ledger
+const (
+	EventFund             = "fund"
+	EventBuy              = "buy"
+	EventSell             = "sell"
+	EventSellCost         = "sell_cost" // cost of the stock a sell took
+	EventLoanCredit       = "loan_credit"
+	EventLoanPayoff       = "loan_payoff"
+	EventLoanWriteOff     = "loan_write_off"
+	EventLoanIncome       = "loan_income"
+	EventLoanForfeit      = "loan_forfeit"
+	EventLoanOffset       = "loan_offset"        // principal repaid out of a buy
+	EventLoanOffsetIncome = "loan_offset_income" // fees and interest repaid out of a buy
+	EventMiscellaneous    = "miscellaneous"
+	EventStash            = "stash"
+	EventStashDispatch    = "stash_dispatch" // cost of the stock a stash took
+	EventStashSettle      = "stash_settle"
+	EventStashCost        = "stash_cost" // cost of a settled stash
+	EventOpening          = "opening"
+	EventFloatIssue       = "float_issue"
+	EventFloatReturn      = "float_return"
+)
+
+type rule struct {
+	debit  string
+	credit string
+}
+
+// rules maps each event to the account it debits and the one it credits.
+var rules = map[string]rule{
+	EventFund:             {debit: Cash, credit: Capital},
+	EventBuy:              {debit: Inventory, credit: Float},
+	EventSell:             {debit: Cash, credit: Sales},
+	EventSellCost:         {debit: CostOfSales, credit: Inventory},
+	EventLoanCredit:       {debit: LoansReceivable, credit: Float},
+	EventLoanPayoff:       {debit: Cash, credit: LoansReceivable},
+	EventLoanWriteOff:     {debit: Expenses, credit: LoansReceivable},
+	EventLoanIncome:       {debit: Cash, credit: LoanIncome},
+	EventLoanForfeit:      {debit: Inventory, credit: LoansReceivable},
+	EventLoanOffset:       {debit: Inventory, credit: LoansReceivable},
+	EventLoanOffsetIncome: {debit: Inventory, credit: LoanIncome},
+	EventMiscellaneous:    {debit: Expenses, credit: Cash},
+	EventStash:            {debit: Stash, credit: Cash},
+	EventStashDispatch:    {debit: Stash, credit: Inventory},
+	EventStashSettle:      {debit: Cash, credit: Sales},
+	EventStashCost:        {debit: CostOfSales, credit: Stash},
+	EventOpening:          {debit: Cash, credit: Capital},
+	EventFloatIssue:       {debit: Float, credit: Cash},
+	EventFloatReturn:      {debit: Cash, credit: Float},
+}
+
+var (
+	ErrNoCurrency   = errors.New("journal entry has no currency")
+	ErrUnknownEvent = errors.New("unknown ledger event")
+	ErrUnbalanced   = errors.New("journal entry does not balance")
+	ErrNoBranch     = errors.New("journal entry has no branch")
+	ErrNoHolder     = errors.New("float line has no holder")
+)
+
+// Source identifies the business document behind a journal entry.
+type Source struct {
+	AssociateID   primitive.ObjectID
+	BranchID      primitive.ObjectID
+	HolderID      primitive.ObjectID // associate whose float moves, AssociateID when unset
+	ReferenceID   primitive.ObjectID
+	ReferenceType string
+	Description   string
+	OccurredAt    time.Time // when the business event happened, now when unset
+	Currency      string    // currency of the amount, models.DefaultCurrency when unset
+}
+
+// NewEntry builds the journal entry for a business event of the given amount.
+func NewEntry(event string, amount models.Decimal, source Source) (*models.JournalEntry, error) {
+	r, ok := rules[event]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, event)
+	}
+
+	zero := models.NewDecimal(0, 0)
+
+	holder := source.HolderID
+	if holder.IsZero() {
+		holder = source.AssociateID
+	}
+
+	currency := source.Currency
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+
+	occurredAt := source.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+
+	entry := &models.JournalEntry{
+		ID:            primitive.NewObjectID(),
+		Event:         event,
+		ReferenceID:   source.ReferenceID,
+		ReferenceType: source.ReferenceType,
+		AssociateID:   source.AssociateID,
+		BranchID:      source.BranchID,
+		Currency:      currency,
+		Description:   source.Description,
+		Lines: []models.JournalLine{
+			{Account: r.debit, Debit: amount, Credit: zero},
+			{Account: r.credit, Debit: zero, Credit: amount},
+		},
+		CreatedAt: occurredAt,
+	}
+
+	for i := range entry.Lines {
+		if entry.Lines[i].Account == Float {
+			entry.Lines[i].HolderID = holder
+		}
+	}
+
+	return entry, nil
+}
+
+// Validate checks that every line touches one side of a known account and
+// that debits equal credits.
+func Validate(entry *models.JournalEntry) error {
+	if entry.BranchID.IsZero() {
+		return ErrNoBranch
+	}
+
+	if entry.Currency == "" {
+		return ErrNoCurrency
+	}
+
+	if len(entry.Lines) < 2 {
+		return fmt.Errorf("%w: an entry needs at least two lines", ErrUnbalanced)
+	}
+
+	debits := models.NewDecimal(0, 0)
+	credits := models.NewDecimal(0, 0)
+
+	for _, line := range entry.Lines {
+		if !isAccount(line.Account) {
+			return fmt.Errorf("unknown ledger account %q", line.Account)
+		}
+		if line.Account == Float && line.HolderID.IsZero() {
+			return ErrNoHolder
+		}
+		if line.Debit.Sign() < 0 || line.Credit.Sign() < 0 {
+			return fmt.Errorf("%w: negative amount on %s", ErrUnbalanced, line.Account)
+		}
+		if (line.Debit.Sign() == 0) == (line.Credit.Sign() == 0) {
+			return fmt.Errorf("%w: line on %s must be either a debit or a credit", ErrUnbalanced, line.Account)
+		}
+		debits = debits.Add(line.Debit)
+		credits = credits.Add(line.Credit)
+	}
+
+	if debits.Cmp(credits) != 0 {
+		return fmt.Errorf("%w: debits %s, credits %s", ErrUnbalanced, debits, credits)
+	}
+
+	return nil
+}
+
+func isAccount(account string) bool {
+	for _, a := range Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+// Post validates a journal entry, checks its date is not in a closed period,
+// applies its cash lines to the cached balance and its float lines to the
+// holders' floats, and stores it. Run it inside database.WithTransaction together with
+// the insert of the business document so either everything lands or nothing.
+func Post(ctx context.Context, entry *models.JournalEntry) error {
+	if err := Validate(entry); err != nil {
+		return err
+	}
+
+	if err := EnsureOpen(ctx, entry.CreatedAt); err != nil {
+		return err
+	}
+
+	if err := applyToBalance(ctx, entry); err != nil {
+		return err
+	}
+
+	if err := applyToFloat(ctx, entry); err != nil {
+		return err
+	}
+
+	if _, err := database.Database.Collection(models.Collection.Ledger).InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to post journal entry: %w", err)
+	}
+
+	return nil
+}
+
+// Record builds and posts the entry for a business event in one step.
+func Record(ctx context.Context, event string, amount models.Decimal, source Source) (*models.JournalEntry, error) {
+	entry, err := NewEntry(event, amount, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Post(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Reverse posts the mirror image of entry, taking back its effect on every
+// account, the till and the floats. The reversal keeps the original event
+// and reference and points back at it through ReversalOf.
+func Reverse(ctx context.Context, entry *models.JournalEntry, associateID primitive.ObjectID, description string) (*models.JournalEntry, error) {
+	reversal := &models.JournalEntry{
+		ID:            primitive.NewObjectID(),
+		Event:         entry.Event,
+		ReferenceID:   entry.ReferenceID,
+		ReferenceType: entry.ReferenceType,
+		AssociateID:   associateID,
+		BranchID:      entry.BranchID,
+		Currency:      entry.Currency,
+		Description:   description,
+		Lines:         make([]models.JournalLine, len(entry.Lines)),
+		ReversalOf:    entry.ID,
+		CreatedAt:     time.Now(),
+	}
+
+	if reversal.Currency == "" {
+		reversal.Currency = models.DefaultCurrency
+	}
+
+	for i, line := range entry.Lines {
+		line.Debit, line.Credit = line.Credit, line.Debit
+		reversal.Lines[i] = line
+	}
+
+	if err := Post(ctx, reversal); err != nil {
+		return nil, err
+	}
+
+	return reversal, nil
+}
@@ -0,0 +1,126 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// useTestDatabase points the database package at a throwaway database on
+// the replica set in TEST_DATABASE_URI, skipping the test when none is set.
+// Transactions need a replica set, so a standalone server will not do.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	database.ConnectMongoDB(uri)
+	database.Database = database.Client.Database("oryan_test_" + primitive.NewObjectID().Hex())
+
+	t.Cleanup(func() {
+		_ = database.Database.Drop(context.Background())
+		_ = database.Client.Disconnect(context.Background())
+	})
+}
+
+// TestConcurrentBuysSpendFloatOnce fires more buys at once than the float
+// covers. Every buy the float can pay for must land and the rest must be
+// turned away, without any two spending the same money.
+func TestConcurrentBuysSpendFloatOnce(t *testing.T) {
+	useTestDatabase(t)
+
+	ctx := context.Background()
+
+	const (
+		buys      = 20
+		float     = 1000
+		buyAmount = 100
+	)
+
+	source := Source{
+		AssociateID: primitive.NewObjectID(),
+		BranchID:    primitive.NewObjectID(),
+		Currency:    models.DefaultCurrency,
+	}
+
+	if _, err := Record(ctx, EventFund, models.NewDecimal(float, 0), source); err != nil {
+		t.Fatalf("fund the till: %v", err)
+	}
+	if _, err := Record(ctx, EventFloatIssue, models.NewDecimal(float, 0), source); err != nil {
+		t.Fatalf("issue the float: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, refused := 0, 0
+	var unexpected []error
+
+	for i := 0; i < buys; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buy := source
+			buy.ReferenceID = primitive.NewObjectID()
+			buy.ReferenceType = models.Collection.Transaction
+
+			err := database.WithTransaction(ctx, func(sessionContext mongo.SessionContext) error {
+				_, err := Record(sessionContext, EventBuy, models.NewDecimal(buyAmount, 0), buy)
+				return err
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInsufficientFloat):
+				refused++
+			default:
+				unexpected = append(unexpected, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range unexpected {
+		t.Errorf("buy failed: %v", err)
+	}
+
+	if want := float / buyAmount; succeeded != want {
+		t.Errorf("%d buys succeeded, want %d", succeeded, want)
+	}
+	if want := buys - float/buyAmount; refused != want {
+		t.Errorf("%d buys were refused, want %d", refused, want)
+	}
+
+	held, err := AssociateFloat(ctx, source.AssociateID, models.DefaultCurrency)
+	if err != nil {
+		t.Fatalf("read the float: %v", err)
+	}
+	if held.Amount.Sign() != 0 {
+		t.Errorf("float left is %s, want 0", held.Amount)
+	}
+	if want := models.NewDecimal(float, 0); held.Spent.Cmp(want) != 0 {
+		t.Errorf("float spent is %s, want %s", held.Spent, want)
+	}
+
+	posted, err := database.Database.Collection(models.Collection.Ledger).CountDocuments(ctx, bson.M{"event": EventBuy})
+	if err != nil {
+		t.Fatalf("count buy entries: %v", err)
+	}
+	if posted != int64(succeeded) {
+		t.Errorf("%d buy entries posted, want %d", posted, succeeded)
+	}
+}
@@ -0,0 +1,153 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Period kinds and statuses
+const (
+	PeriodDay    = "day"
+	PeriodMonth  = "month"
+	PeriodClosed = "closed"
+	PeriodOpen   = "open"
+)
+
+var (
+	ErrPeriodClosed   = errors.New("accounting period is closed")
+	ErrUnknownPeriod  = errors.New("period kind must be day or month")
+	ErrPeriodNotFound = errors.New("period not found")
+	ErrPeriodOpen     = errors.New("period is not closed")
+)
+
+// PeriodBounds returns the day or month containing t, in UTC.
+func PeriodBounds(kind string, t time.Time) (time.Time, time.Time, error) {
+	t = t.UTC()
+	switch kind {
+	case PeriodDay:
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1), nil
+	case PeriodMonth:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, ErrUnknownPeriod
+}
+
+// EnsureOpen fails with ErrPeriodClosed when t falls inside a closed period.
+// Check it inside the transaction that writes a record dated t, before
+// anything else: a closed period refuses the record even when it posts
+// nothing to the ledger, as a zero amount or a buy fully offset against
+// loans does.
+func EnsureOpen(ctx context.Context, t time.Time) error {
+	var period models.Period
+
+	err := database.Database.Collection(models.Collection.Period).FindOne(ctx, bson.M{
+		"status": PeriodClosed,
+		"start":  bson.M{"$lte": t},
+		"end":    bson.M{"$gt": t},
+	}).Decode(&period)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	label := period.Start.Format("2006-01-02")
+	if period.Kind == PeriodMonth {
+		label = period.Start.Format("2006-01")
+	}
+
+	return fmt.Errorf("%w: %s %s is closed, ask an admin to reopen it", ErrPeriodClosed, period.Kind, label)
+}
+
+// ClosePeriod locks the day or month containing t and audits who did it.
+func ClosePeriod(ctx context.Context, kind string, t time.Time, associateID primitive.ObjectID, reason string) (*models.Period, error) {
+	start, end, err := PeriodBounds(kind, t)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	filter := bson.D{{Key: "kind", Value: kind}, {Key: "start", Value: start}}
+
+	var period models.Period
+	err = database.Database.Collection(models.Collection.Period).FindOneAndUpdate(ctx,
+		filter,
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "end", Value: end},
+				{Key: "status", Value: PeriodClosed},
+				{Key: "closed_by", Value: associateID},
+				{Key: "closed_at", Value: now},
+			}},
+			{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID()}}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&period)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auditPeriod(ctx, period.ID, "close", associateID, reason); err != nil {
+		return nil, err
+	}
+
+	return &period, nil
+}
+
+// ReopenPeriod unlocks a closed period and audits who did it and why. A
+// period that is already open is left alone.
+func ReopenPeriod(ctx context.Context, periodID primitive.ObjectID, associateID primitive.ObjectID, reason string) (*models.Period, error) {
+	var period models.Period
+	err := database.Database.Collection(models.Collection.Period).FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: periodID}, {Key: "status", Value: PeriodClosed}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: PeriodOpen},
+			{Key: "reopened_by", Value: associateID},
+			{Key: "reopened_at", Value: time.Now()},
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&period)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, err := database.Database.Collection(models.Collection.Period).CountDocuments(ctx, bson.D{{Key: "_id", Value: periodID}})
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrPeriodOpen
+		}
+		return nil, ErrPeriodNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auditPeriod(ctx, period.ID, "reopen", associateID, reason); err != nil {
+		return nil, err
+	}
+
+	return &period, nil
+}
+
+func auditPeriod(ctx context.Context, periodID primitive.ObjectID, action string, associateID primitive.ObjectID, reason string) error {
+	_, err := database.Database.Collection(models.Collection.PeriodAudit).InsertOne(ctx, models.PeriodAudit{
+		ID:          primitive.NewObjectID(),
+		PeriodID:    periodID,
+		Action:      action,
+		AssociateID: associateID,
+		Reason:      reason,
+		CreatedAt:   time.Now(),
+	})
+	return err
+}
@@ -0,0 +1,493 @@
+// Package lending keeps an account for every loan paid out to a customer.
+// The credit and payoff loan records stay the cash that moved; the account
+// ties them together into the principal, what has been repaid and what is
+// still outstanding.
+package lending
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Types of loan record
+const (
+	TypeCredit = "credit" // pays a loan out
+	TypePayoff = "payoff" // repays it
+)
+
+// Statuses of a loan account. Overdue is never stored: it is an active loan
+// past its due date.
+const (
+	StatusActive     = "active"
+	StatusSettled    = "settled"
+	StatusOverdue    = "overdue"
+	StatusWrittenOff = "written_off"
+	StatusForfeited  = "forfeited" // closed by taking its collateral
+)
+
+var (
+	ErrAccountNotFound = errors.New("loan account not found")
+	ErrNoOpenAccount   = errors.New("the customer has no open loan in this currency")
+	ErrAccountMismatch = errors.New("the repayment is not of the loan's customer and currency")
+	ErrAccountClosed   = errors.New("the loan is settled, written off or voided")
+	ErrOverpayment     = errors.New("the repayment is more than the loan's outstanding balance")
+	ErrHasRepayments   = errors.New("the loan has repayments, void them first")
+	ErrUnknownStatus   = errors.New("unknown status, expected active, settled, overdue, written_off or forfeited")
+	ErrAccountChanged  = errors.New("the loan changed while the repayment was taken, try again")
+)
+
+// StatusOf is the status of an account at the given moment.
+func StatusOf(account *models.LoanAccount, at time.Time) string {
+	switch {
+	case account.Forfeiture != nil:
+		return StatusForfeited
+	case account.WriteOff != nil:
+		return StatusWrittenOff
+	case account.Outstanding.Sign() <= 0:
+		return StatusSettled
+	case account.NextDueAt != nil && account.NextDueAt.Before(at):
+		return StatusOverdue
+	}
+	return StatusActive
+}
+
+// StatusFilter narrows a query on loan accounts to the given status at the
+// given moment.
+func StatusFilter(status string, at time.Time) (bson.M, error) {
+	switch status {
+	case StatusActive:
+		return bson.M{"status": StatusActive, "$or": bson.A{
+			bson.M{"next_due_at": bson.M{"$exists": false}},
+			bson.M{"next_due_at": bson.M{"$gte": at}},
+		}}, nil
+	case StatusOverdue:
+		return bson.M{"status": StatusActive, "next_due_at": bson.M{"$lt": at}}, nil
+	case StatusSettled, StatusWrittenOff, StatusForfeited:
+		return bson.M{"status": status}, nil
+	}
+	return nil, ErrUnknownStatus
+}
+
+// stored is the status kept on the account; overdue is worked out on read
+func stored(account *models.LoanAccount) string {
+	status := StatusOf(account, time.Time{})
+	if status == StatusOverdue {
+		return StatusActive
+	}
+	return status
+}
+
+// closed reports whether an account can no longer move: voided, written off
+// or forfeited
+func closed(account *models.LoanAccount) bool {
+	return account.Void != nil || account.WriteOff != nil || account.Forfeiture != nil
+}
+
+// Open starts the account a credit loan record pays out, with the schedule
+// of its terms and the pledges securing it, and links the record to it. Run
+// it in the same transaction as the insert of the record.
+func Open(ctx context.Context, loan *models.Loan) (*models.LoanAccount, error) {
+	pledgeValue, loanToValue, err := valuePledges(ctx, loan)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := newAccount(loan)
+	if err != nil {
+		return nil, err
+	}
+	account.PledgeValue, account.LoanToValue = pledgeValue, loanToValue
+
+	if _, err := database.Database.Collection(models.Collection.LoanAccount).InsertOne(ctx, account); err != nil {
+		return nil, err
+	}
+
+	loan.AccountID = account.ID
+	account.Status = StatusOf(account, time.Now())
+
+	return account, nil
+}
+
+func newAccount(loan *models.Loan) (*models.LoanAccount, error) {
+	account := &models.LoanAccount{
+		ID:             primitive.NewObjectID(),
+		CustomerID:     loan.CustomerID,
+		BranchID:       loan.BranchID,
+		AssociateID:    loan.AssociateID,
+		Principal:      loan.Amount,
+		Currency:       loan.Currency,
+		DisbursementID: loan.ID,
+		DisbursedAt:    loan.CreatedAt,
+		DueAt:          loan.DueAt,
+		Terms:          loan.Terms,
+		Schedule:       []models.LoanInstalment{},
+		Repayments:     []models.LoanRepayment{},
+		Pledges:        []models.LoanPledge{},
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if loan.Pledges != nil {
+		account.Pledges = loan.Pledges
+	}
+
+	// a loan on terms is due when its last instalment is
+	if loan.Terms != nil {
+		schedule, err := BuildSchedule(loan.Amount, loan.Terms, loan.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		account.Schedule = schedule
+		account.DueAt = &schedule[len(schedule)-1].DueAt
+	}
+
+	allocate(account)
+	account.Status = stored(account)
+
+	return account, nil
+}
+
+// Repay takes a payoff loan record against the account it names, or the
+// customer's oldest open loan in its currency when it names none, and links
+// the record to it. Run it in the same transaction as the insert of the
+// record.
+func Repay(ctx context.Context, loan *models.Loan) (*models.LoanAccount, error) {
+	var account *models.LoanAccount
+	var err error
+
+	if loan.AccountID.IsZero() {
+		account, err = oldestOpen(ctx, loan.CustomerID, loan.Currency)
+	} else {
+		account, err = AccountFor(ctx, loan.AccountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if account.CustomerID != loan.CustomerID || account.Currency != loan.Currency {
+		return nil, ErrAccountMismatch
+	}
+	if closed(account) || account.Outstanding.Sign() <= 0 {
+		return nil, ErrAccountClosed
+	}
+
+	account.Repayments = append(account.Repayments, models.LoanRepayment{
+		LoanID:      loan.ID,
+		AssociateID: loan.AssociateID,
+		Amount:      loan.Amount,
+		PaidAt:      loan.CreatedAt,
+	})
+	outstanding := account.Outstanding
+	allocate(account)
+
+	if account.Outstanding.Sign() < 0 {
+		return nil, ErrOverpayment
+	}
+
+	if err := save(ctx, account, outstanding); err != nil {
+		return nil, err
+	}
+
+	loan.AccountID = account.ID
+
+	return account, nil
+}
+
+// Post records a loan record's cash in the ledger: a credit pays the
+// principal out, a repayment takes back principal and earns the fees and
+// interest it was allocated to. A repayment offset against a buy was paid
+// in stock rather than cash.
+func Post(ctx context.Context, loan *models.Loan, account *models.LoanAccount) error {
+	source := ledger.Source{
+		AssociateID:   loan.AssociateID,
+		BranchID:      loan.BranchID,
+		ReferenceID:   loan.ID,
+		ReferenceType: models.Collection.Loan,
+		OccurredAt:    loan.CreatedAt,
+		Currency:      loan.Currency,
+	}
+
+	if loan.Type == TypeCredit {
+		_, err := ledger.Record(ctx, ledger.EventLoanCredit, loan.Amount, source)
+		return err
+	}
+
+	payoff, income := ledger.EventLoanPayoff, ledger.EventLoanIncome
+	if loan.TransactionID != nil {
+		payoff, income = ledger.EventLoanOffset, ledger.EventLoanOffsetIncome
+	}
+
+	for _, repayment := range account.Repayments {
+		if repayment.LoanID != loan.ID {
+			continue
+		}
+
+		if repayment.Principal.Sign() > 0 {
+			if _, err := ledger.Record(ctx, payoff, repayment.Principal, source); err != nil {
+				return err
+			}
+		}
+		if charges := repayment.Fee.Add(repayment.Interest); charges.Sign() > 0 {
+			if _, err := ledger.Record(ctx, income, charges, source); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Unwind takes a voided loan record off its account: a voided disbursement
+// voids the account and releases its collateral, a voided repayment is owed
+// again.
+func Unwind(ctx context.Context, loanID primitive.ObjectID, accountID primitive.ObjectID, void *models.Void) error {
+	account, err := AccountFor(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	// what was written off or forfeited is already posted, so the loan can
+	// no longer move
+	if account.WriteOff != nil || account.Forfeiture != nil {
+		return ErrAccountClosed
+	}
+
+	if account.DisbursementID == loanID {
+		if len(account.Repayments) > 0 {
+			return ErrHasRepayments
+		}
+		closePledges(account, PledgeReleased)
+		_, err := database.Database.Collection(models.Collection.LoanAccount).UpdateOne(ctx,
+			bson.M{"_id": account.ID},
+			bson.M{"$set": bson.M{"void": void, "pledges": account.Pledges, "updated_at": time.Now()}},
+		)
+		return err
+	}
+
+	repayments := []models.LoanRepayment{}
+	for _, repayment := range account.Repayments {
+		if repayment.LoanID != loanID {
+			repayments = append(repayments, repayment)
+		}
+	}
+	if len(repayments) == len(account.Repayments) {
+		return nil
+	}
+
+	outstanding := account.Outstanding
+	account.Repayments = repayments
+	allocate(account)
+
+	if account.Outstanding.Sign() > 0 && holdsPledges(account, PledgeReleased) {
+		return ErrCollateralReleased
+	}
+
+	return save(ctx, account, outstanding)
+}
+
+// WriteOff gives up on what is left of a loan, posting it to expenses.
+func WriteOff(ctx context.Context, accountID primitive.ObjectID, associateID primitive.ObjectID, reason string) (*models.LoanAccount, error) {
+	account, err := AccountFor(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if closed(account) || account.Outstanding.Sign() <= 0 {
+		return nil, ErrAccountClosed
+	}
+	if holdsPledges(account, PledgeHeld) {
+		return nil, ErrCollateralHeld
+	}
+
+	account.WriteOff = &models.LoanWriteOff{
+		WrittenOffBy: associateID,
+		WrittenOffAt: time.Now(),
+		Amount:       account.Outstanding,
+		Reason:       reason,
+	}
+	account.Status = StatusWrittenOff
+
+	result, err := database.Database.Collection(models.Collection.LoanAccount).UpdateOne(ctx,
+		bson.M{"_id": account.ID, "write_off": bson.M{"$exists": false}, "forfeiture": bson.M{"$exists": false}, "outstanding": account.Outstanding},
+		bson.M{"$set": bson.M{"write_off": account.WriteOff, "status": account.Status, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrAccountClosed
+	}
+
+	// only the principal was ever booked as owed; unpaid fees and interest
+	// were never earned
+	principal := account.Principal.Sub(account.PrincipalPaid)
+	if principal.Sign() <= 0 {
+		return account, nil
+	}
+
+	_, err = ledger.Record(ctx, ledger.EventLoanWriteOff, principal, ledger.Source{
+		AssociateID:   associateID,
+		BranchID:      account.BranchID,
+		ReferenceID:   account.ID,
+		ReferenceType: models.Collection.LoanAccount,
+		Description:   "Write-off: " + reason,
+		OccurredAt:    account.WriteOff.WrittenOffAt,
+		Currency:      account.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// save stores what allocating the repayments worked out, as long as the
+// account still owes what it did when it was read
+func save(ctx context.Context, account *models.LoanAccount, outstanding models.Decimal) error {
+	account.Status = stored(account)
+	account.UpdatedAt = time.Now()
+
+	// a settled loan's collateral goes back to the customer
+	if account.Status == StatusSettled {
+		closePledges(account, PledgeReleased)
+	}
+
+	result, err := database.Database.Collection(models.Collection.LoanAccount).UpdateOne(ctx,
+		bson.M{
+			"_id":         account.ID,
+			"void":        bson.M{"$exists": false},
+			"write_off":   bson.M{"$exists": false},
+			"forfeiture":  bson.M{"$exists": false},
+			"outstanding": outstanding,
+		},
+		bson.M{"$set": bson.M{
+			"schedule":       account.Schedule,
+			"fees":           account.Fees,
+			"interest":       account.Interest,
+			"repayments":     account.Repayments,
+			"repaid":         account.Repaid,
+			"fees_paid":      account.FeesPaid,
+			"interest_paid":  account.InterestPaid,
+			"principal_paid": account.PrincipalPaid,
+			"outstanding":    account.Outstanding,
+			"next_due_at":    account.NextDueAt,
+			"pledges":        account.Pledges,
+			"status":         account.Status,
+			"updated_at":     account.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAccountChanged
+	}
+
+	account.Status = StatusOf(account, time.Now())
+
+	return nil
+}
+
+// AccountFor returns a loan account with its status as of now.
+func AccountFor(ctx context.Context, id primitive.ObjectID) (*models.LoanAccount, error) {
+	var account models.LoanAccount
+
+	err := database.Database.Collection(models.Collection.LoanAccount).FindOne(ctx, bson.M{"_id": id}).Decode(&account)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	account.Status = StatusOf(&account, time.Now())
+
+	return &account, nil
+}
+
+// Accounts lists the live loan accounts matching filter, latest first, with
+// their status as of now.
+func Accounts(ctx context.Context, filter bson.M) ([]models.LoanAccount, error) {
+	cursor, err := database.Database.Collection(models.Collection.LoanAccount).Find(ctx, database.ExcludeVoided(filter),
+		options.Find().SetSort(bson.D{{Key: "disbursed_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []models.LoanAccount{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range accounts {
+		accounts[i].Status = StatusOf(&accounts[i], now)
+	}
+
+	return accounts, nil
+}
+
+func oldestOpen(ctx context.Context, customerID primitive.ObjectID, currency string) (*models.LoanAccount, error) {
+	var account models.LoanAccount
+
+	err := database.Database.Collection(models.Collection.LoanAccount).FindOne(ctx,
+		database.ExcludeVoided(bson.M{"customer_id": customerID, "currency": currency, "status": StatusActive}),
+		options.FindOne().SetSort(bson.D{{Key: "disbursed_at", Value: 1}}),
+	).Decode(&account)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNoOpenAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// Totals is what a set of loan accounts lent, took back and is still owed,
+// in one currency.
+type Totals struct {
+	Principal   models.Decimal `json:"principal"`
+	Charges     models.Decimal `json:"charges"` // fees and interest
+	Repaid      models.Decimal `json:"repaid"`
+	Outstanding models.Decimal `json:"outstanding"` // of active and overdue loans
+	WrittenOff  models.Decimal `json:"written_off"`
+	Forfeited   models.Decimal `json:"forfeited"` // closed by taking the collateral
+}
+
+// Total sums accounts into the book's currency at the rate in force when
+// each loan was paid out.
+func Total(accounts []models.LoanAccount, book *ledger.RateBook) (Totals, error) {
+	zero := models.NewDecimal(0, 0)
+	totals := Totals{Principal: zero, Charges: zero, Repaid: zero, Outstanding: zero, WrittenOff: zero, Forfeited: zero}
+
+	for _, account := range accounts {
+		rate, err := book.Rate(account.Currency, account.DisbursedAt)
+		if err != nil {
+			return Totals{}, err
+		}
+
+		totals.Principal = totals.Principal.Add(account.Principal.Mul(rate))
+		totals.Charges = totals.Charges.Add(account.Fees.Add(account.Interest).Mul(rate))
+		totals.Repaid = totals.Repaid.Add(account.Repaid.Mul(rate))
+
+		if account.WriteOff != nil {
+			totals.WrittenOff = totals.WrittenOff.Add(account.WriteOff.Amount.Mul(rate))
+		} else if account.Forfeiture != nil {
+			totals.Forfeited = totals.Forfeited.Add(account.Forfeiture.Amount.Mul(rate))
+		} else if account.Outstanding.Sign() > 0 {
+			totals.Outstanding = totals.Outstanding.Add(account.Outstanding.Mul(rate))
+		}
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,156 @@
+package lending
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOffsetNotBuy     = errors.New("only a buy can be offset against loans")
+	ErrOffsetExceedsBuy = errors.New("the loan offset is more than the buy pays")
+)
+
+// Offsets reports whether a buy asks for part of its amount to repay loans.
+func Offsets(transaction *models.Transaction) bool {
+	return transaction.OffsetLoans || transaction.LoanOffset.Sign() > 0 || !transaction.LoanAccountID.IsZero()
+}
+
+// Offset keeps part of what a buy pays a customer to repay their loans in
+// the buy's currency: the loan the buy names, else the oldest open loans
+// first. Without an amount it repays all the buy covers of what is owed.
+// Each loan repaid gets a payoff record linked to the buy, posted
+// against the stock bought since no cash came in. Run it in the same
+// transaction as the insert of the buy.
+func Offset(ctx context.Context, transaction *models.Transaction) ([]models.Loan, []*models.LoanAccount, error) {
+	if transaction.Kind != "buy" {
+		return nil, nil, ErrOffsetNotBuy
+	}
+
+	var accounts []*models.LoanAccount
+
+	if transaction.LoanAccountID.IsZero() {
+		open, err := openAccounts(ctx, transaction.CustomerID, transaction.Currency)
+		if err != nil {
+			return nil, nil, err
+		}
+		accounts = open
+	} else {
+		account, err := AccountFor(ctx, transaction.LoanAccountID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if account.CustomerID != transaction.CustomerID || account.Currency != transaction.Currency {
+			return nil, nil, ErrAccountMismatch
+		}
+		if closed(account) || account.Outstanding.Sign() <= 0 {
+			return nil, nil, ErrAccountClosed
+		}
+		accounts = []*models.LoanAccount{account}
+	}
+
+	if len(accounts) == 0 {
+		return nil, nil, ErrNoOpenAccount
+	}
+
+	owed := models.NewDecimal(0, 0)
+	for _, account := range accounts {
+		owed = owed.Add(account.Outstanding)
+	}
+
+	amount := transaction.LoanOffset
+	if !amount.IsSet() || amount.Sign() == 0 {
+		amount = owed
+		if amount.Cmp(transaction.Amount) > 0 {
+			amount = transaction.Amount
+		}
+	}
+
+	if amount.Cmp(transaction.Amount) > 0 {
+		return nil, nil, ErrOffsetExceedsBuy
+	}
+	if amount.Cmp(owed) > 0 {
+		return nil, nil, ErrOverpayment
+	}
+
+	loans := []models.Loan{}
+	repaid := []*models.LoanAccount{}
+	left := amount
+
+	for _, account := range accounts {
+		if left.Sign() <= 0 {
+			break
+		}
+
+		part := left
+		if part.Cmp(account.Outstanding) > 0 {
+			part = account.Outstanding
+		}
+		left = left.Sub(part)
+
+		loan := models.Loan{
+			ID:            primitive.NewObjectID(),
+			AssociateID:   transaction.AssociateID,
+			BranchID:      transaction.BranchID,
+			CustomerID:    transaction.CustomerID,
+			Amount:        part,
+			Currency:      transaction.Currency,
+			Type:          TypePayoff,
+			AccountID:     account.ID,
+			TransactionID: &transaction.ID,
+			CreatedAt:     transaction.CreatedAt,
+			UpdatedAt:     time.Now(),
+		}
+
+		updated, err := Repay(ctx, &loan)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, err := database.Database.Collection(models.Collection.Loan).InsertOne(ctx, loan); err != nil {
+			return nil, nil, err
+		}
+
+		if err := Post(ctx, &loan, updated); err != nil {
+			return nil, nil, err
+		}
+
+		loans = append(loans, loan)
+		repaid = append(repaid, updated)
+		transaction.RepaymentIDs = append(transaction.RepaymentIDs, loan.ID)
+	}
+
+	transaction.LoanOffset = amount
+	transaction.NetAmount = transaction.Amount.Sub(amount)
+
+	return loans, repaid, nil
+}
+
+// openAccounts lists a customer's open loans in a currency, oldest first
+func openAccounts(ctx context.Context, customerID primitive.ObjectID, currency string) ([]*models.LoanAccount, error) {
+	cursor, err := database.Database.Collection(models.Collection.LoanAccount).Find(ctx,
+		database.ExcludeVoided(bson.M{"customer_id": customerID, "currency": currency, "status": StatusActive}),
+		options.Find().SetSort(bson.D{{Key: "disbursed_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []*models.LoanAccount{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, account := range accounts {
+		account.Status = StatusOf(account, now)
+	}
+
+	return accounts, nil
+}
@@ -0,0 +1,106 @@
+package lending
+
+import (
+	"context"
+	"log"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OpenLegacyAccounts builds loan accounts from the loan records written
+// before accounts were kept. Each credit opens an account and each payoff
+// is taken against the customer's oldest loan in its currency it fits in.
+// The "debit" records older clients wrote for repayments become payoffs.
+// It does nothing once an account exists.
+func OpenLegacyAccounts(ctx context.Context) error {
+	count, err := database.Database.Collection(models.Collection.LoanAccount).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	result, err := database.Database.Collection(models.Collection.Loan).UpdateMany(ctx,
+		bson.M{"type": "debit"},
+		bson.M{"$set": bson.M{"type": TypePayoff}},
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("[ MIGRATE ] loan: %d debit records are now payoffs", result.ModifiedCount)
+
+	cursor, err := database.Database.Collection(models.Collection.Loan).Find(ctx,
+		database.ExcludeVoided(bson.M{"account_id": bson.M{"$exists": false}}),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+
+	var loans []models.Loan
+	if err := cursor.All(ctx, &loans); err != nil {
+		return err
+	}
+
+	accounts := []*models.LoanAccount{}
+	skipped := 0
+
+	for i := range loans {
+		loan := &loans[i]
+
+		switch loan.Type {
+		case TypeCredit:
+			account, err := newAccount(loan)
+			if err != nil {
+				return err
+			}
+			accounts = append(accounts, account)
+			loan.AccountID = account.ID
+
+		case TypePayoff:
+			for _, account := range accounts {
+				if account.CustomerID != loan.CustomerID || account.Currency != loan.Currency || account.Outstanding.Cmp(loan.Amount) < 0 {
+					continue
+				}
+				account.Repayments = append(account.Repayments, models.LoanRepayment{
+					LoanID:      loan.ID,
+					AssociateID: loan.AssociateID,
+					Amount:      loan.Amount,
+					PaidAt:      loan.CreatedAt,
+				})
+				allocate(account)
+				account.Status = stored(account)
+				loan.AccountID = account.ID
+				break
+			}
+		}
+
+		if loan.AccountID.IsZero() {
+			log.Printf("[ MIGRATE ] loan %s: %s of %s fits no loan account, skipped", loan.ID.Hex(), loan.Type, loan.Amount)
+			skipped++
+			continue
+		}
+
+		_, err := database.Database.Collection(models.Collection.Loan).UpdateOne(ctx,
+			bson.M{"_id": loan.ID},
+			bson.M{"$set": bson.M{"account_id": loan.AccountID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, account := range accounts {
+		if _, err := database.Database.Collection(models.Collection.LoanAccount).InsertOne(ctx, account); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[ MIGRATE ] loan: %d accounts opened from %d records, %d skipped", len(accounts), len(loans), skipped)
+
+	return nil
+}
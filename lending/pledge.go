@@ -0,0 +1,250 @@
+package lending
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/catalogue"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/pricing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of a pledge
+const (
+	PledgeHeld      = "held"
+	PledgeReleased  = "released"
+	PledgeForfeited = "forfeited"
+)
+
+var (
+	ErrLoanToValue        = errors.New("the loan is more than its collateral allows")
+	ErrNoCollateral       = errors.New("the loan has no collateral held")
+	ErrNotInDefault       = errors.New("only an overdue loan can be forfeited")
+	ErrCollateralHeld     = errors.New("the loan's collateral is still held, forfeit it instead")
+	ErrCollateralReleased = errors.New("the loan's collateral has been released, it cannot be reopened")
+)
+
+// default limit when LOAN_TO_VALUE_PERCENT is not set
+var defaultLoanToValue = models.NewDecimal(70, 0)
+
+var hundred = models.NewDecimal(100, 0)
+
+// MaxLoanToValue is the most, in percent of the value of its collateral,
+// that can be lent against pledges. It is read from LOAN_TO_VALUE_PERCENT.
+func MaxLoanToValue() models.Decimal {
+	limit, err := models.ParseDecimal(os.Getenv("LOAN_TO_VALUE_PERCENT"))
+	if err != nil || limit.Sign() <= 0 {
+		return defaultLoanToValue
+	}
+	return limit
+}
+
+// valuePledges values a credit's pledges at the board's current buy rate in
+// the loan's currency and checks the loan is within the loan-to-value limit.
+// A credit without pledges is unsecured and is not checked.
+func valuePledges(ctx context.Context, loan *models.Loan) (models.Decimal, models.Decimal, error) {
+	if len(loan.Pledges) == 0 {
+		return models.Decimal{}, models.Decimal{}, nil
+	}
+
+	value := models.NewDecimal(0, 0)
+
+	for i := range loan.Pledges {
+		pledge := &loan.Pledges[i]
+
+		mineral, grade, err := catalogue.Describe(ctx, pledge.Mineral, pledge.Grade, nil, false)
+		if err != nil {
+			return models.Decimal{}, models.Decimal{}, err
+		}
+
+		board, err := pricing.BoardRateFor(ctx, "buy", mineral, grade, pledge.Scale, loan.Currency, time.Now())
+		if err != nil {
+			return models.Decimal{}, models.Decimal{}, err
+		}
+
+		quote, err := pricing.Price(ctx, pledge.Scale, mineral, pledge.Weight, board.Rate)
+		if err != nil {
+			return models.Decimal{}, models.Decimal{}, err
+		}
+
+		pledge.ID = primitive.NewObjectID()
+		pledge.Mineral, pledge.Grade, pledge.Scale, pledge.Factor = mineral, grade, quote.Scale, quote.Factor
+		pledge.BoardRateID = board.ID
+		pledge.Rate = board.Rate
+		pledge.Value = quote.Amount
+		pledge.Status = PledgeHeld
+		pledge.ClosedAt = nil
+
+		value = value.Add(quote.Amount)
+	}
+
+	if value.Sign() <= 0 {
+		return models.Decimal{}, models.Decimal{}, ErrLoanToValue
+	}
+
+	ratio, err := loan.Amount.Mul(hundred).Div(value, moneyPlaces)
+	if err != nil {
+		return models.Decimal{}, models.Decimal{}, err
+	}
+	if ratio.Cmp(MaxLoanToValue()) > 0 {
+		return models.Decimal{}, models.Decimal{}, fmt.Errorf("%w: %s%% of %s pledged, at most %s%%", ErrLoanToValue, ratio, value, MaxLoanToValue())
+	}
+
+	return value, ratio, nil
+}
+
+// closePledges marks the pledges still held as released or forfeited
+func closePledges(account *models.LoanAccount, status string) {
+	now := time.Now()
+	for i := range account.Pledges {
+		if account.Pledges[i].Status == PledgeHeld {
+			account.Pledges[i].Status = status
+			account.Pledges[i].ClosedAt = &now
+		}
+	}
+}
+
+func holdsPledges(account *models.LoanAccount, status string) bool {
+	for _, pledge := range account.Pledges {
+		if pledge.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Forfeit takes the collateral of an overdue loan into the stock of its
+// branch and closes the loan. The gold is stocked at the principal still
+// owed, shared over the pledges by value; unpaid fees and interest were
+// never earned and are dropped.
+func Forfeit(ctx context.Context, accountID primitive.ObjectID, associateID primitive.ObjectID, reason string) (*models.LoanAccount, error) {
+	account, err := AccountFor(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if closed(account) || account.Outstanding.Sign() <= 0 {
+		return nil, ErrAccountClosed
+	}
+	if !holdsPledges(account, PledgeHeld) {
+		return nil, ErrNoCollateral
+	}
+	if account.Status != StatusOverdue {
+		return nil, ErrNotInDefault
+	}
+
+	principal := account.Principal.Sub(account.PrincipalPaid)
+	if principal.Sign() < 0 {
+		principal = models.NewDecimal(0, 0)
+	}
+
+	held := models.NewDecimal(0, 0)
+	for _, pledge := range account.Pledges {
+		if pledge.Status == PledgeHeld {
+			held = held.Add(pledge.Value)
+		}
+	}
+
+	source := inventory.Source{
+		AssociateID:   associateID,
+		BranchID:      account.BranchID,
+		ReferenceID:   account.ID,
+		ReferenceType: models.Collection.LoanAccount,
+	}
+
+	remaining := principal
+	last := -1
+	for i := range account.Pledges {
+		if account.Pledges[i].Status == PledgeHeld {
+			last = i
+		}
+	}
+
+	for i, pledge := range account.Pledges {
+		if pledge.Status != PledgeHeld {
+			continue
+		}
+
+		// the last pledge takes what rounding leaves
+		cost := remaining
+		if i != last {
+			cost, err = principal.Mul(pledge.Value).Div(held, moneyPlaces)
+			if err != nil {
+				return nil, err
+			}
+		}
+		remaining = remaining.Sub(cost)
+
+		// the factor it was weighed with, the scale's own for pledges taken
+		// before it was kept
+		factor := pledge.Factor
+		if !factor.IsSet() {
+			scale, err := pricing.ScaleFor(ctx, pledge.Scale)
+			if err != nil {
+				return nil, err
+			}
+			factor = scale.Factor
+		}
+		weight := pledge.Weight.Mul(factor)
+
+		fineWeight, err := inventory.FineWeightOf(ctx, pledge.Mineral, pledge.Grade, weight)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := inventory.Receive(ctx, inventory.KindForfeit, pledge.Mineral, pledge.Grade, weight, fineWeight, cost, account.Currency, source); err != nil {
+			return nil, err
+		}
+	}
+
+	closePledges(account, PledgeForfeited)
+	account.Forfeiture = &models.LoanWriteOff{
+		WrittenOffBy: associateID,
+		WrittenOffAt: time.Now(),
+		Amount:       account.Outstanding,
+		Reason:       reason,
+	}
+	account.Status = StatusForfeited
+
+	result, err := database.Database.Collection(models.Collection.LoanAccount).UpdateOne(ctx,
+		bson.M{
+			"_id":         account.ID,
+			"write_off":   bson.M{"$exists": false},
+			"forfeiture":  bson.M{"$exists": false},
+			"outstanding": account.Outstanding,
+		},
+		bson.M{"$set": bson.M{"forfeiture": account.Forfeiture, "pledges": account.Pledges, "status": account.Status, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrAccountChanged
+	}
+
+	if principal.Sign() <= 0 {
+		return account, nil
+	}
+
+	_, err = ledger.Record(ctx, ledger.EventLoanForfeit, principal, ledger.Source{
+		AssociateID:   associateID,
+		BranchID:      account.BranchID,
+		ReferenceID:   account.ID,
+		ReferenceType: models.Collection.LoanAccount,
+		Description:   "Forfeiture: " + reason,
+		OccurredAt:    account.Forfeiture.WrittenOffAt,
+		Currency:      account.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
@@ -0,0 +1,267 @@
+package lending
+
+import (
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+)
+
+// Interest methods
+const (
+	MethodFlat     = "flat"     // charged on the principal for the whole term
+	MethodReducing = "reducing" // charged on the principal still owed, in equal instalments
+)
+
+// Instalment frequencies
+const (
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// Statuses of an instalment
+const (
+	InstalmentPaid     = "paid"
+	InstalmentPartial  = "partial"
+	InstalmentDue      = "due"
+	InstalmentOverdue  = "overdue"
+	InstalmentUpcoming = "upcoming"
+)
+
+// precision of money and of the interest rate per period
+const (
+	moneyPlaces = 2
+	ratePlaces  = 12
+)
+
+// AmortisationLine is an instalment of a loan's schedule with the principal
+// owed before it and how far it has been paid.
+type AmortisationLine struct {
+	models.LoanInstalment
+	Opening     models.Decimal `json:"opening"` // principal owed before the instalment
+	Paid        models.Decimal `json:"paid"`
+	Outstanding models.Decimal `json:"outstanding"`
+	Status      string         `json:"status"` // paid, partial, due, overdue, upcoming
+}
+
+// BuildSchedule lays out the instalments that repay principal lent on terms
+// from disbursedAt. The first instalment carries the fee; the last takes
+// what rounding leaves of the principal.
+func BuildSchedule(principal models.Decimal, terms *models.LoanTerms, disbursedAt time.Time) ([]models.LoanInstalment, error) {
+	zero := models.NewDecimal(0, 0)
+	one := models.NewDecimal(1, 0)
+	n := models.NewDecimal(int64(terms.Instalments), 0)
+
+	perYear := int64(12)
+	if terms.Frequency == FrequencyWeekly {
+		perYear = 52
+	}
+
+	rate, err := terms.Rate.Div(models.NewDecimal(100*perYear, 0), ratePlaces)
+	if err != nil {
+		return nil, err
+	}
+
+	first := advance(disbursedAt, terms.Frequency, 1)
+	if terms.FirstDueAt != nil {
+		first = *terms.FirstDueAt
+	}
+
+	// what each instalment pays of the principal and the interest
+	var payment, totalInterest, flatInterest, flatPrincipal models.Decimal
+
+	if terms.Method == MethodFlat {
+		totalInterest = principal.Mul(rate).Mul(n).Round(moneyPlaces)
+		if flatInterest, err = totalInterest.Div(n, moneyPlaces); err != nil {
+			return nil, err
+		}
+		if flatPrincipal, err = principal.Div(n, moneyPlaces); err != nil {
+			return nil, err
+		}
+	} else if rate.Sign() == 0 {
+		if payment, err = principal.Div(n, moneyPlaces); err != nil {
+			return nil, err
+		}
+	} else {
+		// equal instalments: principal × r × (1+r)^n / ((1+r)^n - 1)
+		growth := one
+		for i := 0; i < terms.Instalments; i++ {
+			growth = growth.Mul(one.Add(rate)).Round(ratePlaces)
+		}
+		if payment, err = principal.Mul(rate).Mul(growth).Div(growth.Sub(one), moneyPlaces); err != nil {
+			return nil, err
+		}
+	}
+
+	schedule := make([]models.LoanInstalment, 0, terms.Instalments)
+	balance := principal
+
+	for i := 0; i < terms.Instalments; i++ {
+		last := i == terms.Instalments-1
+
+		var interest, part models.Decimal
+		if terms.Method == MethodFlat {
+			interest, part = flatInterest, flatPrincipal
+			if last {
+				interest, part = totalInterest.Sub(flatInterest.Mul(models.NewDecimal(int64(i), 0))), balance
+			}
+		} else {
+			interest = balance.Mul(rate).Round(moneyPlaces)
+			part = payment.Sub(interest)
+			if part.Sign() < 0 {
+				part = zero
+			}
+			if last || part.Cmp(balance) > 0 {
+				part = balance
+			}
+		}
+
+		fee := zero
+		if i == 0 && terms.Fee.Sign() > 0 {
+			fee = terms.Fee
+		}
+
+		balance = balance.Sub(part)
+
+		schedule = append(schedule, models.LoanInstalment{
+			Number:        i + 1,
+			DueAt:         advance(first, terms.Frequency, i),
+			Fee:           fee,
+			Interest:      interest,
+			Principal:     part,
+			Total:         fee.Add(interest).Add(part),
+			Balance:       balance,
+			FeePaid:       zero,
+			InterestPaid:  zero,
+			PrincipalPaid: zero,
+		})
+	}
+
+	return schedule, nil
+}
+
+// advance moves t on by periods weeks or months; a month on from the 31st
+// falls on the last day of a shorter month rather than spilling into the next
+func advance(t time.Time, frequency string, periods int) time.Time {
+	if frequency == FrequencyWeekly {
+		return t.AddDate(0, 0, 7*periods)
+	}
+
+	month := time.Date(t.Year(), t.Month()+time.Month(periods), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := month.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return month.AddDate(0, 0, day-1)
+}
+
+// allocate replays an account's repayments over its schedule in the order
+// they were taken. Each goes to the fee, then the interest, then the
+// principal of the earliest instalment not yet paid; a loan without a
+// schedule is all principal. It works out what is paid, what is
+// outstanding and when the next instalment falls due.
+func allocate(account *models.LoanAccount) {
+	zero := models.NewDecimal(0, 0)
+
+	if account.Schedule == nil {
+		account.Schedule = []models.LoanInstalment{}
+	}
+
+	account.Fees, account.Interest = zero, zero
+	for i := range account.Schedule {
+		instalment := &account.Schedule[i]
+		instalment.FeePaid, instalment.InterestPaid, instalment.PrincipalPaid = zero, zero, zero
+		account.Fees = account.Fees.Add(instalment.Fee)
+		account.Interest = account.Interest.Add(instalment.Interest)
+	}
+
+	account.Repaid, account.FeesPaid, account.InterestPaid, account.PrincipalPaid = zero, zero, zero, zero
+
+	for i := range account.Repayments {
+		repayment := &account.Repayments[i]
+		left := repayment.Amount
+		repayment.Fee, repayment.Interest, repayment.Principal = zero, zero, zero
+
+		// take moves what left can cover of due - paid into paid
+		take := func(due models.Decimal, paid *models.Decimal) models.Decimal {
+			owed := due.Sub(*paid)
+			if owed.Sign() <= 0 || left.Sign() <= 0 {
+				return zero
+			}
+			if owed.Cmp(left) > 0 {
+				owed = left
+			}
+			*paid = paid.Add(owed)
+			left = left.Sub(owed)
+			return owed
+		}
+
+		for j := range account.Schedule {
+			instalment := &account.Schedule[j]
+			repayment.Fee = repayment.Fee.Add(take(instalment.Fee, &instalment.FeePaid))
+			repayment.Interest = repayment.Interest.Add(take(instalment.Interest, &instalment.InterestPaid))
+			repayment.Principal = repayment.Principal.Add(take(instalment.Principal, &instalment.PrincipalPaid))
+		}
+		repayment.Principal = repayment.Principal.Add(left)
+
+		account.Repaid = account.Repaid.Add(repayment.Amount)
+		account.FeesPaid = account.FeesPaid.Add(repayment.Fee)
+		account.InterestPaid = account.InterestPaid.Add(repayment.Interest)
+		account.PrincipalPaid = account.PrincipalPaid.Add(repayment.Principal)
+	}
+
+	account.Outstanding = account.Principal.Add(account.Fees).Add(account.Interest).Sub(account.Repaid)
+
+	account.NextDueAt = nil
+	if account.Outstanding.Sign() > 0 {
+		account.NextDueAt = account.DueAt
+		for i := range account.Schedule {
+			if instalmentPaid(&account.Schedule[i]).Cmp(account.Schedule[i].Total) < 0 {
+				due := account.Schedule[i].DueAt
+				account.NextDueAt = &due
+				break
+			}
+		}
+	}
+}
+
+func instalmentPaid(instalment *models.LoanInstalment) models.Decimal {
+	return instalment.FeePaid.Add(instalment.InterestPaid).Add(instalment.PrincipalPaid)
+}
+
+// Amortisation is an account's schedule as of the given moment, with how
+// far each instalment has been paid.
+func Amortisation(account *models.LoanAccount, at time.Time) []AmortisationLine {
+	lines := make([]AmortisationLine, 0, len(account.Schedule))
+	opening := account.Principal
+
+	for _, instalment := range account.Schedule {
+		paid := instalmentPaid(&instalment)
+		outstanding := instalment.Total.Sub(paid)
+
+		status := InstalmentUpcoming
+		switch {
+		case outstanding.Sign() <= 0:
+			status = InstalmentPaid
+		case instalment.DueAt.Before(at):
+			status = InstalmentOverdue
+		case paid.Sign() > 0:
+			status = InstalmentPartial
+		case account.NextDueAt != nil && instalment.DueAt.Equal(*account.NextDueAt):
+			status = InstalmentDue
+		}
+
+		lines = append(lines, AmortisationLine{
+			LoanInstalment: instalment,
+			Opening:        opening,
+			Paid:           paid,
+			Outstanding:    outstanding,
+			Status:         status,
+		})
+		opening = instalment.Balance
+	}
+
+	return lines
+}
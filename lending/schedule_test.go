@@ -0,0 +1,245 @@
+package lending
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func dec(s string) models.Decimal {
+	return models.MustParseDecimal(s)
+}
+
+// instalment is the fee, interest, principal, total and balance expected of
+// one line of a schedule
+type instalment [5]string
+
+func TestBuildSchedule(t *testing.T) {
+	disbursed := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		principal string
+		terms     models.LoanTerms
+		want      []instalment
+		dueAt     []time.Time
+	}{
+		{
+			name:      "flat monthly with a fee on the first instalment",
+			principal: "1000",
+			terms:     models.LoanTerms{Method: MethodFlat, Rate: dec("12"), Frequency: FrequencyMonthly, Instalments: 3, Fee: dec("10")},
+			want: []instalment{
+				{"10", "10", "333.33", "353.33", "666.67"},
+				{"0", "10", "333.33", "343.33", "333.34"},
+				{"0", "10", "333.34", "343.34", "0"},
+			},
+			dueAt: []time.Time{
+				time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:      "flat interest that does not split evenly rounds on the last instalment",
+			principal: "1000",
+			terms:     models.LoanTerms{Method: MethodFlat, Rate: dec("10"), Frequency: FrequencyMonthly, Instalments: 3},
+			want: []instalment{
+				{"0", "8.33", "333.33", "341.66", "666.67"},
+				{"0", "8.33", "333.33", "341.66", "333.34"},
+				{"0", "8.34", "333.34", "341.68", "0"},
+			},
+		},
+		{
+			name:      "reducing monthly, the last instalment takes what rounding leaves",
+			principal: "1000",
+			terms:     models.LoanTerms{Method: MethodReducing, Rate: dec("12"), Frequency: FrequencyMonthly, Instalments: 3},
+			want: []instalment{
+				{"0", "10", "330.02", "340.02", "669.98"},
+				{"0", "6.70", "333.32", "340.02", "336.66"},
+				{"0", "3.37", "336.66", "340.03", "0"},
+			},
+		},
+		{
+			name:      "reducing weekly without interest",
+			principal: "1000",
+			terms:     models.LoanTerms{Method: MethodReducing, Rate: dec("0"), Frequency: FrequencyWeekly, Instalments: 3},
+			want: []instalment{
+				{"0", "0", "333.33", "333.33", "666.67"},
+				{"0", "0", "333.33", "333.33", "333.34"},
+				{"0", "0", "333.34", "333.34", "0"},
+			},
+			dueAt: []time.Time{
+				time.Date(2026, 1, 22, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 1, 29, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 2, 5, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := BuildSchedule(dec(tt.principal), &tt.terms, disbursed)
+			if err != nil {
+				t.Fatalf("BuildSchedule: %v", err)
+			}
+			if len(schedule) != len(tt.want) {
+				t.Fatalf("%d instalments, want %d", len(schedule), len(tt.want))
+			}
+
+			for i, line := range schedule {
+				got := [5]models.Decimal{line.Fee, line.Interest, line.Principal, line.Total, line.Balance}
+				for j, name := range []string{"fee", "interest", "principal", "total", "balance"} {
+					if got[j].Cmp(dec(tt.want[i][j])) != 0 {
+						t.Errorf("instalment %d %s is %s, want %s", i+1, name, got[j], tt.want[i][j])
+					}
+				}
+				if line.Number != i+1 {
+					t.Errorf("instalment %d is numbered %d", i+1, line.Number)
+				}
+				if tt.dueAt != nil && !line.DueAt.Equal(tt.dueAt[i]) {
+					t.Errorf("instalment %d is due %s, want %s", i+1, line.DueAt, tt.dueAt[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAdvanceKeepsToTheEndOfAShortMonth(t *testing.T) {
+	from := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	if got, want := advance(from, FrequencyMonthly, 1), time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("a month on from %s is %s, want %s", from, got, want)
+	}
+	if got, want := advance(from, FrequencyMonthly, 2), time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("two months on from %s is %s, want %s", from, got, want)
+	}
+}
+
+// scheduledAccount is a loan of 1000 at 12% flat over three months with a
+// fee of 10: 1040 owed in all.
+func scheduledAccount(t *testing.T) *models.LoanAccount {
+	t.Helper()
+
+	terms := &models.LoanTerms{Method: MethodFlat, Rate: dec("12"), Frequency: FrequencyMonthly, Instalments: 3, Fee: dec("10")}
+	schedule, err := BuildSchedule(dec("1000"), terms, time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("BuildSchedule: %v", err)
+	}
+
+	return &models.LoanAccount{Principal: dec("1000"), Terms: terms, Schedule: schedule}
+}
+
+func repayment(amount string) models.LoanRepayment {
+	return models.LoanRepayment{LoanID: primitive.NewObjectID(), Amount: dec(amount)}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name        string
+		repayments  []string
+		split       [][3]string // fee, interest and principal of each repayment
+		outstanding string
+		nextDue     int // index of the next instalment due, -1 for none
+	}{
+		{
+			name:        "nothing repaid",
+			outstanding: "1040",
+			nextDue:     0,
+		},
+		{
+			name:        "fee, then interest, then principal of the earliest instalment",
+			repayments:  []string{"400"},
+			split:       [][3]string{{"10", "20", "370"}},
+			outstanding: "640",
+			nextDue:     1,
+		},
+		{
+			name:        "repayments in turn carry on where the last stopped",
+			repayments:  []string{"400", "300"},
+			split:       [][3]string{{"10", "20", "370"}, {"0", "3.34", "296.66"}},
+			outstanding: "340",
+			nextDue:     2,
+		},
+		{
+			name:        "an overpayment goes to principal beyond the schedule",
+			repayments:  []string{"1100"},
+			split:       [][3]string{{"10", "30", "1060"}},
+			outstanding: "-60",
+			nextDue:     -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := scheduledAccount(t)
+			for _, amount := range tt.repayments {
+				account.Repayments = append(account.Repayments, repayment(amount))
+			}
+
+			allocate(account)
+
+			for i, split := range tt.split {
+				got := account.Repayments[i]
+				if got.Fee.Cmp(dec(split[0])) != 0 || got.Interest.Cmp(dec(split[1])) != 0 || got.Principal.Cmp(dec(split[2])) != 0 {
+					t.Errorf("repayment %d went %s/%s/%s to fee/interest/principal, want %s/%s/%s",
+						i+1, got.Fee, got.Interest, got.Principal, split[0], split[1], split[2])
+				}
+			}
+
+			if account.Outstanding.Cmp(dec(tt.outstanding)) != 0 {
+				t.Errorf("outstanding is %s, want %s", account.Outstanding, tt.outstanding)
+			}
+
+			switch {
+			case tt.nextDue < 0 && account.NextDueAt != nil:
+				t.Errorf("next due at %s, want none", account.NextDueAt)
+			case tt.nextDue >= 0 && (account.NextDueAt == nil || !account.NextDueAt.Equal(account.Schedule[tt.nextDue].DueAt)):
+				t.Errorf("next due at %v, want instalment %d", account.NextDueAt, tt.nextDue+1)
+			}
+		})
+	}
+}
+
+// TestAllocateAfterReversal takes back the first of two repayments, as
+// voiding it does, and expects the account to stand as if only the second
+// had been made.
+func TestAllocateAfterReversal(t *testing.T) {
+	account := scheduledAccount(t)
+	first, second := repayment("400"), repayment("200")
+	account.Repayments = []models.LoanRepayment{first, second}
+	allocate(account)
+
+	account.Repayments = []models.LoanRepayment{account.Repayments[1]}
+	allocate(account)
+
+	want := scheduledAccount(t)
+	want.Repayments = []models.LoanRepayment{second}
+	allocate(want)
+
+	got := account.Repayments[0]
+	if got.Fee.Cmp(dec("10")) != 0 || got.Interest.Cmp(dec("10")) != 0 || got.Principal.Cmp(dec("180")) != 0 {
+		t.Errorf("the repayment left went %s/%s/%s to fee/interest/principal, want 10/10/180", got.Fee, got.Interest, got.Principal)
+	}
+
+	if account.Outstanding.Cmp(dec("840")) != 0 {
+		t.Errorf("outstanding is %s, want 840", account.Outstanding)
+	}
+	if account.Repaid.Cmp(want.Repaid) != 0 || account.PrincipalPaid.Cmp(want.PrincipalPaid) != 0 ||
+		account.InterestPaid.Cmp(want.InterestPaid) != 0 || account.FeesPaid.Cmp(want.FeesPaid) != 0 {
+		t.Errorf("paid %s (%s/%s/%s), want %s (%s/%s/%s)",
+			account.Repaid, account.FeesPaid, account.InterestPaid, account.PrincipalPaid,
+			want.Repaid, want.FeesPaid, want.InterestPaid, want.PrincipalPaid)
+	}
+
+	for i := range account.Schedule {
+		if instalmentPaid(&account.Schedule[i]).Cmp(instalmentPaid(&want.Schedule[i])) != 0 {
+			t.Errorf("instalment %d has %s paid, want %s", i+1, instalmentPaid(&account.Schedule[i]), instalmentPaid(&want.Schedule[i]))
+		}
+	}
+
+	if account.NextDueAt == nil || !account.NextDueAt.Equal(account.Schedule[0].DueAt) {
+		t.Errorf("next due at %v, want the first instalment", account.NextDueAt)
+	}
+}
@@ -36,6 +36,10 @@ func main() {
 		}
 	}()
 
+	if err := database.EnsureIndexes(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
 	// routes controller
 	router := routers.SetupRouter()
 
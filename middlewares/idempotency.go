@@ -0,0 +1,161 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	ReplayedHeader    = "Idempotent-Replayed"
+
+	// default retention when IDEMPOTENCY_RETENTION_HOURS is not set
+	defaultIdempotencyRetention = 24 * time.Hour
+)
+
+// idempotencyRetention is how long a key and its response are kept.
+func idempotencyRetention() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_RETENTION_HOURS"))
+	if err != nil || hours <= 0 {
+		return defaultIdempotencyRetention
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// responseRecorder keeps a copy of everything the handler writes.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+// Idempotent makes a POST safe to retry. When the request carries an
+// Idempotency-Key header the first response for that key is stored and
+// returned again for every retry within the retention window; reusing the
+// key for a different request is rejected. Requests without the header are
+// handled as before. It must run after AuthMiddleware since keys are kept
+// per associate.
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		auth, exist := c.Get("auth")
+		authInfo, ok := auth.(*utils.Authentication)
+		if !exist || !ok {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Invalid authentication data"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+
+		now := time.Now()
+		record := models.IdempotencyRecord{
+			ID:          authInfo.ID + ":" + key,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			Status:      "pending",
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyRetention()),
+		}
+
+		collection := database.Database.Collection(models.Collection.Idempotency)
+
+		// records past their window may outlive it until MongoDB reaps them
+		_, _ = collection.DeleteOne(c, bson.M{"_id": record.ID, "expires_at": bson.M{"$lte": now}})
+
+		if _, err := collection.InsertOne(c, record); mongo.IsDuplicateKeyError(err) {
+			var stored models.IdempotencyRecord
+			if err := collection.FindOne(c, bson.M{"_id": record.ID}).Decode(&stored); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+
+			switch {
+			case stored.RequestHash != record.RequestHash:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case stored.Status != "complete":
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			default:
+				c.Header(ReplayedHeader, "true")
+				c.Data(stored.ResponseStatus, stored.ContentType, []byte(stored.ResponseBody))
+			}
+			c.Abort()
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		// a key whose response is not stored is dropped so the client can
+		// retry it, also when the handler panics
+		completed := false
+		defer func() {
+			if !completed {
+				_, _ = collection.DeleteOne(context.Background(), bson.M{"_id": record.ID})
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		// server errors are not kept so the client can retry them
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+
+		_, err = collection.UpdateOne(c, bson.M{"_id": record.ID}, bson.M{"$set": bson.M{
+			"status":          "complete",
+			"response_status": status,
+			"response_body":   recorder.body.String(),
+			"content_type":    recorder.Header().Get("Content-Type"),
+		}})
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			_ = c.Error(err)
+			return
+		}
+		completed = true
+	}
+}
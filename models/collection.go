@@ -1,23 +1,63 @@
 package models
 
 type Collections struct {
-	Transaction   string
-	Associate     string
-	Loan          string
-	Miscellaneous string
-	Customer      string
-	Fund          string
-	Balance       string
-	Stash         string
+	Transaction    string
+	Associate      string
+	Loan           string
+	Miscellaneous  string
+	Customer       string
+	Fund           string
+	Balance        string
+	Stash          string
+	Ledger         string
+	Branch         string
+	Float          string
+	FloatTransfer  string
+	TillClose      string
+	Period         string
+	PeriodAudit    string
+	BalanceHistory string
+	Idempotency    string
+	ExchangeRate   string
+	Scale          string
+	Mineral        string
+	Grade          string
+	BoardRate      string
+	Density        string
+	Stock          string
+	StockMovement  string
+	Assay          string
+	MeltBatch      string
+	LoanAccount    string
 }
 
 var Collection = Collections{
-	Transaction:   "transaction",
-	Associate:     "associate",
-	Loan:          "loan",
-	Miscellaneous: "miscellaneous",
-	Customer:      "customer",
-	Balance:       "balance",
-	Fund:          "fund",
-	Stash:         "stash",
+	Transaction:    "transaction",
+	Associate:      "associate",
+	Loan:           "loan",
+	Miscellaneous:  "miscellaneous",
+	Customer:       "customer",
+	Balance:        "balance",
+	Fund:           "fund",
+	Stash:          "stash",
+	Ledger:         "ledger",
+	Branch:         "branch",
+	Float:          "float",
+	FloatTransfer:  "float_transfer",
+	TillClose:      "till_close",
+	Period:         "period",
+	PeriodAudit:    "period_audit",
+	BalanceHistory: "balance_history",
+	Idempotency:    "idempotency",
+	ExchangeRate:   "exchange_rate",
+	Scale:          "scale",
+	Mineral:        "mineral",
+	Grade:          "grade",
+	BoardRate:      "board_rate",
+	Density:        "density",
+	Stock:          "stock",
+	StockMovement:  "stock_movement",
+	Assay:          "assay",
+	MeltBatch:      "melt_batch",
+	LoanAccount:    "loan_account",
 }
@@ -0,0 +1,327 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Decimal is an exact base-10 number used for every money and weight field.
+// It is stored in MongoDB as Decimal128 and sent over JSON as a string, so
+// amounts never pass through binary floating point.
+//
+// The zero value is an unset decimal; use NewDecimal(0, 0) for the number 0.
+type Decimal struct {
+	coef *big.Int
+	exp  int
+}
+
+var ErrInvalidDecimal = errors.New("invalid decimal value")
+
+var bigTen = big.NewInt(10)
+
+// NewDecimal returns the decimal value coef * 10^exp.
+func NewDecimal(coef int64, exp int) Decimal {
+	return Decimal{coef: big.NewInt(coef), exp: exp}
+}
+
+// ParseDecimal parses a plain decimal string such as "1250.75".
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Decimal{}, ErrInvalidDecimal
+	}
+
+	d128, err := primitive.ParseDecimal128(s)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("%w: %q", ErrInvalidDecimal, s)
+	}
+
+	return decimalFrom128(d128)
+}
+
+// MustParseDecimal is like ParseDecimal but panics on error. It is meant for
+// constants in code.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func decimalFrom128(d128 primitive.Decimal128) (Decimal, error) {
+	coef, exp, err := d128.BigInt()
+	if err != nil {
+		return Decimal{}, fmt.Errorf("%w: %s", ErrInvalidDecimal, d128.String())
+	}
+	return Decimal{coef: coef, exp: exp}, nil
+}
+
+// IsSet reports whether d holds a value.
+func (d Decimal) IsSet() bool {
+	return d.coef != nil
+}
+
+// IsZero reports whether d is unset. It lets bson omitempty skip unset fields;
+// use Sign to test for the number zero.
+func (d Decimal) IsZero() bool {
+	return d.coef == nil
+}
+
+func (d Decimal) big() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// rescale returns the coefficient of d expressed with the smaller exponent exp.
+func (d Decimal) rescale(exp int) *big.Int {
+	coef := new(big.Int).Set(d.big())
+	if d.exp > exp {
+		coef.Mul(coef, new(big.Int).Exp(bigTen, big.NewInt(int64(d.exp-exp)), nil))
+	}
+	return coef
+}
+
+func align(x, y Decimal) (*big.Int, *big.Int, int) {
+	exp := x.exp
+	if y.exp < exp {
+		exp = y.exp
+	}
+	return x.rescale(exp), y.rescale(exp), exp
+}
+
+// Add returns d + other.
+func (d Decimal) Add(other Decimal) Decimal {
+	x, y, exp := align(d, other)
+	return Decimal{coef: x.Add(x, y), exp: exp}
+}
+
+// Sub returns d - other.
+func (d Decimal) Sub(other Decimal) Decimal {
+	x, y, exp := align(d, other)
+	return Decimal{coef: x.Sub(x, y), exp: exp}
+}
+
+// Mul returns d * other without rounding.
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.big(), other.big()), exp: d.exp + other.exp}
+}
+
+// Div returns d / other rounded half away from zero to the given number of
+// decimal places. Dividing by zero returns an error.
+func (d Decimal) Div(other Decimal, places int) (Decimal, error) {
+	if other.Sign() == 0 {
+		return Decimal{}, errors.New("decimal division by zero")
+	}
+
+	// d/other = (cd/co) * 10^(ed-eo); scale so the quotient has -places exponent.
+	num := new(big.Int).Set(d.big())
+	den := new(big.Int).Set(other.big())
+	shift := d.exp - other.exp + places
+	if shift >= 0 {
+		num.Mul(num, new(big.Int).Exp(bigTen, big.NewInt(int64(shift)), nil))
+	} else {
+		den.Mul(den, new(big.Int).Exp(bigTen, big.NewInt(int64(-shift)), nil))
+	}
+
+	return Decimal{coef: quoRound(num, den), exp: -places}, nil
+}
+
+// Round returns d rounded half away from zero to the given number of places.
+func (d Decimal) Round(places int) Decimal {
+	if d.exp >= -places {
+		return Decimal{coef: d.rescale(-places), exp: -places}
+	}
+	den := new(big.Int).Exp(bigTen, big.NewInt(int64(-places-d.exp)), nil)
+	return Decimal{coef: quoRound(new(big.Int).Set(d.big()), den), exp: -places}
+}
+
+func quoRound(num, den *big.Int) *big.Int {
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo
+	}
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	if twice.Cmp(new(big.Int).Abs(den)) >= 0 {
+		if (num.Sign() < 0) != (den.Sign() < 0) {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return quo
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.big()), exp: d.exp}
+}
+
+// Abs returns |d|.
+func (d Decimal) Abs() Decimal {
+	return Decimal{coef: new(big.Int).Abs(d.big()), exp: d.exp}
+}
+
+// Cmp compares d and other and returns -1, 0 or +1.
+func (d Decimal) Cmp(other Decimal) int {
+	x, y, _ := align(d, other)
+	return x.Cmp(y)
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d. Unset is 0.
+func (d Decimal) Sign() int {
+	return d.big().Sign()
+}
+
+// Float64 returns the nearest float64 to d. Only use it for display, such
+// as chart points, never for arithmetic.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String formats d in plain notation, keeping its scale ("12.50").
+func (d Decimal) String() string {
+	if d.coef == nil {
+		return ""
+	}
+
+	digits := new(big.Int).Abs(d.coef).String()
+	sign := ""
+	if d.coef.Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.exp >= 0 {
+		return sign + digits + strings.Repeat("0", d.exp)
+	}
+
+	places := -d.exp
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	point := len(digits) - places
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Decimal128 converts d for use in raw BSON queries.
+func (d Decimal) Decimal128() primitive.Decimal128 {
+	d128, ok := primitive.ParseDecimal128FromBigInt(d.big(), d.exp)
+	if !ok {
+		// Too many digits for Decimal128: keep the 34 most significant ones.
+		intDigits := len(new(big.Int).Abs(d.big()).String()) + d.exp
+		d128, _ = primitive.ParseDecimal128(d.Round(34 - intDigits).String())
+	}
+	return d128
+}
+
+// SumDecimals adds up all values, returning 0 for an empty list.
+func SumDecimals(values ...Decimal) Decimal {
+	total := NewDecimal(0, 0)
+	for _, v := range values {
+		total = total.Add(v)
+	}
+	return total
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d.coef == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts both "12.50" and 12.50.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		*d = Decimal{}
+		return nil
+	}
+
+	if strings.HasPrefix(s, "\"") {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Decimal) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if d.coef == nil {
+		return bsontype.Null, nil, nil
+	}
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, d.Decimal128()), nil
+}
+
+// UnmarshalBSONValue reads Decimal128 values as well as the string and
+// numeric values written before the decimal migration.
+func (d *Decimal) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*d = Decimal{}
+		return nil
+	case bsontype.Decimal128:
+		parsed, err := decimalFrom128(value.Decimal128())
+		if err != nil {
+			return err
+		}
+		*d = parsed
+	case bsontype.String:
+		if value.StringValue() == "" {
+			*d = Decimal{}
+			return nil
+		}
+		parsed, err := ParseDecimal(value.StringValue())
+		if err != nil {
+			return err
+		}
+		*d = parsed
+	case bsontype.Double:
+		parsed, err := ParseDecimal(strconv.FormatFloat(value.Double(), 'f', -1, 64))
+		if err != nil {
+			return err
+		}
+		*d = parsed
+	case bsontype.Int32:
+		*d = NewDecimal(int64(value.Int32()), 0)
+	case bsontype.Int64:
+		*d = NewDecimal(value.Int64(), 0)
+	default:
+		return fmt.Errorf("cannot decode %s into a decimal", t)
+	}
+
+	return nil
+}
+
+func init() {
+	// nonnegative rejects decimals below zero, positive rejects zero as well.
+	_ = ValidateStruct.RegisterValidation("nonnegative", func(fl validator.FieldLevel) bool {
+		d, ok := fl.Field().Interface().(Decimal)
+		return ok && d.Sign() >= 0
+	})
+	_ = ValidateStruct.RegisterValidation("positive", func(fl validator.FieldLevel) bool {
+		d, ok := fl.Field().Interface().(Decimal)
+		return ok && d.Sign() > 0
+	})
+}
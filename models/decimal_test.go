@@ -0,0 +1,250 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseDecimalKeepsScale(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1250.75", "1250.75"},
+		{"12.50", "12.50"},
+		{" 7 ", "7"},
+		{"-0.05", "-0.05"},
+		{"0.000", "0.000"},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q): %v", tt.in, err)
+			continue
+		}
+		if got := d.String(); got != tt.want {
+			t.Errorf("ParseDecimal(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1.2.3"} {
+		if _, err := ParseDecimal(in); !errors.Is(err, ErrInvalidDecimal) {
+			t.Errorf("ParseDecimal(%q) error = %v, want ErrInvalidDecimal", in, err)
+		}
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{NewDecimal(5, -2), "0.05"},
+		{NewDecimal(-5, -1), "-0.5"},
+		{NewDecimal(-125, -2), "-1.25"},
+		{NewDecimal(12, 2), "1200"},
+		{NewDecimal(0, 0), "0"},
+		{Decimal{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a, b := MustParseDecimal("10.25"), MustParseDecimal("0.5")
+
+	if got := a.Add(b).String(); got != "10.75" {
+		t.Errorf("10.25 + 0.5 = %s, want 10.75", got)
+	}
+	if got := b.Sub(a).String(); got != "-9.75" {
+		t.Errorf("0.5 - 10.25 = %s, want -9.75", got)
+	}
+	if got := a.Mul(b).String(); got != "5.125" {
+		t.Errorf("10.25 × 0.5 = %s, want 5.125", got)
+	}
+	if a.Cmp(MustParseDecimal("10.250")) != 0 {
+		t.Errorf("10.25 and 10.250 compare unequal")
+	}
+	if got := (Decimal{}).Add(b).String(); got != "0.5" {
+		t.Errorf("unset + 0.5 = %s, want 0.5", got)
+	}
+}
+
+func TestDecimalDiv(t *testing.T) {
+	tests := []struct {
+		num, den string
+		places   int
+		want     string
+	}{
+		{"10", "3", 2, "3.33"},
+		{"-10", "3", 2, "-3.33"},
+		{"2", "3", 2, "0.67"},
+		{"-2", "3", 2, "-0.67"},
+		{"1", "8", 2, "0.13"},   // half away from zero
+		{"1", "-8", 2, "-0.13"}, // and the same below zero
+		{"-1", "-8", 2, "0.13"}, // two negatives make a positive
+		{"1000", "0.3", 0, "3333"},
+		{"12.5", "0.5", 4, "25.0000"},
+	}
+
+	for _, tt := range tests {
+		got, err := MustParseDecimal(tt.num).Div(MustParseDecimal(tt.den), tt.places)
+		if err != nil {
+			t.Errorf("%s / %s: %v", tt.num, tt.den, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s / %s to %d places = %s, want %s", tt.num, tt.den, tt.places, got, tt.want)
+		}
+	}
+
+	if _, err := NewDecimal(5, 0).Div(NewDecimal(0, -2), 2); err == nil {
+		t.Errorf("dividing by zero did not fail")
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"2.49", 0, "2"},
+		{"-2.49", 0, "-2"},
+		{"1.005", 2, "1.01"},
+		{"-1.005", 2, "-1.01"},
+		{"1.004", 2, "1.00"},
+		{"7", 2, "7.00"},
+		{"1250", -2, "1300"},
+	}
+
+	for _, tt := range tests {
+		if got := MustParseDecimal(tt.in).Round(tt.places).String(); got != tt.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	type body struct {
+		Amount Decimal `json:"amount"`
+		Rate   Decimal `json:"rate"`
+		Unset  Decimal `json:"unset"`
+	}
+
+	var decoded body
+	if err := json.Unmarshal([]byte(`{"amount":"12.50","rate":0.75,"unset":null}`), &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Amount.String() != "12.50" || decoded.Rate.String() != "0.75" || decoded.Unset.IsSet() {
+		t.Errorf("decoded %s, %s, %q; want 12.50, 0.75 and unset", decoded.Amount, decoded.Rate, decoded.Unset.String())
+	}
+
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if want := `{"amount":"12.50","rate":"0.75","unset":null}`; string(encoded) != want {
+		t.Errorf("marshal = %s, want %s", encoded, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"amount":"twelve"}`), &decoded); !errors.Is(err, ErrInvalidDecimal) {
+		t.Errorf("unmarshal of a word: %v, want ErrInvalidDecimal", err)
+	}
+}
+
+func TestDecimalBSON(t *testing.T) {
+	type record struct {
+		Amount Decimal `bson:"amount"`
+		Weight Decimal `bson:"weight,omitempty"`
+		Spent  Decimal `bson:"spent"`
+	}
+
+	raw, err := bson.Marshal(record{Amount: MustParseDecimal("-1250.50"), Spent: NewDecimal(0, 0)})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	if _, err := bson.Raw(raw).LookupErr("weight"); err == nil {
+		t.Errorf("an unset decimal was stored despite omitempty")
+	}
+
+	var decoded record
+	if err := bson.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Amount.String() != "-1250.50" || decoded.Spent.String() != "0" || decoded.Weight.IsSet() {
+		t.Errorf("round trip gave %s, %s, %q; want -1250.50, 0 and unset", decoded.Amount, decoded.Spent, decoded.Weight.String())
+	}
+}
+
+// TestDecimalBSONLegacy reads the strings and numbers stored before amounts
+// were kept as Decimal128.
+func TestDecimalBSONLegacy(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"string", "7.25", "7.25"},
+		{"empty string", "", ""},
+		{"double", 1.5, "1.5"},
+		{"int32", int32(3), "3"},
+		{"int64", int64(-4), "-4"},
+		{"null", nil, ""},
+	}
+
+	for _, tt := range tests {
+		raw, err := bson.Marshal(bson.M{"amount": tt.value})
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", tt.name, err)
+		}
+
+		var decoded struct {
+			Amount Decimal `bson:"amount"`
+		}
+		if err := bson.Unmarshal(raw, &decoded); err != nil {
+			t.Errorf("%s: unmarshal: %v", tt.name, err)
+			continue
+		}
+		if got := decoded.Amount.String(); got != tt.want {
+			t.Errorf("%s: decoded %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalValidators(t *testing.T) {
+	type amounts struct {
+		Positive    Decimal `validate:"positive"`
+		Nonnegative Decimal `validate:"nonnegative"`
+	}
+
+	tests := []struct {
+		name   string
+		values amounts
+		valid  bool
+	}{
+		{"both above zero", amounts{NewDecimal(1, -2), NewDecimal(1, 0)}, true},
+		{"zero is not positive", amounts{NewDecimal(0, 0), NewDecimal(0, 0)}, false},
+		{"zero is nonnegative", amounts{NewDecimal(1, 0), NewDecimal(0, 0)}, true},
+		{"below zero", amounts{NewDecimal(1, 0), NewDecimal(-1, -2)}, false},
+		{"unset is not positive", amounts{Decimal{}, NewDecimal(0, 0)}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateStruct.Struct(tt.values)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: validation error %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -9,24 +9,46 @@ import (
 
 var ValidateStruct = validator.New(validator.WithRequiredStructEnabled())
 
+// DefaultCurrency is the currency of records written before currencies were
+// recorded, and of new records that do not name one.
+const DefaultCurrency = "GHS"
+
 // Transaction struct
 type Transaction struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id"`
-	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id" validate:"required"` //	Associate initiating purchase
-	CustomerID  primitive.ObjectID `json:"customer_id" bson:"customer_id" validate:"required"`   //	Associate initiating purchase
-	Kind        string             `json:"kind" bson:"kind" validate:"required"`                 // Kind Sell or buy
-	Scale       string             `json:"scale" bson:"scale" validate:"required"`               // Kind Sell or buy
-	Weight      string             `json:"weight" bson:"weight" validate:"required"`             //	Weight of the mineral
-	Mineral     string             `json:"mineral" bson:"mineral" validate:"required"`           // Mineral gold or diamond
-	Rate        string             `json:"rate" bson:"rate" validate:"required"`                 // Rate buying rate
-	Amount      string             `json:"amount" bson:"amount" validate:"required"`             // Amount money given to seller
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	ID            primitive.ObjectID   `json:"id" bson:"_id"`
+	AssociateID   primitive.ObjectID   `json:"associate_id" bson:"associate_id" validate:"required"` //	Associate initiating purchase
+	BranchID      primitive.ObjectID   `json:"branch_id" bson:"branch_id"`                           //	Branch the purchase was made at
+	CustomerID    primitive.ObjectID   `json:"customer_id" bson:"customer_id" validate:"required"`   //	Associate initiating purchase
+	Kind          string               `json:"kind" bson:"kind" validate:"required,oneof=buy sell"`  // Kind Sell or buy
+	Scale         string               `json:"scale" bson:"scale" validate:"required"`               // Kind Sell or buy
+	Weight        Decimal              `json:"weight" bson:"weight" validate:"required,positive"`    //	Weight of the mineral
+	Factor        Decimal              `json:"factor" bson:"factor,omitempty"`                       // Conversion factor of the scale when it was weighed, 1 for a bar
+	Mineral       string               `json:"mineral" bson:"mineral" validate:"required"`           // Mineral code from the catalogue
+	Grade         string               `json:"grade" bson:"grade"`                                   // Grade code, or colour/clarity of a diamond
+	Quality       *Quality             `json:"quality,omitempty" bson:"quality,omitempty"`           // Carat, colour and clarity of a diamond
+	Purity        *Purity              `json:"purity,omitempty" bson:"purity,omitempty"`             // Density test of gold
+	Rate          Decimal              `json:"rate" bson:"rate" validate:"required,positive"`        // Rate buying rate
+	Amount        Decimal              `json:"amount" bson:"amount" validate:"required,positive"`    // Amount money given to seller
+	Currency      string               `json:"currency" bson:"currency" validate:"required,iso4217"` // ISO 4217 code of amount
+	CreatedAt     time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at" bson:"updated_at"`
+	Void          *Void                `json:"void,omitempty" bson:"void,omitempty"`                            // Set once the record is voided
+	RateReview    *RateReview          `json:"rate_review,omitempty" bson:"rate_review,omitempty"`              // Set when the rate strays from the board
+	StashID       *primitive.ObjectID  `json:"stash_id,omitempty" bson:"stash_id,omitempty"`                    // Stash lot the buy was added to
+	MeltID        *primitive.ObjectID  `json:"melt_id,omitempty" bson:"melt_id,omitempty"`                      // Melt batch the buy went into
+	Bar           string               `json:"bar,omitempty" bson:"bar,omitempty"`                              // Serial of the bar sold
+	OffsetLoans   bool                 `json:"offset_loans,omitempty" bson:"-"`                                 // Repay as much of the customer's loans as the buy covers
+	LoanAccountID primitive.ObjectID   `json:"loan_account_id,omitempty" bson:"loan_account_id,omitempty"`      // Loan to offset, the oldest open ones when unset
+	LoanOffset    Decimal              `json:"loan_offset" bson:"loan_offset,omitempty" validate:"nonnegative"` // Part of the amount kept to repay loans
+	NetAmount     Decimal              `json:"net_amount" bson:"net_amount,omitempty"`                          // Cash that changed hands, the amount less the loan offset
+	RepaymentIDs  []primitive.ObjectID `json:"repayment_ids,omitempty" bson:"repayment_ids,omitempty"`          // Payoff loan records the offset created
 }
 type Balance struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id"`
-	Amount    string             `json:"amount" bson:"amount" validate:"required"`
-	Spent     string             `json:"spent" bson:"spent" validate:"required"`
+	BranchID  primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Currency  string             `json:"currency" bson:"currency"`
+	Amount    Decimal            `json:"amount" bson:"amount" validate:"required"`
+	Spent     Decimal            `json:"spent" bson:"spent" validate:"required"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
 }
@@ -34,9 +56,12 @@ type Balance struct {
 type Fund struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id"`
 	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id" validate:"required"`
-	Amount      string             `json:"amount" bson:"amount" validate:"required"`
+	BranchID    primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Amount      Decimal            `json:"amount" bson:"amount" validate:"required,positive"`
+	Currency    string             `json:"currency" bson:"currency" validate:"required,iso4217"` // ISO 4217 code of amount
 	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	Void        *Void              `json:"void,omitempty" bson:"void,omitempty"` // Set once the record is voided
 }
 
 // Associate struct
@@ -49,30 +74,139 @@ type Associate struct {
 	Address     string             `json:"address" bson:"address" validate:"required"`
 	IDNumber    string             `json:"id_number" bson:"IDNumber" validate:"required"`
 	Role        string             `json:"role" bson:"role" validate:"required"`
+	BranchID    primitive.ObjectID `json:"branch_id" bson:"branch_id,omitempty"` // Branch the associate works at
 	CreatedAt   time.Time          `json:"created_at" bson:"created_at" validate:"required"`
 	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
 }
 
 // Loan struct
 type Loan struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	ID            primitive.ObjectID  `json:"id" bson:"_id"`
+	AssociateID   primitive.ObjectID  `json:"associate_id" bson:"associate_id"`
+	BranchID      primitive.ObjectID  `json:"branch_id" bson:"branch_id"`
+	CustomerID    primitive.ObjectID  `json:"customer_id" bson:"customer_id" validate:"required"`
+	Amount        Decimal             `json:"amount" bson:"amount" validate:"required,positive"`
+	Currency      string              `json:"currency" bson:"currency" validate:"required,iso4217"`     // ISO 4217 code of amount
+	Type          string              `json:"type" bson:"type" validate:"required,oneof=credit payoff"` // credit pays a loan out, payoff repays it
+	AccountID     primitive.ObjectID  `json:"account_id" bson:"account_id,omitempty"`                   // Loan account the money moved on
+	DueAt         *time.Time          `json:"due_at,omitempty" bson:"due_at,omitempty"`                 // When a credit is to be repaid by
+	Terms         *LoanTerms          `json:"terms,omitempty" bson:"terms,omitempty"`                   // Interest, fee and instalments of a credit
+	Pledges       []LoanPledge        `json:"pledges,omitempty" bson:"-" validate:"dive"`               // Items left as security for a credit, kept on its account
+	TransactionID *primitive.ObjectID `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"` // Buy a payoff was offset against
+	CreatedAt     time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at" bson:"updated_at"`
+	Void          *Void               `json:"void,omitempty" bson:"void,omitempty"` // Set once the record is voided
+}
+
+// LoanAccount is one loan to a customer: the principal paid out by its
+// disbursement and the repayments taken against it
+type LoanAccount struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	CustomerID     primitive.ObjectID `json:"customer_id" bson:"customer_id"`
+	BranchID       primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	AssociateID    primitive.ObjectID `json:"associate_id" bson:"associate_id"` // Associate who paid the loan out
+	Principal      Decimal            `json:"principal" bson:"principal"`
+	Currency       string             `json:"currency" bson:"currency"`
+	DisbursementID primitive.ObjectID `json:"disbursement_id" bson:"disbursement_id"` // Credit loan record that paid it out
+	DisbursedAt    time.Time          `json:"disbursed_at" bson:"disbursed_at"`
+	DueAt          *time.Time         `json:"due_at,omitempty" bson:"due_at,omitempty"`
+	NextDueAt      *time.Time         `json:"next_due_at,omitempty" bson:"next_due_at,omitempty"` // Due date of the first instalment not fully paid
+	Terms          *LoanTerms         `json:"terms,omitempty" bson:"terms,omitempty"`
+	Schedule       []LoanInstalment   `json:"schedule" bson:"schedule"`
+	Fees           Decimal            `json:"fees" bson:"fees"`
+	Interest       Decimal            `json:"interest" bson:"interest"`
+	Repayments     []LoanRepayment    `json:"repayments" bson:"repayments"`
+	Repaid         Decimal            `json:"repaid" bson:"repaid"`
+	FeesPaid       Decimal            `json:"fees_paid" bson:"fees_paid"`
+	InterestPaid   Decimal            `json:"interest_paid" bson:"interest_paid"`
+	PrincipalPaid  Decimal            `json:"principal_paid" bson:"principal_paid"`
+	Outstanding    Decimal            `json:"outstanding" bson:"outstanding"` // principal + fees + interest - repaid
+	Status         string             `json:"status" bson:"status"`           // active, settled, overdue, written_off, forfeited
+	WriteOff       *LoanWriteOff      `json:"write_off,omitempty" bson:"write_off,omitempty"`
+	Forfeiture     *LoanWriteOff      `json:"forfeiture,omitempty" bson:"forfeiture,omitempty"` // Set once the collateral is taken for the loan
+	Pledges        []LoanPledge       `json:"pledges" bson:"pledges"`
+	PledgeValue    Decimal            `json:"pledge_value" bson:"pledge_value,omitempty"`   // estimated value of the pledges when paid out
+	LoanToValue    Decimal            `json:"loan_to_value" bson:"loan_to_value,omitempty"` // principal in percent of the pledge value
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at"`
+	Void           *Void              `json:"void,omitempty" bson:"void,omitempty"` // Set once the disbursement is voided
+}
+
+// LoanTerms is how a loan is charged for and repaid
+type LoanTerms struct {
+	Method      string     `json:"method" bson:"method" validate:"required,oneof=flat reducing"`        // interest on the principal, or on the balance left
+	Rate        Decimal    `json:"rate" bson:"rate" validate:"nonnegative"`                             // interest in percent a year
+	Frequency   string     `json:"frequency" bson:"frequency" validate:"required,oneof=weekly monthly"` // how often an instalment falls due
+	Instalments int        `json:"instalments" bson:"instalments" validate:"required,min=1,max=520"`
+	Fee         Decimal    `json:"fee" bson:"fee" validate:"nonnegative"`                // charged with the first instalment
+	FirstDueAt  *time.Time `json:"first_due_at,omitempty" bson:"first_due_at,omitempty"` // one period after disbursement when unset
+}
+
+// LoanInstalment is one repayment due on a loan's schedule and what has
+// been paid towards it
+type LoanInstalment struct {
+	Number        int       `json:"number" bson:"number"`
+	DueAt         time.Time `json:"due_at" bson:"due_at"`
+	Fee           Decimal   `json:"fee" bson:"fee"`
+	Interest      Decimal   `json:"interest" bson:"interest"`
+	Principal     Decimal   `json:"principal" bson:"principal"`
+	Total         Decimal   `json:"total" bson:"total"`
+	Balance       Decimal   `json:"balance" bson:"balance"` // principal left once it is paid
+	FeePaid       Decimal   `json:"fee_paid" bson:"fee_paid"`
+	InterestPaid  Decimal   `json:"interest_paid" bson:"interest_paid"`
+	PrincipalPaid Decimal   `json:"principal_paid" bson:"principal_paid"`
+}
+
+// LoanRepayment is a payoff loan record taken against a loan account, and
+// how it was allocated
+type LoanRepayment struct {
+	LoanID      primitive.ObjectID `json:"loan_id" bson:"loan_id"`
 	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id"`
-	CustomerID  primitive.ObjectID `json:"customer_id" bson:"customer_id" validate:"required"`
-	Amount      string             `json:"amount" bson:"amount" validate:"required"`
-	Type        string             `json:"type" bson:"type" validate:"required"`
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	Amount      Decimal            `json:"amount" bson:"amount"`
+	Fee         Decimal            `json:"fee" bson:"fee"`
+	Interest    Decimal            `json:"interest" bson:"interest"`
+	Principal   Decimal            `json:"principal" bson:"principal"`
+	PaidAt      time.Time          `json:"paid_at" bson:"paid_at"`
+}
+
+// LoanPledge is an item a customer leaves as security for a loan, valued
+// at the board's buy rate when the loan is paid out
+type LoanPledge struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Mineral     string             `json:"mineral" bson:"mineral" validate:"required"`
+	Grade       string             `json:"grade" bson:"grade"`
+	Scale       string             `json:"scale" bson:"scale" validate:"required"`
+	Weight      Decimal            `json:"weight" bson:"weight" validate:"required,positive"` // as read on the scale
+	Factor      Decimal            `json:"factor" bson:"factor,omitempty"`                    // the scale's conversion factor when it was weighed
+	Description string             `json:"description" bson:"description"`
+	Location    string             `json:"location" bson:"location" validate:"required"` // where the item is stored
+	BoardRateID primitive.ObjectID `json:"board_rate_id" bson:"board_rate_id"`
+	Rate        Decimal            `json:"rate" bson:"rate"` // board buy rate it was valued at
+	Value       Decimal            `json:"value" bson:"value"`
+	Status      string             `json:"status" bson:"status"`                           // held, released, forfeited
+	ClosedAt    *time.Time         `json:"closed_at,omitempty" bson:"closed_at,omitempty"` // when it was released or forfeited
+}
+
+// LoanWriteOff records who gave up on what was left of a loan, and why
+type LoanWriteOff struct {
+	WrittenOffBy primitive.ObjectID `json:"written_off_by" bson:"written_off_by"`
+	WrittenOffAt time.Time          `json:"written_off_at" bson:"written_off_at"`
+	Amount       Decimal            `json:"amount" bson:"amount"` // outstanding when written off
+	Reason       string             `json:"reason" bson:"reason"`
 }
 
 // Miscellaneous struct
 type Miscellaneous struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id"`                      // Unique identifier for each miscellaneous record
 	AssociateID  primitive.ObjectID `json:"associate_id" bson:"associate_id"`   // Foreign key referencing Associate
+	BranchID     primitive.ObjectID `json:"branch_id" bson:"branch_id"`         // Branch the money was spent at
 	PurchaseType string             `json:"purchase_type" bson:"purchase_type"` // Purchase type ("Buy" or "Sell")
 	Description  string             `json:"description" bson:"description"`     // Description of the purchase
-	Amount       string             `json:"amount" bson:"amount"`
+	Amount       Decimal            `json:"amount" bson:"amount" validate:"required,positive"`
+	Currency     string             `json:"currency" bson:"currency" validate:"required,iso4217"` // ISO 4217 code of amount
 	CreatedAt    time.Time          `json:"created_date" bson:"created_date"`
 	UpdatedAt    time.Time          `json:"updated_date" bson:"updated_date"`
+	Void         *Void              `json:"void,omitempty" bson:"void,omitempty"` // Set once the record is voided
 }
 
 // Customer struct
@@ -90,11 +224,393 @@ type Customer struct {
 
 // Stash struct
 type Stash struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id"`                              // Unique identifier for each customer
-	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id"`           // Foreign key referencing Associate
-	Weight      string             `json:"weight" bson:"weight" validate:"required"`   //	Weight of the mineral
-	Mineral     string             `json:"mineral" bson:"mineral" validate:"required"` // Mineral gold or diamond
-	Amount      string             `json:"amount" bson:"amount" validate:"required"`   // Amount money given to seller
-	CreatedAt   time.Time          `json:"created_date" bson:"created_date"`
-	UpdatedAt   time.Time          `json:"updated_date" bson:"updated_date"`
+	ID             primitive.ObjectID   `json:"id" bson:"_id"`                                        // Unique identifier for each customer
+	AssociateID    primitive.ObjectID   `json:"associate_id" bson:"associate_id"`                     // Foreign key referencing Associate
+	BranchID       primitive.ObjectID   `json:"branch_id" bson:"branch_id"`                           // Branch the stash was made at
+	Weight         Decimal              `json:"weight" bson:"weight" validate:"nonnegative"`          //	Weight of the mineral in the lot
+	Mineral        string               `json:"mineral" bson:"mineral" validate:"required"`           // Mineral code from the catalogue
+	Grade          string               `json:"grade" bson:"grade"`                                   // Grade code, when known
+	Amount         Decimal              `json:"amount" bson:"amount" validate:"nonnegative"`          // Amount money paid out when the stash was made
+	Currency       string               `json:"currency" bson:"currency" validate:"required,iso4217"` // ISO 4217 code of amount
+	Status         string               `json:"status" bson:"status"`                                 // open, sealed, shipped, received, settled
+	Transactions   []primitive.ObjectID `json:"transaction_ids" bson:"transaction_ids"`               // Buys added to the lot
+	SealedWeight   Decimal              `json:"sealed_weight" bson:"sealed_weight,omitempty"`
+	ShippedWeight  Decimal              `json:"shipped_weight" bson:"shipped_weight,omitempty"`
+	ReceivedWeight Decimal              `json:"received_weight" bson:"received_weight,omitempty"` // as weighed by the buyer
+	Proceeds       Decimal              `json:"proceeds" bson:"proceeds,omitempty"`               // what the lot was settled for, in currency
+	Steps          []StashStep          `json:"steps" bson:"steps"`
+	CreatedAt      time.Time            `json:"created_date" bson:"created_date"`
+	UpdatedAt      time.Time            `json:"updated_date" bson:"updated_date"`
+	Void           *Void                `json:"void,omitempty" bson:"void,omitempty"` // Set once the record is voided
+}
+
+// StashStep is one move of a stash along its workflow
+type StashStep struct {
+	Status      string             `json:"status" bson:"status"`
+	Weight      Decimal            `json:"weight" bson:"weight,omitempty"`
+	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+	At          time.Time          `json:"at" bson:"at"`
+}
+
+// JournalEntry is a balanced set of ledger lines posted for one business event
+type JournalEntry struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Event         string             `json:"event" bson:"event"`                   // Business event (fund, buy, sell ...)
+	ReferenceID   primitive.ObjectID `json:"reference_id" bson:"reference_id"`     // Document that caused the entry
+	ReferenceType string             `json:"reference_type" bson:"reference_type"` // Collection of that document
+	AssociateID   primitive.ObjectID `json:"associate_id" bson:"associate_id"`     // Associate who recorded the event
+	BranchID      primitive.ObjectID `json:"branch_id" bson:"branch_id"`           // Branch whose accounts are posted to
+	Currency      string             `json:"currency" bson:"currency"`
+	Description   string             `json:"description" bson:"description"`
+	Lines         []JournalLine      `json:"lines" bson:"lines"`
+	ReversalOf    primitive.ObjectID `json:"reversal_of,omitempty" bson:"reversal_of,omitempty"` // Entry this one reverses
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// JournalLine debits or credits a single ledger account
+type JournalLine struct {
+	Account  string             `json:"account" bson:"account"`
+	Debit    Decimal            `json:"debit" bson:"debit"`
+	Credit   Decimal            `json:"credit" bson:"credit"`
+	HolderID primitive.ObjectID `json:"holder_id,omitempty" bson:"holder_id,omitempty"` // Associate holding the cash of a float line
+}
+
+// Branch is a buying centre with its own till
+type Branch struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name" validate:"required"`
+	Code      string             `json:"code" bson:"code" validate:"required"`
+	Location  string             `json:"location" bson:"location"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Float is the cash an associate is holding for buys and loans
+type Float struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id"`
+	Currency    string             `json:"currency" bson:"currency"`
+	Amount      Decimal            `json:"amount" bson:"amount"`     // Cash on hand
+	Issued      Decimal            `json:"issued" bson:"issued"`     // Total issued from the till
+	Spent       Decimal            `json:"spent" bson:"spent"`       // Total paid out on buys and loans
+	Returned    Decimal            `json:"returned" bson:"returned"` // Total handed back to the till
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// FloatTransfer moves cash between a branch till and an associate's float
+type FloatTransfer struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id" validate:"required"` // Associate whose float moves
+	RecordedBy  primitive.ObjectID `json:"recorded_by" bson:"recorded_by"`                       // Admin who handed over the cash
+	BranchID    primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Kind        string             `json:"kind" bson:"kind"` // issue or return
+	Amount      Decimal            `json:"amount" bson:"amount" validate:"required,positive"`
+	Currency    string             `json:"currency" bson:"currency" validate:"required,iso4217"` // ISO 4217 code of amount
+	Description string             `json:"description" bson:"description"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// TillClose is an associate's end-of-day cash count against what the records
+// say they should be holding
+type TillClose struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	AssociateID   primitive.ObjectID `json:"associate_id" bson:"associate_id"`
+	BranchID      primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Date          time.Time          `json:"date" bson:"date"` // Start of the day being closed
+	Currency      string             `json:"currency" bson:"currency"`
+	Opening       Decimal            `json:"opening" bson:"opening"`             // Counted cash of the previous close
+	Funds         Decimal            `json:"funds" bson:"funds"`                 // Funds recorded and float issued less float returned
+	Buys          Decimal            `json:"buys" bson:"buys"`                   // Paid out on buys
+	Sells         Decimal            `json:"sells" bson:"sells"`                 // Taken in on sells
+	LoansOut      Decimal            `json:"loans_out" bson:"loans_out"`         // Paid out on loans
+	LoansIn       Decimal            `json:"loans_in" bson:"loans_in"`           // Taken in on loan payoffs
+	Miscellaneous Decimal            `json:"miscellaneous" bson:"miscellaneous"` // Paid out on miscellaneous spending
+	Expected      Decimal            `json:"expected" bson:"expected"`           // Cash the records say is on hand
+	Counted       Decimal            `json:"counted" bson:"counted"`             // Cash declared by the associate
+	Variance      Decimal            `json:"variance" bson:"variance"`           // Counted - expected, negative when short
+	Denominations []Denomination     `json:"denominations" bson:"denominations"` // Optional breakdown of the count
+	Note          string             `json:"note" bson:"note"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Denomination is the number of notes or coins of one value in a cash count
+type Denomination struct {
+	Value Decimal `json:"value" bson:"value" validate:"required,positive"`
+	Count int64   `json:"count" bson:"count" validate:"gte=0"`
+}
+
+// Period is a day or month of records that may be locked against changes
+type Period struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Kind       string             `json:"kind" bson:"kind"`   // day or month
+	Start      time.Time          `json:"start" bson:"start"` // First instant of the period
+	End        time.Time          `json:"end" bson:"end"`     // First instant after the period
+	Status     string             `json:"status" bson:"status"`
+	ClosedBy   primitive.ObjectID `json:"closed_by" bson:"closed_by"`
+	ClosedAt   time.Time          `json:"closed_at" bson:"closed_at"`
+	ReopenedBy primitive.ObjectID `json:"reopened_by,omitempty" bson:"reopened_by,omitempty"`
+	ReopenedAt time.Time          `json:"reopened_at,omitempty" bson:"reopened_at,omitempty"`
+}
+
+// PeriodAudit records who closed or reopened a period and why
+type PeriodAudit struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	PeriodID    primitive.ObjectID `json:"period_id" bson:"period_id"`
+	Action      string             `json:"action" bson:"action"` // close or reopen
+	AssociateID primitive.ObjectID `json:"associate_id" bson:"associate_id"`
+	Reason      string             `json:"reason" bson:"reason"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// BalanceHistory is one change of a branch's cached balance
+type BalanceHistory struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	BranchID      primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Currency      string             `json:"currency" bson:"currency"`
+	Before        Decimal            `json:"before" bson:"before"`
+	After         Decimal            `json:"after" bson:"after"`
+	Delta         Decimal            `json:"delta" bson:"delta"`
+	Event         string             `json:"event" bson:"event"`                   // Ledger event, or rebuild
+	ReferenceID   primitive.ObjectID `json:"reference_id" bson:"reference_id"`     // Document that caused the change
+	ReferenceType string             `json:"reference_type" bson:"reference_type"` // Collection of that document
+	AssociateID   primitive.ObjectID `json:"associate_id" bson:"associate_id"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Void records who voided a record, when and why
+type Void struct {
+	VoidedBy primitive.ObjectID `json:"voided_by" bson:"voided_by"`
+	VoidedAt time.Time          `json:"voided_at" bson:"voided_at"`
+	Reason   string             `json:"reason" bson:"reason"`
+}
+
+// IdempotencyRecord keeps the response of a money-moving request so a retry
+// with the same Idempotency-Key gets it back instead of repeating the request
+type IdempotencyRecord struct {
+	ID             string    `json:"id" bson:"_id"` // Associate ID and key
+	RequestHash    string    `json:"request_hash" bson:"request_hash"`
+	Status         string    `json:"status" bson:"status"` // pending or complete
+	ResponseStatus int       `json:"response_status" bson:"response_status"`
+	ResponseBody   string    `json:"response_body" bson:"response_body"`
+	ContentType    string    `json:"content_type" bson:"content_type"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// ExchangeRate is the value of one unit of Base in Quote from EffectiveFrom
+// until the next rate for the same pair takes over
+type ExchangeRate struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Base          string             `json:"base" bson:"base" validate:"required,iso4217"`
+	Quote         string             `json:"quote" bson:"quote" validate:"required,iso4217,nefield=Base"`
+	Rate          Decimal            `json:"rate" bson:"rate" validate:"required,positive"`
+	EffectiveFrom time.Time          `json:"effective_from" bson:"effective_from"`
+	CreatedBy     primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Scale is a weighing scale minerals are bought on. Factor converts a
+// reading into the weight rates are quoted for, and Margin is the share of
+// a buy's amount counted as profit.
+type Scale struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Code      string             `json:"code" bson:"code" validate:"required,lowercase"` // bb, mini, gb
+	Name      string             `json:"name" bson:"name" validate:"required"`
+	Unit      string             `json:"unit" bson:"unit" validate:"required"` // unit the scale reads in
+	Factor    Decimal            `json:"conversion_factor" bson:"conversion_factor" validate:"required,positive"`
+	Margin    Decimal            `json:"margin" bson:"margin" validate:"required,nonnegative"` // 0.1581 for 15.81%
+	Active    bool               `json:"active" bson:"active"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Mineral is an entry of the mineral catalogue. Grading says how what is
+// bought is described: by a purity grade (fineness, gold) or by the carat,
+// colour and clarity of each stone (quality, diamond).
+type Mineral struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Code      string             `json:"code" bson:"code" validate:"required,lowercase"` // gold, diamond
+	Name      string             `json:"name" bson:"name" validate:"required"`
+	Unit      string             `json:"unit" bson:"unit" validate:"required"` // unit rates are quoted for
+	Grading   string             `json:"grading" bson:"grading" validate:"required,oneof=fineness quality"`
+	Active    bool               `json:"active" bson:"active"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Grade is a purity grade of a mineral graded by fineness
+type Grade struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Mineral   string             `json:"mineral" bson:"mineral" validate:"required"`
+	Code      string             `json:"code" bson:"code" validate:"required,lowercase"` // 22k
+	Name      string             `json:"name" bson:"name" validate:"required"`
+	Karat     Decimal            `json:"karat" bson:"karat"`                                    // out of 24
+	Fineness  Decimal            `json:"fineness" bson:"fineness" validate:"required,positive"` // parts per thousand
+	Active    bool               `json:"active" bson:"active"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Quality describes a diamond
+type Quality struct {
+	Carat   Decimal `json:"carat" bson:"carat" validate:"required,positive"`
+	Colour  string  `json:"colour" bson:"colour" validate:"required,oneof=D E F G H I J K L M N O P Q R S T U V W X Y Z"`
+	Clarity string  `json:"clarity" bson:"clarity" validate:"required,oneof=FL IF VVS1 VVS2 VS1 VS2 SI1 SI2 I1 I2 I3"`
+}
+
+// BoardRate is the official rate of a mineral and grade on a scale from
+// EffectiveFrom until the next rate for the same mineral, grade, scale, kind
+// and currency takes over. An empty Grade covers every grade.
+type BoardRate struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Kind          string             `json:"kind" bson:"kind" validate:"required,oneof=buy sell"`
+	Mineral       string             `json:"mineral" bson:"mineral" validate:"required"`
+	Grade         string             `json:"grade" bson:"grade"`
+	Scale         string             `json:"scale" bson:"scale" validate:"required"`
+	Currency      string             `json:"currency" bson:"currency" validate:"required,iso4217"`
+	Rate          Decimal            `json:"rate" bson:"rate" validate:"required,positive"`
+	Band          Decimal            `json:"band" bson:"band" validate:"required,nonnegative"` // percent a transaction may stray before review
+	EffectiveFrom time.Time          `json:"effective_from" bson:"effective_from"`
+	CreatedBy     primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// RateReview flags a transaction whose rate strayed from the board rate
+// beyond its band until an admin looks at it
+type RateReview struct {
+	BoardRateID primitive.ObjectID `json:"board_rate_id" bson:"board_rate_id"`
+	BoardRate   Decimal            `json:"board_rate" bson:"board_rate"`
+	Deviation   Decimal            `json:"deviation" bson:"deviation"` // percent off the board rate
+	Status      string             `json:"status" bson:"status"`       // pending, approved or rejected
+	ReviewedBy  primitive.ObjectID `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time         `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// Purity is a density (water) test: the weights in air and in water are
+// measured, the rest is worked out from them
+type Purity struct {
+	AirWeight   Decimal `json:"air_weight" bson:"air_weight" validate:"required,positive"`
+	WaterWeight Decimal `json:"water_weight" bson:"water_weight" validate:"required,positive"`
+	Density     Decimal `json:"density" bson:"density"`         // air / (air - water)
+	Fineness    Decimal `json:"fineness" bson:"fineness"`       // parts per thousand, from the density table
+	Karat       Decimal `json:"karat" bson:"karat"`             // out of 24
+	FineWeight  Decimal `json:"fine_weight" bson:"fine_weight"` // pure content of the weight
+}
+
+// DensityPoint is one line of a mineral's density table: an alloy of this
+// density has this fineness. Fineness between lines is interpolated.
+type DensityPoint struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Mineral   string             `json:"mineral" bson:"mineral"`
+	Density   Decimal            `json:"density" bson:"density" validate:"required,positive"`
+	Fineness  Decimal            `json:"fineness" bson:"fineness" validate:"required,positive"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Stock is the cached holding of one mineral grade at a branch, kept from
+// its stock movements. Cost is what the holding was bought for, in the
+// default currency.
+type Stock struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	BranchID   primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Mineral    string             `json:"mineral" bson:"mineral"`
+	Grade      string             `json:"grade" bson:"grade"`
+	Weight     Decimal            `json:"weight" bson:"weight"`           // in the mineral's unit
+	FineWeight Decimal            `json:"fine_weight" bson:"fine_weight"` // pure content of the weight
+	Cost       Decimal            `json:"cost" bson:"cost"`
+	Currency   string             `json:"currency" bson:"currency"` // currency of cost
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// StockMovement is weight going into stock (positive) or out of it
+// (negative) for one business event
+type StockMovement struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Kind          string             `json:"kind" bson:"kind"` // buy, sell, stash
+	BranchID      primitive.ObjectID `json:"branch_id" bson:"branch_id"`
+	Mineral       string             `json:"mineral" bson:"mineral"`
+	Grade         string             `json:"grade" bson:"grade"`
+	Weight        Decimal            `json:"weight" bson:"weight"`
+	FineWeight    Decimal            `json:"fine_weight" bson:"fine_weight"`
+	Cost          Decimal            `json:"cost" bson:"cost"` // cost in or out at the average cost
+	Currency      string             `json:"currency" bson:"currency"`
+	ReferenceID   primitive.ObjectID `json:"reference_id" bson:"reference_id"`     // Document that moved the stock
+	ReferenceType string             `json:"reference_type" bson:"reference_type"` // Collection of that document
+	AssociateID   primitive.ObjectID `json:"associate_id" bson:"associate_id"`
+	ReversalOf    primitive.ObjectID `json:"reversal_of,omitempty" bson:"reversal_of,omitempty"` // Movement this one takes back
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Assay is the result a refinery returned for a stash lot, set against what
+// was declared when its buys were weighed
+type Assay struct {
+	ID                 primitive.ObjectID `json:"id" bson:"_id"`
+	StashID            primitive.ObjectID `json:"stash_id" bson:"stash_id" validate:"required"`
+	AssociateID        primitive.ObjectID `json:"associate_id" bson:"associate_id"`                              // Associate who recorded the result
+	GrossWeight        Decimal            `json:"gross_weight" bson:"gross_weight" validate:"required,positive"` // weight out of the melt
+	Fineness           Decimal            `json:"fineness" bson:"fineness" validate:"required,positive"`
+	FineWeight         Decimal            `json:"fine_weight" bson:"fine_weight" validate:"required,positive"` // gross weight × fineness when not given
+	Assayer            string             `json:"assayer" bson:"assayer" validate:"required"`
+	CertificateNumber  string             `json:"certificate_number" bson:"certificate_number" validate:"required"`
+	DeclaredWeight     Decimal            `json:"declared_weight" bson:"declared_weight"`
+	DeclaredFineWeight Decimal            `json:"declared_fine_weight" bson:"declared_fine_weight"`
+	WeightLoss         Decimal            `json:"weight_loss" bson:"weight_loss"`   // declared - gross weight
+	FineLoss           Decimal            `json:"fine_loss" bson:"fine_loss"`       // declared - assayed fine weight
+	LossPercent        Decimal            `json:"loss_percent" bson:"loss_percent"` // fine loss in percent of the declared
+	Lines              []AssayLine        `json:"lines" bson:"lines"`
+	CreatedAt          time.Time          `json:"created_at" bson:"created_at"`
+	Void               *Void              `json:"void,omitempty" bson:"void,omitempty"` // Set once the record is voided
+}
+
+// AssayLine is the share of an assay's result that falls to one buy of the
+// lot, in proportion to the fine weight declared for it. Loose weight put in
+// the lot has a line without a transaction.
+type AssayLine struct {
+	TransactionID      primitive.ObjectID `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	AssociateID        primitive.ObjectID `json:"associate_id,omitempty" bson:"associate_id,omitempty"`
+	CustomerID         primitive.ObjectID `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Scale              string             `json:"scale,omitempty" bson:"scale,omitempty"`
+	DeclaredFineWeight Decimal            `json:"declared_fine_weight" bson:"declared_fine_weight"`
+	AssayedFineWeight  Decimal            `json:"assayed_fine_weight" bson:"assayed_fine_weight"`
+	Loss               Decimal            `json:"loss" bson:"loss"`
+}
+
+// MeltBatch is a melt of bought weight into a bar. The bar is stocked on
+// its own, at the cost of what went into it, until it is sold.
+type MeltBatch struct {
+	ID              primitive.ObjectID  `json:"id" bson:"_id"`
+	BranchID        primitive.ObjectID  `json:"branch_id" bson:"branch_id"`
+	AssociateID     primitive.ObjectID  `json:"associate_id" bson:"associate_id"` // Associate who recorded the melt
+	Mineral         string              `json:"mineral" bson:"mineral" validate:"required"`
+	Inputs          []MeltInput         `json:"inputs" bson:"inputs"`
+	InputWeight     Decimal             `json:"input_weight" bson:"input_weight"`
+	InputFineWeight Decimal             `json:"input_fine_weight" bson:"input_fine_weight"`
+	InputCost       Decimal             `json:"input_cost" bson:"input_cost"` // in the default currency
+	Serial          string              `json:"serial" bson:"serial,omitempty" validate:"required"`
+	VoidedSerial    string              `json:"voided_serial,omitempty" bson:"voided_serial,omitempty"` // Serial of a voided bar, freed for another
+	BarWeight       Decimal             `json:"bar_weight" bson:"bar_weight" validate:"required,positive"`
+	Fineness        Decimal             `json:"fineness" bson:"fineness" validate:"required,positive"`
+	BarFineWeight   Decimal             `json:"bar_fine_weight" bson:"bar_fine_weight"`
+	MeltLoss        Decimal             `json:"melt_loss" bson:"melt_loss"`                 // input weight - bar weight
+	FineLoss        Decimal             `json:"fine_loss" bson:"fine_loss"`                 // input fine weight - bar fine weight
+	LossPercent     Decimal             `json:"loss_percent" bson:"loss_percent"`           // fine loss in percent of the input
+	SoldBy          *primitive.ObjectID `json:"sold_by,omitempty" bson:"sold_by,omitempty"` // Sell transaction of the bar
+	CreatedAt       time.Time           `json:"created_at" bson:"created_at"`
+	Void            *Void               `json:"void,omitempty" bson:"void,omitempty"` // Set once the record is voided
+}
+
+// MeltInput is weight that went into a melt: a whole buy, or loose weight
+// of one grade taken from stock
+type MeltInput struct {
+	TransactionID primitive.ObjectID `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	CustomerID    primitive.ObjectID `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Grade         string             `json:"grade" bson:"grade"`
+	Weight        Decimal            `json:"weight" bson:"weight"`
+	FineWeight    Decimal            `json:"fine_weight" bson:"fine_weight"`
+	Cost          Decimal            `json:"cost" bson:"cost"`
 }
@@ -0,0 +1,121 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoBoardRate is returned when the board has no rate for a transaction.
+var ErrNoBoardRate = errors.New("no rate on the board")
+
+// boardKey identifies the rates that replace one another over time
+type boardKey struct {
+	kind, mineral, grade, scale, currency string
+}
+
+// CurrentRates lists the board rate in force at the given moment for every
+// kind, mineral, grade, scale and currency.
+func CurrentRates(ctx context.Context, at time.Time) ([]models.BoardRate, error) {
+	cursor, err := database.Database.Collection(models.Collection.BoardRate).Find(ctx,
+		bson.M{"effective_from": bson.M{"$lte": at}},
+		options.Find().SetSort(bson.D{{Key: "effective_from", Value: -1}, {Key: "_id", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []models.BoardRate
+	if err := cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+
+	seen := map[boardKey]bool{}
+	current := []models.BoardRate{}
+
+	for _, rate := range rates {
+		key := boardKey{rate.Kind, rate.Mineral, rate.Grade, rate.Scale, rate.Currency}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		current = append(current, rate)
+	}
+
+	return current, nil
+}
+
+// BoardRateFor finds the board rate in force at the given moment for a
+// transaction, preferring one for its grade over one for every grade.
+func BoardRateFor(ctx context.Context, kind string, mineral string, grade string, scale string, currency string, at time.Time) (*models.BoardRate, error) {
+	grades := bson.A{""}
+	if grade != "" {
+		grades = bson.A{grade, ""}
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.BoardRate).Find(ctx,
+		bson.M{
+			"kind":           kind,
+			"mineral":        mineral,
+			"grade":          bson.M{"$in": grades},
+			"scale":          scale,
+			"currency":       currency,
+			"effective_from": bson.M{"$lte": at},
+		},
+		options.Find().SetSort(bson.D{{Key: "effective_from", Value: -1}, {Key: "_id", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []models.BoardRate
+	if err := cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+
+	var fallback *models.BoardRate
+	for i := range rates {
+		if rates[i].Grade == grade {
+			return &rates[i], nil
+		}
+		if fallback == nil {
+			fallback = &rates[i]
+		}
+	}
+
+	if fallback == nil {
+		return nil, fmt.Errorf("%w for %s %s on %s in %s", ErrNoBoardRate, kind, mineral, scale, currency)
+	}
+	return fallback, nil
+}
+
+// Deviation is how far rate is from the board rate, in percent of it.
+func Deviation(board *models.BoardRate, rate models.Decimal) (models.Decimal, error) {
+	return rate.Sub(board.Rate).Mul(models.NewDecimal(100, 0)).Div(board.Rate, 2)
+}
+
+// Review flags rate for admin review when it strays from the board rate
+// beyond the board's band, and returns nil when it is within it.
+func Review(board *models.BoardRate, rate models.Decimal) (*models.RateReview, error) {
+	deviation, err := Deviation(board, rate)
+	if err != nil {
+		return nil, err
+	}
+
+	if deviation.Abs().Cmp(board.Band) <= 0 {
+		return nil, nil
+	}
+
+	return &models.RateReview{
+		BoardRateID: board.ID,
+		BoardRate:   board.Rate,
+		Deviation:   deviation,
+		Status:      "pending",
+	}, nil
+}
@@ -0,0 +1,80 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeviation(t *testing.T) {
+	board := &models.BoardRate{Rate: dec("800")}
+
+	tests := []struct {
+		rate string
+		want string
+	}{
+		{"800", "0"},
+		{"816", "2"},
+		{"780", "-2.5"},
+		{"801", "0.13"},
+		{"799", "-0.13"},
+	}
+
+	for _, tt := range tests {
+		got, err := Deviation(board, dec(tt.rate))
+		if err != nil {
+			t.Errorf("Deviation(%s): %v", tt.rate, err)
+			continue
+		}
+		if got.Cmp(dec(tt.want)) != 0 {
+			t.Errorf("Deviation(%s) = %s%%, want %s%%", tt.rate, got, tt.want)
+		}
+	}
+
+	if _, err := Deviation(&models.BoardRate{Rate: dec("0")}, dec("1")); err == nil {
+		t.Errorf("a deviation from a zero board rate did not fail")
+	}
+}
+
+func TestReview(t *testing.T) {
+	board := &models.BoardRate{ID: primitive.NewObjectID(), Rate: dec("800"), Band: dec("2")}
+
+	tests := []struct {
+		name      string
+		rate      string
+		deviation string // empty when no review is wanted
+	}{
+		{"on the board", "800", ""},
+		{"on the edge of the band above", "816", ""},
+		{"on the edge of the band below", "784", ""},
+		{"beyond the band above", "816.08", "2.01"},
+		{"beyond the band below", "780", "-2.5"},
+	}
+
+	for _, tt := range tests {
+		review, err := Review(board, dec(tt.rate))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if tt.deviation == "" {
+			if review != nil {
+				t.Errorf("%s: flagged for review at %s%%", tt.name, review.Deviation)
+			}
+			continue
+		}
+
+		if review == nil {
+			t.Errorf("%s: not flagged for review", tt.name)
+			continue
+		}
+		if review.Deviation.Cmp(dec(tt.deviation)) != 0 {
+			t.Errorf("%s: deviation %s%%, want %s%%", tt.name, review.Deviation, tt.deviation)
+		}
+		if review.BoardRateID != board.ID || review.BoardRate.Cmp(board.Rate) != 0 || review.Status != "pending" {
+			t.Errorf("%s: review %+v does not point at the board rate as pending", tt.name, review)
+		}
+	}
+}
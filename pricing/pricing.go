@@ -0,0 +1,146 @@
+// Package pricing works out what a transaction pays from the weight read on
+// a scale and the agreed rate, so the amount never depends on the client's
+// arithmetic.
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/DreamSoft-LLC/oryan/catalogue"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUnknownScale   = errors.New("no active scale")
+	ErrAmountMismatch = errors.New("amount does not match the computed price")
+)
+
+// AmountPlaces is the precision amounts are rounded to
+const AmountPlaces = 2
+
+// default tolerance when PRICE_TOLERANCE_PERCENT is not set
+var defaultTolerance = models.MustParseDecimal("0.5")
+
+// Quote is the breakdown of a computed price.
+type Quote struct {
+	Scale        string         `json:"scale"`
+	Mineral      string         `json:"mineral"`
+	Unit         string         `json:"unit"`          // unit the weight is in
+	Weight       models.Decimal `json:"weight"`        // as read on the scale
+	Factor       models.Decimal `json:"factor"`        // the scale's conversion factor
+	PricedWeight models.Decimal `json:"priced_weight"` // weight × factor
+	Rate         models.Decimal `json:"rate"`
+	Amount       models.Decimal `json:"amount"` // priced weight × rate, rounded
+}
+
+// Scales lists the scales, only the active ones when activeOnly is set.
+func Scales(ctx context.Context, activeOnly bool) ([]models.Scale, error) {
+	filter := bson.M{}
+	if activeOnly {
+		filter["active"] = true
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.Scale).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "code", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	scales := []models.Scale{}
+	if err := cursor.All(ctx, &scales); err != nil {
+		return nil, err
+	}
+
+	return scales, nil
+}
+
+// ScaleFor finds the active scale with the given code.
+func ScaleFor(ctx context.Context, code string) (*models.Scale, error) {
+	var scale models.Scale
+
+	err := database.Database.Collection(models.Collection.Scale).FindOne(ctx,
+		bson.M{"code": strings.ToLower(code), "active": true},
+	).Decode(&scale)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownScale, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &scale, nil
+}
+
+// Price computes what weight of mineral read on scale pays at rate.
+func Price(ctx context.Context, scale string, mineral string, weight models.Decimal, rate models.Decimal) (*Quote, error) {
+	found, err := ScaleFor(ctx, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQuote(found.Code, mineral, found.Unit, weight, found.Factor, rate), nil
+}
+
+// PriceFine computes what a weight already in the mineral's unit, such as
+// a bar's fine weight, pays at rate. The scale is recorded but its factor
+// is not applied: the quote's factor is 1.
+func PriceFine(ctx context.Context, scale string, mineral string, weight models.Decimal, rate models.Decimal) (*Quote, error) {
+	found, err := ScaleFor(ctx, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	described, err := catalogue.MineralFor(ctx, mineral)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQuote(found.Code, mineral, described.Unit, weight, models.NewDecimal(1, 0), rate), nil
+}
+
+func newQuote(scale string, mineral string, unit string, weight models.Decimal, factor models.Decimal, rate models.Decimal) *Quote {
+	pricedWeight := weight.Mul(factor)
+
+	return &Quote{
+		Scale:        scale,
+		Mineral:      strings.ToLower(mineral),
+		Unit:         unit,
+		Weight:       weight,
+		Factor:       factor,
+		PricedWeight: pricedWeight,
+		Rate:         rate,
+		Amount:       pricedWeight.Mul(rate).Round(AmountPlaces),
+	}
+}
+
+// Tolerance is how far, in percent of the computed price, a submitted
+// amount may be off before it is rejected. It is read from
+// PRICE_TOLERANCE_PERCENT.
+func Tolerance() models.Decimal {
+	tolerance, err := models.ParseDecimal(os.Getenv("PRICE_TOLERANCE_PERCENT"))
+	if err != nil || tolerance.Sign() < 0 {
+		return defaultTolerance
+	}
+	return tolerance
+}
+
+// Check compares a submitted amount with the quote, returning
+// ErrAmountMismatch when it is off by more than the tolerance.
+func (q *Quote) Check(submitted models.Decimal) error {
+	difference := submitted.Sub(q.Amount).Abs()
+	allowed := q.Amount.Mul(Tolerance()).Mul(models.NewDecimal(1, -2))
+
+	if difference.Cmp(allowed) > 0 {
+		return fmt.Errorf("%w: submitted %s, computed %s", ErrAmountMismatch, submitted, q.Amount)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+)
+
+func dec(s string) models.Decimal {
+	return models.MustParseDecimal(s)
+}
+
+func TestQuoteAmount(t *testing.T) {
+	tests := []struct {
+		name           string
+		weight, factor string
+		rate           string
+		priced, amount string
+	}{
+		{"a factor of one", "12.5", "1", "100", "12.5", "1250.00"},
+		{"the factor converts the reading first", "10.5", "0.9", "100.333", "9.45", "948.15"},
+		{"half a pesewa rounds up", "1", "1", "0.125", "1", "0.13"},
+	}
+
+	for _, tt := range tests {
+		quote := newQuote("bb", "Gold", "g", dec(tt.weight), dec(tt.factor), dec(tt.rate))
+
+		if quote.PricedWeight.Cmp(dec(tt.priced)) != 0 {
+			t.Errorf("%s: priced weight %s, want %s", tt.name, quote.PricedWeight, tt.priced)
+		}
+		if quote.Amount.String() != tt.amount {
+			t.Errorf("%s: amount %s, want %s", tt.name, quote.Amount, tt.amount)
+		}
+		if quote.Mineral != "gold" {
+			t.Errorf("%s: mineral %q, want gold", tt.name, quote.Mineral)
+		}
+	}
+}
+
+func TestTolerance(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", "0.5"},
+		{"2", "2"},
+		{"0", "0"},
+		{"-1", "0.5"},
+		{"lots", "0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("PRICE_TOLERANCE_PERCENT", tt.env)
+		if got := Tolerance(); got.Cmp(dec(tt.want)) != 0 {
+			t.Errorf("PRICE_TOLERANCE_PERCENT=%q gives %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteCheck(t *testing.T) {
+	quote := &Quote{Amount: dec("100.00")}
+
+	tests := []struct {
+		tolerance string
+		submitted string
+		ok        bool
+	}{
+		{"0.5", "100.00", true},
+		{"0.5", "100.50", true},
+		{"0.5", "99.50", true},
+		{"0.5", "100.51", false},
+		{"0.5", "99.49", false},
+		{"0", "100.00", true},
+		{"0", "100.01", false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("PRICE_TOLERANCE_PERCENT", tt.tolerance)
+
+		err := quote.Check(dec(tt.submitted))
+		if tt.ok && err != nil {
+			t.Errorf("%s within %s%%: %v", tt.submitted, tt.tolerance, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrAmountMismatch) {
+			t.Errorf("%s within %s%%: %v, want ErrAmountMismatch", tt.submitted, tt.tolerance, err)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+// Package profit works out the margin made on minerals sold by matching the
+// weight each sale took out of stock against what that weight cost, either
+// at the weighted average cost of the stock or first in, first out. Stock
+// movements are replayed from the start, so either method can be reported
+// whichever one the stock cache was kept with.
+package profit
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/pricing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cost matching methods
+const (
+	MethodAverage = "average" // weighted average cost of the stock held
+	MethodFIFO    = "fifo"    // cost of the earliest weight still held
+)
+
+// ErrUnknownMethod is returned for a cost matching method other than the above.
+var ErrUnknownMethod = errors.New("unknown cost method, expected average or fifo")
+
+// precision of matched costs
+const costPlaces = 2
+
+// Sale is the realised profit of one sale.
+type Sale struct {
+	TransactionID primitive.ObjectID `json:"transaction_id"`
+	BranchID      primitive.ObjectID `json:"branch_id"`
+	AssociateID   primitive.ObjectID `json:"associate_id"`
+	Scale         string             `json:"scale"`
+	Mineral       string             `json:"mineral"`
+	Grade         string             `json:"grade"`
+	Weight        models.Decimal     `json:"weight"`
+	Proceeds      models.Decimal     `json:"proceeds"`
+	Cost          models.Decimal     `json:"cost"`
+	Profit        models.Decimal     `json:"profit"`
+	SoldAt        time.Time          `json:"sold_at"`
+}
+
+// Realised is the gross profit of the sales made in a period.
+type Realised struct {
+	Method      string                    `json:"method"`
+	Currency    string                    `json:"currency"`
+	Sales       []Sale                    `json:"sales"`
+	Proceeds    models.Decimal            `json:"proceeds"`
+	Cost        models.Decimal            `json:"cost"`
+	Profit      models.Decimal            `json:"profit"`
+	ByDay       map[string]models.Decimal `json:"by_day"`
+	ByAssociate map[string]models.Decimal `json:"by_associate"`
+	ByScale     map[string]models.Decimal `json:"by_scale"`
+}
+
+// Holding is the stock of one mineral grade at a branch valued at the
+// current board rate. Rate, value and profit are left unset when the board
+// has no rate for it.
+type Holding struct {
+	BranchID primitive.ObjectID `json:"branch_id"`
+	Mineral  string             `json:"mineral"`
+	Grade    string             `json:"grade"`
+	Weight   models.Decimal     `json:"weight"`
+	Cost     models.Decimal     `json:"cost"`
+	Scale    string             `json:"scale,omitempty"` // scale the rate is for
+	Rate     models.Decimal     `json:"rate"`
+	Value    models.Decimal     `json:"value"`
+	Profit   models.Decimal     `json:"profit"`
+}
+
+// Unrealised is the profit that would be made on the stock held were it
+// sold at the current board rate.
+type Unrealised struct {
+	Method   string         `json:"method"`
+	Currency string         `json:"currency"`
+	Holdings []Holding      `json:"holdings"`
+	Cost     models.Decimal `json:"cost"`
+	Value    models.Decimal `json:"value"`
+	Profit   models.Decimal `json:"profit"` // of the holdings that could be valued
+}
+
+type stockKey struct {
+	branchID       primitive.ObjectID
+	mineral, grade string
+}
+
+// lot is weight that came into stock together, at its cost
+type lot struct {
+	weight, cost models.Decimal
+}
+
+// position is the stock of one key as the movements are replayed
+type position struct {
+	lots []lot // one lot holding everything when averaging
+}
+
+func (p *position) held() (models.Decimal, models.Decimal) {
+	weight, cost := models.NewDecimal(0, 0), models.NewDecimal(0, 0)
+	for _, l := range p.lots {
+		weight = weight.Add(l.weight)
+		cost = cost.Add(l.cost)
+	}
+	return weight, cost
+}
+
+func (p *position) receive(method string, weight models.Decimal, cost models.Decimal) {
+	if method == MethodAverage && len(p.lots) > 0 {
+		p.lots[0].weight = p.lots[0].weight.Add(weight)
+		p.lots[0].cost = p.lots[0].cost.Add(cost)
+		return
+	}
+	p.lots = append(p.lots, lot{weight: weight, cost: cost})
+}
+
+// issue takes weight out of the front lots and returns what it cost. Weight
+// beyond what is held has no cost.
+func (p *position) issue(weight models.Decimal) (models.Decimal, error) {
+	cost := models.NewDecimal(0, 0)
+	remaining := weight
+
+	for len(p.lots) > 0 && remaining.Sign() > 0 {
+		front := &p.lots[0]
+
+		if front.weight.Cmp(remaining) <= 0 {
+			cost = cost.Add(front.cost)
+			remaining = remaining.Sub(front.weight)
+			p.lots = p.lots[1:]
+			continue
+		}
+
+		share, err := front.cost.Mul(remaining).Div(front.weight, costPlaces)
+		if err != nil {
+			return models.Decimal{}, err
+		}
+		cost = cost.Add(share)
+		front.weight = front.weight.Sub(remaining)
+		front.cost = front.cost.Sub(share)
+		remaining = models.NewDecimal(0, 0)
+	}
+
+	return cost, nil
+}
+
+// replay runs every live stock movement matching filter through the
+// method, calling sold for each sale with what it cost, and returns the
+// positions left.
+func replay(ctx context.Context, method string, filter bson.M, sold func(movement models.StockMovement, cost models.Decimal)) (map[stockKey]*position, error) {
+	if method != MethodAverage && method != MethodFIFO {
+		return nil, ErrUnknownMethod
+	}
+
+	cursor, err := database.Database.Collection(models.Collection.StockMovement).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var movements []models.StockMovement
+	if err := cursor.All(ctx, &movements); err != nil {
+		return nil, err
+	}
+
+	// a voided record's movements and their reversals cancel out
+	reversed := map[primitive.ObjectID]bool{}
+	for _, movement := range movements {
+		if !movement.ReversalOf.IsZero() {
+			reversed[movement.ReversalOf] = true
+		}
+	}
+
+	positions := map[stockKey]*position{}
+
+	for _, movement := range movements {
+		if !movement.ReversalOf.IsZero() || reversed[movement.ID] {
+			continue
+		}
+
+		key := stockKey{movement.BranchID, movement.Mineral, movement.Grade}
+		p, ok := positions[key]
+		if !ok {
+			p = &position{}
+			positions[key] = p
+		}
+
+		if movement.Weight.Sign() >= 0 {
+			p.receive(method, movement.Weight, movement.Cost)
+			continue
+		}
+
+		cost, err := p.issue(movement.Weight.Neg())
+		if err != nil {
+			return nil, err
+		}
+		if movement.Kind == inventory.KindSell && sold != nil {
+			sold(movement, cost)
+		}
+	}
+
+	return positions, nil
+}
+
+// RealisedProfit matches the sales made between from and to (exclusive) at
+// the branches matching filter against the cost of the stock they took.
+// Proceeds are converted into the default currency at the rate of the day
+// of the sale, the currency stock is costed in.
+func RealisedProfit(ctx context.Context, method string, filter bson.M, from time.Time, to time.Time) (*Realised, error) {
+	type match struct {
+		movement models.StockMovement
+		cost     models.Decimal
+	}
+	matches := []match{}
+
+	_, err := replay(ctx, method, filter, func(movement models.StockMovement, cost models.Decimal) {
+		if !movement.CreatedAt.Before(from) && movement.CreatedAt.Before(to) {
+			matches = append(matches, match{movement, cost})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(bson.A, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.movement.ReferenceID)
+	}
+
+	transactions := map[primitive.ObjectID]models.Transaction{}
+	if len(ids) > 0 {
+		cursor, err := database.Database.Collection(models.Collection.Transaction).Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, err
+		}
+		var found []models.Transaction
+		if err := cursor.All(ctx, &found); err != nil {
+			return nil, err
+		}
+		for _, transaction := range found {
+			transactions[transaction.ID] = transaction
+		}
+	}
+
+	book, err := ledger.LoadRateBook(ctx, models.DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	zero := models.NewDecimal(0, 0)
+	report := &Realised{
+		Method:      method,
+		Currency:    models.DefaultCurrency,
+		Sales:       []Sale{},
+		Proceeds:    zero,
+		Cost:        zero,
+		Profit:      zero,
+		ByDay:       map[string]models.Decimal{},
+		ByAssociate: map[string]models.Decimal{},
+		ByScale:     map[string]models.Decimal{},
+	}
+
+	for _, m := range matches {
+		transaction := transactions[m.movement.ReferenceID]
+
+		proceeds, err := book.Convert(transaction.Amount, transaction.Currency, transaction.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		proceeds = proceeds.Round(costPlaces)
+
+		sale := Sale{
+			TransactionID: m.movement.ReferenceID,
+			BranchID:      m.movement.BranchID,
+			AssociateID:   transaction.AssociateID,
+			Scale:         transaction.Scale,
+			Mineral:       m.movement.Mineral,
+			Grade:         m.movement.Grade,
+			Weight:        m.movement.Weight.Neg(),
+			Proceeds:      proceeds,
+			Cost:          m.cost,
+			Profit:        proceeds.Sub(m.cost),
+			SoldAt:        m.movement.CreatedAt,
+		}
+		report.Sales = append(report.Sales, sale)
+
+		report.Proceeds = report.Proceeds.Add(sale.Proceeds)
+		report.Cost = report.Cost.Add(sale.Cost)
+		report.Profit = report.Profit.Add(sale.Profit)
+
+		day := sale.SoldAt.Format("2006-01-02")
+		report.ByDay[day] = report.ByDay[day].Add(sale.Profit)
+		report.ByAssociate[sale.AssociateID.Hex()] = report.ByAssociate[sale.AssociateID.Hex()].Add(sale.Profit)
+		report.ByScale[sale.Scale] = report.ByScale[sale.Scale].Add(sale.Profit)
+	}
+
+	return report, nil
+}
+
+// UnrealisedProfit values the stock held at the branches matching filter at
+// the current board rate against what it cost. Stock is valued at the sell
+// rate, the buy rate when the board has no sell rate for it.
+func UnrealisedProfit(ctx context.Context, method string, filter bson.M) (*Unrealised, error) {
+	positions, err := replay(ctx, method, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	rates, err := pricing.CurrentRates(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	scales, err := pricing.Scales(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	factors := map[string]models.Decimal{}
+	for _, scale := range scales {
+		factors[scale.Code] = scale.Factor
+	}
+
+	zero := models.NewDecimal(0, 0)
+	report := &Unrealised{
+		Method:   method,
+		Currency: models.DefaultCurrency,
+		Holdings: []Holding{},
+		Cost:     zero,
+		Value:    zero,
+		Profit:   zero,
+	}
+
+	for key, p := range positions {
+		weight, cost := p.held()
+		if weight.Sign() <= 0 {
+			continue
+		}
+
+		holding := Holding{BranchID: key.branchID, Mineral: key.mineral, Grade: key.grade, Weight: weight, Cost: cost}
+		report.Cost = report.Cost.Add(cost)
+
+		if rate := markRate(rates, factors, key.mineral, key.grade); rate != nil {
+			// stock is kept in the mineral's unit, the rate is per unit read
+			// on its scale
+			read, err := weight.Div(factors[rate.Scale], 6)
+			if err != nil {
+				return nil, err
+			}
+
+			holding.Scale = rate.Scale
+			holding.Rate = rate.Rate
+			holding.Value = read.Mul(rate.Rate).Round(pricing.AmountPlaces)
+			holding.Profit = holding.Value.Sub(cost)
+
+			report.Value = report.Value.Add(holding.Value)
+			report.Profit = report.Profit.Add(holding.Profit)
+		}
+
+		report.Holdings = append(report.Holdings, holding)
+	}
+
+	sort.Slice(report.Holdings, func(i, j int) bool {
+		a, b := report.Holdings[i], report.Holdings[j]
+		if a.BranchID != b.BranchID {
+			return a.BranchID.Hex() < b.BranchID.Hex()
+		}
+		if a.Mineral != b.Mineral {
+			return a.Mineral < b.Mineral
+		}
+		return a.Grade < b.Grade
+	})
+
+	return report, nil
+}
+
+// markRate picks the board rate stock of a mineral grade is valued at: a
+// sell rate before a buy rate, one for its grade before one for every grade,
+// and of those the first on an active scale by code.
+func markRate(rates []models.BoardRate, factors map[string]models.Decimal, mineral string, grade string) *models.BoardRate {
+	var best *models.BoardRate
+	rank := func(rate *models.BoardRate) int {
+		r := 0
+		if rate.Kind != "sell" {
+			r += 2
+		}
+		if rate.Grade != grade {
+			r++
+		}
+		return r
+	}
+
+	for i := range rates {
+		rate := &rates[i]
+		if rate.Mineral != mineral || rate.Currency != models.DefaultCurrency {
+			continue
+		}
+		if rate.Grade != grade && rate.Grade != "" {
+			continue
+		}
+		if _, ok := factors[rate.Scale]; !ok {
+			continue
+		}
+		if best == nil || rank(rate) < rank(best) || (rank(rate) == rank(best) && rate.Scale < best.Scale) {
+			best = rate
+		}
+	}
+
+	return best
+}
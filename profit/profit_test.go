@@ -0,0 +1,76 @@
+package profit
+
+import (
+	"testing"
+
+	"github.com/DreamSoft-LLC/oryan/models"
+)
+
+func dec(s string) models.Decimal {
+	return models.MustParseDecimal(s)
+}
+
+func TestPositionIssue(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		issue      string
+		cost       string
+		heldWeight string
+		heldCost   string
+	}{
+		{"first in, first out takes the cheaper lot first", MethodFIFO, "15", "1750", "5", "750"},
+		{"the average spreads the cost over the weight", MethodAverage, "15", "1875", "5", "625"},
+		{"first in, first out to the end of the first lot", MethodFIFO, "10", "1000", "10", "1500"},
+		{"the average of the same weight", MethodAverage, "10", "1250", "10", "1250"},
+		{"weight beyond what is held has no cost", MethodFIFO, "25", "2500", "0", "0"},
+		{"nor when averaging", MethodAverage, "25", "2500", "0", "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &position{}
+			p.receive(tt.method, dec("10"), dec("1000"))
+			p.receive(tt.method, dec("10"), dec("1500"))
+
+			cost, err := p.issue(dec(tt.issue))
+			if err != nil {
+				t.Fatalf("issue: %v", err)
+			}
+			if cost.Cmp(dec(tt.cost)) != 0 {
+				t.Errorf("issuing %s cost %s, want %s", tt.issue, cost, tt.cost)
+			}
+
+			weight, held := p.held()
+			if weight.Cmp(dec(tt.heldWeight)) != 0 || held.Cmp(dec(tt.heldCost)) != 0 {
+				t.Errorf("%s held at %s, want %s at %s", weight, held, tt.heldWeight, tt.heldCost)
+			}
+		})
+	}
+}
+
+// TestPositionIssueKeepsRoundingInStock issues a lot a third at a time and
+// expects the three costs to add up to the lot.
+func TestPositionIssueKeepsRoundingInStock(t *testing.T) {
+	p := &position{}
+	p.receive(MethodFIFO, dec("3"), dec("100"))
+
+	total := models.NewDecimal(0, 0)
+	for i, want := range []string{"33.33", "33.34", "33.33"} {
+		cost, err := p.issue(dec("1"))
+		if err != nil {
+			t.Fatalf("issue %d: %v", i+1, err)
+		}
+		if cost.Cmp(dec(want)) != 0 {
+			t.Errorf("issue %d cost %s, want %s", i+1, cost, want)
+		}
+		total = total.Add(cost)
+	}
+
+	if total.Cmp(dec("100")) != 0 {
+		t.Errorf("the lot went out at %s, want 100", total)
+	}
+	if len(p.lots) != 0 {
+		t.Errorf("%d lots left, want none", len(p.lots))
+	}
+}
@@ -0,0 +1,172 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/assay"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newAssayStruct(associate primitive.ObjectID) *models.Assay {
+	return &models.Assay{
+		ID:          primitive.NewObjectID(),
+		AssociateID: associate,
+		Lines:       []models.AssayLine{},
+		CreatedAt:   time.Now(),
+	}
+}
+
+func SetupAssayRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	assayRoutes := router.Group("/assays")
+	assayRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// assays, latest first, narrowed by ?stash_id=
+		assayRoutes.GET("", func(c *gin.Context) {
+			filter := bson.M{}
+
+			if stashParam := c.Query("stash_id"); stashParam != "" {
+				stashID, err := primitive.ObjectIDFromHex(stashParam)
+
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stash ID"})
+					return
+				}
+
+				filter["stash_id"] = stashID
+			}
+
+			cursor, err := database.FindManyDocuments(models.Collection.Assay, filter, bson.D{{Key: "created_at", Value: -1}})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			assays := []models.Assay{}
+
+			if err := cursor.All(c, &assays); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"assays": assays})
+		})
+
+		// fine weight lost against what was declared, per ?group=associate
+		// (default), scale or customer, over assays made between ?from= and ?to=
+		assayRoutes.GET("/losses", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			from, to, err := balanceRange(c)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			group := c.DefaultQuery("group", assay.ByAssociate)
+
+			losses, err := assay.Losses(c, bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}, group)
+
+			if errors.Is(err, assay.ErrUnknownGroup) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "group": group, "losses": losses})
+		})
+
+		// record what the refinery found in a stash lot; the fine weight is
+		// worked out from the fineness when not given
+		assayRoutes.POST("", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			body := newAssayStruct(associate.ID)
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.AssociateID = associate.ID
+			body.Void = nil
+
+			var stash models.Stash
+
+			err = database.Database.Collection(models.Collection.Stash).FindOne(c, bson.M{"_id": body.StashID}).Decode(&stash)
+
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Stash not found"})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := assay.Prepare(c, body, &stash); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := database.Database.Collection(models.Collection.Assay).FindOne(c, database.ExcludeVoided(bson.M{"stash_id": stash.ID})).Err(); err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "The stash already has an assay, void it first"})
+				return
+			}
+
+			if _, err := database.InsertDocument(models.Collection.Assay, utils.ConvertStructPrimitive(body)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"assay": body, "message": "Assay recorded"})
+		})
+
+		// an assay with its lines
+		assayRoutes.GET("/:id", func(c *gin.Context) {
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			var result models.Assay
+
+			if err := database.Database.Collection(models.Collection.Assay).FindOne(c, bson.M{"_id": id}).Decode(&result); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Assay not found"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"assay": result})
+		})
+
+		// void an assay recorded against the wrong lot or with wrong figures
+		assayRoutes.POST("/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.Assay, "created_at"))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
 	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/DreamSoft-LLC/oryan/models"
 	"github.com/DreamSoft-LLC/oryan/utils"
@@ -50,6 +51,13 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 				return
 			}
 
+			if !body.BranchID.IsZero() {
+				if err := database.FindDocument(models.Collection.Branch, bson.D{{Key: "_id", Value: body.BranchID}}).Err(); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": errUnknownBranch.Error()})
+					return
+				}
+			}
+
 			securePassword, err := utils.HashPassword(body.Password)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -140,13 +148,29 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 				"associate": associate,
 			}
 
-			var todayTransactionSum primitive.Decimal128
-			var allTimeTransactionSum primitive.Decimal128
+			// sums are reported in ?currency= at the rate of the day of each record
+			currency, err := queryCurrency(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
 
-			err = database.SumDocuments(models.Collection.Transaction, bson.M{
+			book, err := ledger.LoadRateBook(c, currency)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			response["currency"] = currency
+
+			var todayTransactionSum models.Decimal
+			var allTimeTransactionSum models.Decimal
+
+			todayTransactionSum, err = ledger.ConvertedSum(c, book, models.Collection.Transaction, bson.M{
 				"associate_id": associate.ID,
 				"created_at":   bson.M{"$gte": startOfDay},
-			}, "amount", &todayTransactionSum)
+			}, "amount", "created_at")
 
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate today's transaction sum", "message": err.Error()})
@@ -154,7 +178,7 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 				return
 			}
 
-			err = database.SumDocuments(models.Collection.Transaction, associateFilter, "amount", &allTimeTransactionSum)
+			allTimeTransactionSum, err = ledger.ConvertedSum(c, book, models.Collection.Transaction, associateFilter, "amount", "created_at")
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate all-time transaction sum", "message": err.Error()})
 				c.Abort()
@@ -162,13 +186,13 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 			}
 
 			// Sum of loans
-			var todayLoanSum primitive.Decimal128
-			var allTimeLoanSum primitive.Decimal128
+			var todayLoanSum models.Decimal
+			var allTimeLoanSum models.Decimal
 
-			err = database.SumDocuments(models.Collection.Loan, bson.M{
+			todayLoanSum, err = ledger.ConvertedSum(c, book, models.Collection.Loan, bson.M{
 				"associate_id": associate.ID,
 				"created_at":   bson.M{"$gte": startOfDay},
-			}, "amount", &todayLoanSum)
+			}, "amount", "created_at")
 
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate today's loan sum"})
@@ -176,7 +200,7 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 				return
 			}
 
-			err = database.SumDocuments(models.Collection.Loan, associateFilter, "amount", &allTimeLoanSum)
+			allTimeLoanSum, err = ledger.ConvertedSum(c, book, models.Collection.Loan, associateFilter, "amount", "created_at")
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate all-time loan sum"})
 				c.Abort()
@@ -184,13 +208,13 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 			}
 
 			// Sum of miscellaneous
-			var todayMiscellaneousSum primitive.Decimal128
-			var allTimeMiscellaneousSum primitive.Decimal128
+			var todayMiscellaneousSum models.Decimal
+			var allTimeMiscellaneousSum models.Decimal
 
-			err = database.SumDocuments(models.Collection.Miscellaneous, bson.M{
+			todayMiscellaneousSum, err = ledger.ConvertedSum(c, book, models.Collection.Miscellaneous, bson.M{
 				"associate_id": associate.ID,
-				"created_at":   bson.M{"$gte": startOfDay},
-			}, "amount", &todayMiscellaneousSum)
+				"created_date": bson.M{"$gte": startOfDay},
+			}, "amount", "created_date")
 
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate today's miscellaneous sum"})
@@ -198,7 +222,7 @@ func SetupAssociatesRoutes(router *gin.Engine) {
 				return
 			}
 
-			err = database.SumDocuments(models.Collection.Miscellaneous, associateFilter, "amount", &allTimeMiscellaneousSum)
+			allTimeMiscellaneousSum, err = ledger.ConvertedSum(c, book, models.Collection.Miscellaneous, associateFilter, "amount", "created_date")
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate all-time miscellaneous sum"})
 				c.Abort()
@@ -1,14 +1,13 @@
 package routers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/DreamSoft-LLC/oryan/models"
 	"github.com/DreamSoft-LLC/oryan/utils"
 	"github.com/gin-gonic/gin"
@@ -20,7 +19,7 @@ import (
 func newBalanceStruct() *models.Balance {
 	return &models.Balance{
 		ID:        primitive.NewObjectID(),
-		Spent:     "0",
+		Spent:     models.NewDecimal(0, 0),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -30,6 +29,7 @@ func newFundStruct(associate primitive.ObjectID) *models.Fund {
 	return &models.Fund{
 		ID:          primitive.NewObjectID(),
 		AssociateID: associate,
+		Currency:    models.DefaultCurrency,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -43,86 +43,85 @@ func SetupBalancesRoutes(router *gin.Engine) {
 
 		balanceRoutes.GET("/", func(context *gin.Context) {
 
-			balanceDocumentID := os.Getenv("BALANCE_ID")
+			associate, err := authAssociate(context)
 
-			if balanceDocumentID == "" {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Balance document ID not set"})
+			if err != nil {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
 				return
 			}
 
-			fixedID, err := primitive.ObjectIDFromHex(balanceDocumentID)
+			branchID, err := listBranch(context, associate)
 
 			if err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid balance document ID"})
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			var balanceDoc models.Balance
-
-			docResult := database.FindDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}})
+			currency, err := queryCurrency(context)
 
-			err = docResult.Decode(&balanceDoc)
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-			fmt.Println(balanceDoc.Amount)
+			// Admins without a branch get every branch added up, currency by currency
+			if branchID.IsZero() {
+				balances, err := ledger.AllBalances(context)
 
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					fmt.Println("Balance document not found, creating new document with fixed ID...")
+				if err != nil {
+					context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
 
+				consolidated := map[string]*models.Balance{
+					currency: {Currency: currency, Amount: models.NewDecimal(0, 0), Spent: models.NewDecimal(0, 0), UpdatedAt: time.Now()},
 				}
-				// Log the error (optional)
-
-				// Create a new balance document with the fixed ID
-				balanceDoc = models.Balance{
-					ID:        fixedID, // Use the fixed ID
-					Amount:    "0",     // Initial amount (modify as needed)
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
+				for _, balance := range balances {
+					total, ok := consolidated[balance.Currency]
+					if !ok {
+						total = &models.Balance{Currency: balance.Currency, Amount: models.NewDecimal(0, 0), Spent: models.NewDecimal(0, 0), UpdatedAt: time.Now()}
+						consolidated[balance.Currency] = total
+					}
+					total.Amount = total.Amount.Add(balance.Amount)
+					total.Spent = total.Spent.Add(balance.Spent)
 				}
 
-				_, err := database.InsertDocument(models.Collection.Balance, utils.ConvertStructPrimitive(balanceDoc))
-
-				if err != nil {
-					context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create balance document: " + err.Error()})
-					return
+				totals := make([]*models.Balance, 0, len(consolidated))
+				for _, total := range consolidated {
+					totals = append(totals, total)
 				}
 
-				// Return the newly created balance document
-				context.JSON(http.StatusCreated, gin.H{"balance": balanceDoc})
+				context.JSON(http.StatusOK, gin.H{"balance": consolidated[currency], "balances": totals, "branches": balances})
 				return
 			}
 
-			// If the document exists, return the balance document
-			context.JSON(http.StatusOK, gin.H{"balance": balanceDoc})
-		})
+			balanceDoc, err := ledger.BranchBalance(context, branchID, currency)
 
-		// POST /balance
-		balanceRoutes.POST("/", func(context *gin.Context) {
-
-			balanceDocumentID := os.Getenv("BALANCE_ID")
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
 
-			auth, _ := context.Get("auth")
-			authentication := auth.(*utils.Authentication)
+			balances, err := ledger.BranchBalances(context, branchID)
 
-			if balanceDocumentID == "" {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Balance document ID not set"})
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
 
-			idStr := authentication.ID
-			if strings.HasPrefix(idStr, "ObjectID(") && strings.HasSuffix(idStr, ")") {
-				idStr = idStr[9 : len(idStr)-1]
-			}
+			context.JSON(http.StatusOK, gin.H{"balance": balanceDoc, "balances": balances})
+		})
 
-			idStr = strings.Trim(idStr, "\"")
+		// POST /balance
+		balanceRoutes.POST("/", middlewares.Idempotent(), func(context *gin.Context) {
 
-			objectId, err := primitive.ObjectIDFromHex(idStr)
+			associate, err := authAssociate(context)
 			if err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ObjectID format"})
 				return
 			}
 
-			body := newFundStruct(objectId)
+			body := newFundStruct(associate.ID)
 
 			// Bind the JSON request to the fund struct
 			if err := context.ShouldBindJSON(&body); err != nil {
@@ -130,59 +129,285 @@ func SetupBalancesRoutes(router *gin.Engine) {
 				return
 			}
 
+			stampRecord(associate, &body.AssociateID, &body.CreatedAt, &body.Void)
+
 			// Validate the required fields
 			if err := models.ValidateStruct.Struct(body); err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": "Validation error: " + err.Error()})
 				return
 			}
 
-			// Insert the new fund document
-			insertResult, err := database.InsertDocument(models.Collection.Fund, utils.ConvertStructPrimitive(body))
+			body.BranchID, err = resolveBranch(associate, body.BranchID)
 			if err != nil {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert fund document: " + err.Error()})
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			body.ID = insertResult.InsertedID.(primitive.ObjectID)
-
-			// Find the balance document by ID
+			// Insert the fund, post it and read back the balance in one transaction
 			var balanceDoc models.Balance
-			docResult := database.FindDocumentById(models.Collection.Balance, balanceDocumentID)
-			if err := docResult.Decode(&balanceDoc); err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Failed to decode balance document: " + err.Error()})
+
+			err = database.WithTransaction(context, func(sessionContext mongo.SessionContext) error {
+				if err := ledger.EnsureOpen(sessionContext, body.CreatedAt); err != nil {
+					return err
+				}
+
+				if _, err := database.InsertDocumentContext(sessionContext, models.Collection.Fund, utils.ConvertStructPrimitive(body)); err != nil {
+					return err
+				}
+
+				_, err := ledger.Record(sessionContext, ledger.EventFund, body.Amount, ledger.Source{
+					AssociateID:   body.AssociateID,
+					BranchID:      body.BranchID,
+					ReferenceID:   body.ID,
+					ReferenceType: models.Collection.Fund,
+					OccurredAt:    body.CreatedAt,
+					Currency:      body.Currency,
+				})
+				if err != nil {
+					return err
+				}
+
+				return database.Database.Collection(models.Collection.Balance).
+					FindOne(sessionContext, bson.D{{Key: "branch_id", Value: body.BranchID}, {Key: "currency", Value: body.Currency}}).
+					Decode(&balanceDoc)
+			})
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance: " + err.Error()})
 				return
 			}
 
-			// Parse the amounts from strings to floats
-			newAmount, err := strconv.ParseFloat(body.Amount, 64)
+			// Return the updated balance
+			context.JSON(http.StatusOK, gin.H{"balance": balanceDoc.Amount})
+		})
+
+		// balance as it stood at a moment, ?at=RFC3339 or YYYY-MM-DD (end of that day)
+		balanceRoutes.GET("/at", func(context *gin.Context) {
+			branchIDs, err := balanceBranches(context)
+
 			if err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fund amount format"})
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			balanceAmount, err := strconv.ParseFloat(balanceDoc.Amount, 64)
+			currency, err := queryCurrency(context)
+
 			if err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid balance amount format"})
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			balanceObjectId, _ := primitive.ObjectIDFromHex(balanceDocumentID)
 
-			s := fmt.Sprintf("%f", balanceAmount+newAmount)
+			at := time.Now()
+			if atParam := context.Query("at"); atParam != "" {
+				at, err = parseMoment(atParam)
+				if err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid at, expected RFC3339 or YYYY-MM-DD"})
+					return
+				}
+			}
+
+			total := models.NewDecimal(0, 0)
+			branches := make([]gin.H, 0, len(branchIDs))
 
-			// Update the balance document by adding the new fund amount
-			_, err = database.UpdateDocument(
-				models.Collection.Balance,
-				bson.D{{Key: "_id", Value: balanceObjectId}},
-				bson.D{{Key: "$set", Value: bson.D{{Key: "amount", Value: s}}}},
-			)
+			for _, branchID := range branchIDs {
+				amount, err := ledger.BalanceAt(context, branchID, currency, at)
+
+				if err != nil {
+					context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				total = total.Add(amount)
+				branches = append(branches, gin.H{"branch_id": branchID, "amount": amount})
+			}
+
+			context.JSON(http.StatusOK, gin.H{
+				"at":       at,
+				"currency": currency,
+				"balance":  total,
+				"branches": branches,
+			})
+		})
+
+		// every change of the balance between ?from= and ?to=, with its cause
+		balanceRoutes.GET("/history", func(context *gin.Context) {
+			branchIDs, err := balanceBranches(context)
 
 			if err != nil {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance: " + err.Error()})
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			// Return the updated balance
-			context.JSON(http.StatusOK, gin.H{"balance": balanceAmount + newAmount})
+			currency, err := queryCurrency(context)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			from, to, err := balanceRange(context)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			histories := []models.BalanceHistory{}
+
+			for _, branchID := range branchIDs {
+				branchHistories, err := ledger.BalanceHistories(context, branchID, currency, from, to)
+
+				if err != nil {
+					context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				histories = append(histories, branchHistories...)
+			}
+
+			context.JSON(http.StatusOK, gin.H{"currency": currency, "history": histories})
+		})
+
+		// closing balance of each day between ?from= and ?to=
+		balanceRoutes.GET("/chart", func(context *gin.Context) {
+			branchIDs, err := balanceBranches(context)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			currency, err := queryCurrency(context)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			from, to, err := balanceRange(context)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if to.Sub(from) > maxChartDays*24*time.Hour {
+				context.JSON(http.StatusBadRequest, gin.H{"error": "The chart covers at most a year"})
+				return
+			}
+
+			// sample at the end of every day
+			step := 24 * time.Hour
+			var points []ledger.BalancePoint
+
+			for _, branchID := range branchIDs {
+				series, err := ledger.BalanceSeries(context, branchID, currency, from.Add(step), to, step)
+
+				if err != nil {
+					context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				if points == nil {
+					points = series
+					continue
+				}
+				for i := range points {
+					points[i].Amount = points[i].Amount.Add(series[i].Amount)
+				}
+			}
+
+			chart := make([]gin.H, 0, len(points))
+			for _, point := range points {
+				chart = append(chart, gin.H{"date": point.At.Add(-step).Format(dayLayout), "amount": point.Amount})
+			}
+
+			context.JSON(http.StatusOK, gin.H{"currency": currency, "chart": chart})
 		})
+
+		// void a fund and reverse what it posted
+		balanceRoutes.POST("/funds/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.Fund, "created_at"))
+	}
+}
+
+// maxChartDays bounds the balance chart
+const maxChartDays = 366
+
+// balanceBranches lists the branches a balance query covers: the caller's
+// own, the one an admin asks for, or every branch for an admin.
+func balanceBranches(c *gin.Context) ([]primitive.ObjectID, error) {
+	associate, err := authAssociate(c)
+	if err != nil {
+		return nil, err
+	}
+
+	branchID, err := listBranch(c, associate)
+	if err != nil {
+		return nil, err
+	}
+
+	if !branchID.IsZero() {
+		return []primitive.ObjectID{branchID}, nil
 	}
+
+	var branches []models.Branch
+
+	cursor, err := database.FindDocuments(models.Collection.Branch, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cursor.All(c, &branches); err != nil {
+		return nil, err
+	}
+
+	branchIDs := make([]primitive.ObjectID, 0, len(branches))
+	for _, branch := range branches {
+		branchIDs = append(branchIDs, branch.ID)
+	}
+
+	return branchIDs, nil
+}
+
+// parseMoment reads an RFC3339 timestamp, or a YYYY-MM-DD date as the end
+// of that day.
+func parseMoment(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	day, err := time.Parse(dayLayout, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// balanceRange reads ?from= and ?to= as whole days, the last 30 days by default.
+func balanceRange(c *gin.Context) (time.Time, time.Time, error) {
+	to, err := parseDay(c.Query("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid to, expected YYYY-MM-DD")
+	}
+	to = to.AddDate(0, 0, 1)
+
+	from := to.AddDate(0, 0, -30)
+	if fromParam := c.Query("from"); fromParam != "" {
+		from, err = time.Parse(dayLayout, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from, expected YYYY-MM-DD")
+		}
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+
+	return from, to, nil
 }
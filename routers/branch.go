@@ -0,0 +1,233 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newBranchStruct() *models.Branch {
+	return &models.Branch{
+		ID:        primitive.NewObjectID(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// branchSummary is one branch's line in the consolidated view
+type branchSummary struct {
+	Branch        models.Branch  `json:"branch"`
+	Balance       models.Decimal `json:"balance"`
+	Buys          models.Decimal `json:"buys"`
+	Sells         models.Decimal `json:"sells"`
+	Loans         models.Decimal `json:"loans"`
+	Miscellaneous models.Decimal `json:"miscellaneous"`
+}
+
+func SetupBranchRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	branchRoutes := router.Group("/branches")
+	branchRoutes.Use(jwtAuthService.AuthMiddleware(), middlewares.IsAdminValidate())
+	{
+
+		// create a new branch
+		branchRoutes.POST("", func(c *gin.Context) {
+			body := newBranchStruct()
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := database.FindDocument(models.Collection.Branch, bson.D{{Key: "code", Value: body.Code}}).Err(); err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A branch with this code already exists"})
+				return
+			}
+
+			insertResult, err := database.InsertDocument(models.Collection.Branch, utils.ConvertStructPrimitive(body))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"created": insertResult,
+				"branch":  body,
+				"message": "Successfully added a new branch",
+			})
+		})
+
+		// list of branches
+		branchRoutes.GET("", func(c *gin.Context) {
+			cursor, err := database.FindDocuments(models.Collection.Branch, bson.D{})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			branches := []models.Branch{}
+
+			if err = cursor.All(c, &branches); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"branches": branches})
+		})
+
+		// balances and money movements of every branch side by side, with
+		// totals, converted into ?currency= at the rates of the day they happened
+		branchRoutes.GET("/consolidated", func(c *gin.Context) {
+			currency, err := queryCurrency(c)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			book, err := ledger.LoadRateBook(c, currency)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			cursor, err := database.FindDocuments(models.Collection.Branch, bson.D{})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			var branches []models.Branch
+
+			if err = cursor.All(c, &branches); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			zero := models.NewDecimal(0, 0)
+			total := branchSummary{Balance: zero, Buys: zero, Sells: zero, Loans: zero, Miscellaneous: zero}
+			summaries := make([]branchSummary, 0, len(branches))
+
+			for _, branch := range branches {
+				summary := branchSummary{Branch: branch}
+
+				balances, err := ledger.BranchBalances(c, branch.ID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				// cash on hand is valued at today's rate
+				summary.Balance = zero
+				for _, balance := range balances {
+					amount, err := book.Convert(balance.Amount, balance.Currency, time.Now())
+					if err != nil {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+					summary.Balance = summary.Balance.Add(amount)
+				}
+
+				sums := []struct {
+					collection string
+					filter     bson.M
+					dateField  string
+					result     *models.Decimal
+				}{
+					{models.Collection.Transaction, bson.M{"branch_id": branch.ID, "kind": "buy"}, "created_at", &summary.Buys},
+					{models.Collection.Transaction, bson.M{"branch_id": branch.ID, "kind": "sell"}, "created_at", &summary.Sells},
+					{models.Collection.Loan, bson.M{"branch_id": branch.ID, "type": "credit"}, "created_at", &summary.Loans},
+					{models.Collection.Miscellaneous, bson.M{"branch_id": branch.ID}, "created_date", &summary.Miscellaneous},
+				}
+
+				for _, sum := range sums {
+					*sum.result, err = ledger.ConvertedSum(c, book, sum.collection, sum.filter, "amount", sum.dateField)
+					if errors.Is(err, ledger.ErrNoRate) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+					if err != nil {
+						c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+						return
+					}
+				}
+
+				total.Balance = total.Balance.Add(summary.Balance)
+				total.Buys = total.Buys.Add(summary.Buys)
+				total.Sells = total.Sells.Add(summary.Sells)
+				total.Loans = total.Loans.Add(summary.Loans)
+				total.Miscellaneous = total.Miscellaneous.Add(summary.Miscellaneous)
+
+				summaries = append(summaries, summary)
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"currency": currency,
+				"branches": summaries,
+				"total":    total,
+			})
+		})
+
+		// move an associate to a branch
+		branchRoutes.POST("/:id/associates", func(c *gin.Context) {
+			branchID, err := primitive.ObjectIDFromHex(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch ID"})
+				return
+			}
+
+			var body struct {
+				AssociateID primitive.ObjectID `json:"associate_id" validate:"required"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := database.FindDocument(models.Collection.Branch, bson.D{{Key: "_id", Value: branchID}}).Err(); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+				return
+			}
+
+			result, err := database.UpdateDocument(models.Collection.Associate,
+				bson.D{{Key: "_id", Value: body.AssociateID}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "branch_id", Value: branchID}, {Key: "updated_at", Value: time.Now()}}}},
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if result.MatchedCount == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Associate not found"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": "Associate assigned to branch"})
+		})
+	}
+}
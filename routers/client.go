@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/lending"
 	"github.com/DreamSoft-LLC/oryan/models"
 	"github.com/DreamSoft-LLC/oryan/utils"
 	"github.com/gin-gonic/gin"
@@ -282,34 +284,56 @@ func SetupClientRoutes(router *gin.Engine) {
 				return
 			}
 
-			//	now do calculations
-			var totalTransactionAmount, totalLoanAmount, totalLoanSettlement float64
+			// what is owed comes from the loan accounts; totals are reported in
+			// ?currency= at the rate of the day of each record
+			accounts, err := lending.Accounts(c, bson.M{"customer_id": objID})
 
-			for _, transaction := range transactions {
-				amount, _ := strconv.ParseFloat(transaction.Amount, 64)
-				totalTransactionAmount += amount
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
 			}
 
-			for _, loan := range loans {
-				amount, _ := strconv.ParseFloat(loan.Amount, 64)
-				if loan.Type == "credit" { // Assuming "credit" means loan taken
-					totalLoanAmount += amount
-				} else if loan.Type == "debit" { // Assuming "debit" means loan settled
-					totalLoanSettlement += amount
-				}
+			currency, err := queryCurrency(c)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
 			}
 
-			totalLoanToBePaid := totalLoanAmount - totalLoanSettlement
+			book, err := ledger.LoadRateBook(c, currency)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			loanTotals, err := lending.Total(accounts, book)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			// voided buys and sells no longer count
+			totalTransactionAmount, err := ledger.ConvertedSum(c, book, models.Collection.Transaction, bson.M{"customer_id": objID}, "amount", "created_at")
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
 
 			// Respond with the results
 			c.JSON(http.StatusOK, gin.H{
 				"customer":                 customer,
 				"transactions":             transactions,
 				"loans":                    loans,
+				"loan_accounts":            accounts,
+				"currency":                 currency,
 				"total_transaction_amount": totalTransactionAmount,
-				"total_loan_amount":        totalLoanAmount,
-				"total_loan_settlement":    totalLoanSettlement,
-				"total_loan_to_be_paid":    totalLoanToBePaid,
+				"total_loan_amount":        loanTotals.Principal,
+				"total_loan_settlement":    loanTotals.Repaid,
+				"total_loan_written_off":   loanTotals.WrittenOff,
+				"total_loan_to_be_paid":    loanTotals.Outstanding,
 			})
 
 		})
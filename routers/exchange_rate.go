@@ -0,0 +1,137 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newExchangeRateStruct(associate primitive.ObjectID) *models.ExchangeRate {
+	return &models.ExchangeRate{
+		ID:        primitive.NewObjectID(),
+		CreatedBy: associate,
+		CreatedAt: time.Now(),
+	}
+}
+
+func SetupExchangeRateRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	rateRoutes := router.Group("/exchange-rates")
+	rateRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// recorded rates, latest first, optionally for ?base= and ?quote=
+		rateRoutes.GET("", func(c *gin.Context) {
+			filter := bson.M{}
+
+			if base := c.Query("base"); base != "" {
+				filter["base"] = strings.ToUpper(base)
+			}
+
+			if quote := c.Query("quote"); quote != "" {
+				filter["quote"] = strings.ToUpper(quote)
+			}
+
+			cursor, err := database.Database.Collection(models.Collection.ExchangeRate).Find(c, filter,
+				options.Find().SetSort(bson.D{{Key: "effective_from", Value: -1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			rates := []models.ExchangeRate{}
+
+			if err = cursor.All(c, &rates); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"rates": rates})
+		})
+
+		// the rate from ?from= to ?to= in force ?at= (now by default)
+		rateRoutes.GET("/convert", func(c *gin.Context) {
+			from := strings.ToUpper(c.Query("from"))
+			to := strings.ToUpper(c.Query("to"))
+
+			if models.ValidateStruct.Var(from, "iso4217") != nil || models.ValidateStruct.Var(to, "iso4217") != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCurrency.Error()})
+				return
+			}
+
+			at := time.Now()
+			if atParam := c.Query("at"); atParam != "" {
+				var err error
+				at, err = parseMoment(atParam)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid at, expected RFC3339 or YYYY-MM-DD"})
+					return
+				}
+			}
+
+			rate, err := ledger.RateAt(c, from, to, at)
+
+			if errors.Is(err, ledger.ErrNoRate) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "at": at, "rate": rate})
+		})
+
+		// record a rate taking effect at effective_from, now when empty
+		rateRoutes.POST("", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			body := newExchangeRateStruct(associate.ID)
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.Base = strings.ToUpper(body.Base)
+			body.Quote = strings.ToUpper(body.Quote)
+			body.CreatedBy = associate.ID
+
+			if body.EffectiveFrom.IsZero() {
+				body.EffectiveFrom = body.CreatedAt
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if _, err := database.InsertDocument(models.Collection.ExchangeRate, utils.ConvertStructPrimitive(body)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"rate": body, "message": "Exchange rate recorded"})
+		})
+	}
+}
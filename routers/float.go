@@ -0,0 +1,264 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newFloatTransferStruct(recordedBy primitive.ObjectID, kind string) *models.FloatTransfer {
+	return &models.FloatTransfer{
+		ID:         primitive.NewObjectID(),
+		RecordedBy: recordedBy,
+		Kind:       kind,
+		Currency:   models.DefaultCurrency,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+}
+
+// associateFloat is a float together with the name of the associate holding it
+type associateFloat struct {
+	Name string `json:"name"`
+	*models.Float
+}
+
+// floatTransferHandler records cash handed to (issue) or taken back from
+// (return) an associate against the till of the associate's branch.
+func floatTransferHandler(kind string, event string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, err := authAssociate(c)
+
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+			return
+		}
+
+		body := newFloatTransferStruct(admin.ID, kind)
+
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := models.ValidateStruct.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		body.Kind = kind
+		body.RecordedBy = admin.ID
+
+		var holder models.Associate
+
+		if err := database.FindDocument(models.Collection.Associate, bson.D{{Key: "_id", Value: body.AssociateID}}).Decode(&holder); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Associate not found"})
+			return
+		}
+
+		// the float comes from, and goes back to, the holder's own branch
+		body.BranchID, err = resolveBranch(&holder, primitive.NilObjectID)
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var float *models.Float
+
+		err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+			if _, err := database.InsertDocumentContext(sessionContext, models.Collection.FloatTransfer, utils.ConvertStructPrimitive(body)); err != nil {
+				return err
+			}
+
+			_, err := ledger.Record(sessionContext, event, body.Amount, ledger.Source{
+				AssociateID:   body.RecordedBy,
+				BranchID:      body.BranchID,
+				HolderID:      body.AssociateID,
+				ReferenceID:   body.ID,
+				ReferenceType: models.Collection.FloatTransfer,
+				OccurredAt:    body.CreatedAt,
+				Description:   body.Description,
+				Currency:      body.Currency,
+			})
+			if err != nil {
+				return err
+			}
+
+			float, err = ledger.AssociateFloat(sessionContext, body.AssociateID, body.Currency)
+			return err
+		})
+
+		if errors.Is(err, ledger.ErrPeriodClosed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Insuficient balance available contact admin"})
+			return
+		}
+
+		if errors.Is(err, ledger.ErrInsufficientFloat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The associate is not holding that much float"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"transfer": body,
+			"float":    float,
+		})
+	}
+}
+
+func SetupFloatRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	floatRoutes := router.Group("/floats")
+	floatRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// float, issued, spent and returned of every associate the caller may see
+		floatRoutes.GET("", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			associates := []models.Associate{*associate}
+
+			if associate.Role == "admin" {
+				branchID, err := listBranch(c, associate)
+
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				filter := bson.D{}
+				if !branchID.IsZero() {
+					filter = append(filter, bson.E{Key: "branch_id", Value: branchID})
+				}
+
+				cursor, err := database.FindDocuments(models.Collection.Associate, filter)
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err = cursor.All(c, &associates); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			floats := make([]associateFloat, 0, len(associates))
+
+			// one line per associate and currency held
+			for _, a := range associates {
+				held, err := ledger.AssociateFloats(c, a.ID)
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				for i := range held {
+					floats = append(floats, associateFloat{Name: a.Name, Float: &held[i]})
+				}
+			}
+
+			c.JSON(http.StatusOK, gin.H{"floats": floats})
+		})
+
+		// one associate's float and the cash moved in and out of it
+		floatRoutes.GET("/:associate_id", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			holderID, err := primitive.ObjectIDFromHex(c.Param("associate_id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid associate ID"})
+				return
+			}
+
+			if associate.Role != "admin" && associate.ID != holderID {
+				c.JSON(http.StatusUnauthorized, gin.H{"message": "You do not have permission to the resource"})
+				return
+			}
+
+			currency, err := queryCurrency(c)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			float, err := ledger.AssociateFloat(c, holderID, currency)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			floats, err := ledger.AssociateFloats(c, holderID)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			cursor, err := database.Database.Collection(models.Collection.FloatTransfer).Find(c,
+				bson.D{{Key: "associate_id", Value: holderID}},
+				options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			transfers := []models.FloatTransfer{}
+
+			if err = cursor.All(c, &transfers); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"float":     float,
+				"floats":    floats,
+				"transfers": transfers,
+			})
+		})
+
+		// hand cash from the till to an associate
+		floatRoutes.POST("/issue", middlewares.IsAdminValidate(), middlewares.Idempotent(), floatTransferHandler("issue", ledger.EventFloatIssue))
+
+		// take unspent cash back from an associate into the till
+		floatRoutes.POST("/return", middlewares.IsAdminValidate(), middlewares.Idempotent(), floatTransferHandler("return", ledger.EventFloatReturn))
+	}
+}
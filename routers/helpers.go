@@ -0,0 +1,173 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errNoBranch      = errors.New("associate is not assigned to a branch, branch_id is required")
+	errUnknownBranch = errors.New("branch not found")
+)
+
+// authAssociate loads the associate the request's token belongs to.
+func authAssociate(c *gin.Context) (*models.Associate, error) {
+	auth, _ := c.Get("auth")
+	authentication := auth.(*utils.Authentication)
+
+	idStr := authentication.ID
+	if strings.HasPrefix(idStr, "ObjectID(") && strings.HasSuffix(idStr, ")") {
+		idStr = idStr[9 : len(idStr)-1]
+	}
+	idStr = strings.Trim(idStr, "\"")
+
+	objectId, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var associate models.Associate
+	if err := database.FindDocument(models.Collection.Associate, bson.D{{Key: "_id", Value: objectId}}).Decode(&associate); err != nil {
+		return nil, err
+	}
+
+	return &associate, nil
+}
+
+// resolveBranch picks the branch a write is recorded against. Associates
+// always work in their own branch; admins may name another with requested.
+func resolveBranch(associate *models.Associate, requested primitive.ObjectID) (primitive.ObjectID, error) {
+	branchID := associate.BranchID
+	if associate.Role == "admin" && !requested.IsZero() {
+		branchID = requested
+	}
+
+	if branchID.IsZero() {
+		return primitive.NilObjectID, errNoBranch
+	}
+
+	if err := database.FindDocument(models.Collection.Branch, bson.D{{Key: "_id", Value: branchID}}).Err(); err != nil {
+		return primitive.NilObjectID, errUnknownBranch
+	}
+
+	return branchID, nil
+}
+
+// listBranch picks the branch a list is limited to. Associates only see
+// their own branch; admins see every branch unless they pass ?branch_id=.
+// A nil id means no branch filter.
+func listBranch(c *gin.Context, associate *models.Associate) (primitive.ObjectID, error) {
+	if associate.Role == "admin" {
+		if branchParam := c.Query("branch_id"); branchParam != "" {
+			return primitive.ObjectIDFromHex(branchParam)
+		}
+		return primitive.NilObjectID, nil
+	}
+
+	if associate.BranchID.IsZero() {
+		return primitive.NilObjectID, errNoBranch
+	}
+
+	return associate.BranchID, nil
+}
+
+// stampRecord sets who made a record being created and when, whatever the
+// client sent: records are created live and belong to the caller, dated by
+// resolveDate. Only the void route marks them voided.
+func stampRecord(associate *models.Associate, associateID *primitive.ObjectID, createdAt *time.Time, void **models.Void) {
+	*associateID = associate.ID
+	*createdAt = resolveDate(associate, *createdAt)
+	*void = nil
+}
+
+// inBranch reports whether associate may reach a record of branchID.
+// Associates only reach their own branch's records; admins reach every
+// branch's, as with listBranch.
+func inBranch(associate *models.Associate, branchID primitive.ObjectID) bool {
+	if associate.Role == "admin" {
+		return true
+	}
+	return !associate.BranchID.IsZero() && associate.BranchID == branchID
+}
+
+// resolveDate picks the date a write is recorded at. Associates always
+// record at the server's time; admins may backdate with requested into a
+// period that is still open.
+func resolveDate(associate *models.Associate, requested time.Time) time.Time {
+	if associate.Role == "admin" && !requested.IsZero() {
+		return requested
+	}
+	return time.Now()
+}
+
+var errInvalidCurrency = errors.New("invalid currency, expected an ISO 4217 code")
+
+// queryCurrency reads the currency a query reports in from ?currency=, the
+// default currency when it is not given.
+func queryCurrency(c *gin.Context) (string, error) {
+	currency := strings.ToUpper(c.Query("currency"))
+	if currency == "" {
+		return models.DefaultCurrency, nil
+	}
+
+	if err := models.ValidateStruct.Var(currency, "iso4217"); err != nil {
+		return "", errInvalidCurrency
+	}
+
+	return currency, nil
+}
+
+var (
+	errBuyTaken       = errors.New("only live buys at the branch, of the mineral and grade and not already in a stash or melt, can be added")
+	errUnknownScaleOf = errors.New("the scale the transaction was weighed on is not known")
+)
+
+// takeBuy marks a buy as taken into a stash or melt lot by setting field to
+// lotID, and returns it with its weight in the mineral's unit. The buy must
+// be live, at branchID, of mineral and, when grade is set, of that grade, and
+// not already in a lot.
+func takeBuy(ctx context.Context, id primitive.ObjectID, field string, lotID primitive.ObjectID, branchID primitive.ObjectID, mineral string, grade string) (*models.Transaction, models.Decimal, error) {
+	var transaction models.Transaction
+	if err := database.Database.Collection(models.Collection.Transaction).FindOne(ctx, bson.M{"_id": id}).Decode(&transaction); err != nil {
+		return nil, models.Decimal{}, fmt.Errorf("%w: %s", errNotFound, id.Hex())
+	}
+
+	if transaction.Kind != "buy" || transaction.Void != nil || transaction.StashID != nil || transaction.MeltID != nil ||
+		transaction.BranchID != branchID || transaction.Mineral != mineral || (grade != "" && transaction.Grade != grade) {
+		return nil, models.Decimal{}, fmt.Errorf("%w: %s", errBuyTaken, id.Hex())
+	}
+
+	// the factor it was weighed with, the scale's own for buys recorded
+	// before it was kept
+	factor := transaction.Factor
+	if !factor.IsSet() {
+		var scale models.Scale
+		if err := database.Database.Collection(models.Collection.Scale).FindOne(ctx, bson.M{"code": transaction.Scale}).Decode(&scale); err != nil {
+			return nil, models.Decimal{}, fmt.Errorf("%w: %s", errUnknownScaleOf, transaction.Scale)
+		}
+		factor = scale.Factor
+	}
+
+	result, err := database.Database.Collection(models.Collection.Transaction).UpdateOne(ctx,
+		bson.M{"_id": id, "stash_id": bson.M{"$exists": false}, "melt_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{field: lotID}},
+	)
+	if err != nil {
+		return nil, models.Decimal{}, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, models.Decimal{}, fmt.Errorf("%w: %s", errBuyTaken, id.Hex())
+	}
+
+	return &transaction, transaction.Weight.Mul(factor), nil
+}
@@ -0,0 +1,136 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// stockFilter limits stock to the branch the associate may see and narrows
+// it by ?mineral= and ?grade=
+func stockFilter(c *gin.Context, associate *models.Associate) (bson.M, error) {
+	branchID, err := listBranch(c, associate)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+
+	if !branchID.IsZero() {
+		filter["branch_id"] = branchID
+	}
+
+	for _, key := range []string{"mineral", "grade"} {
+		if value := c.Query(key); value != "" {
+			filter[key] = strings.ToLower(value)
+		}
+	}
+
+	return filter, nil
+}
+
+func SetupInventoryRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	inventoryRoutes := router.Group("/inventory")
+	inventoryRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// stock on hand per branch, mineral and grade, with totals per mineral
+		inventoryRoutes.GET("", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			filter, err := stockFilter(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			stocks, err := inventory.Stocks(c, filter)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			totals := map[string]gin.H{}
+
+			for _, stock := range stocks {
+				total, ok := totals[stock.Mineral]
+				if !ok {
+					zero := models.NewDecimal(0, 0)
+					total = gin.H{"weight": zero, "fine_weight": zero, "cost": zero}
+				}
+				total["weight"] = total["weight"].(models.Decimal).Add(stock.Weight)
+				total["fine_weight"] = total["fine_weight"].(models.Decimal).Add(stock.FineWeight)
+				total["cost"] = total["cost"].(models.Decimal).Add(stock.Cost)
+				totals[stock.Mineral] = total
+			}
+
+			c.JSON(http.StatusOK, gin.H{"stock": stocks, "totals": totals, "currency": models.DefaultCurrency})
+		})
+
+		// stock movements, latest first, narrowed by ?reference_id= as well
+		inventoryRoutes.GET("/movements", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			filter, err := stockFilter(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if kind := c.Query("kind"); kind != "" {
+				filter["kind"] = kind
+			}
+
+			if referenceParam := c.Query("reference_id"); referenceParam != "" {
+				referenceID, err := primitive.ObjectIDFromHex(referenceParam)
+
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reference ID"})
+					return
+				}
+
+				filter["reference_id"] = referenceID
+			}
+
+			cursor, err := database.Database.Collection(models.Collection.StockMovement).Find(c, filter,
+				options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			movements := []models.StockMovement{}
+
+			if err := cursor.All(c, &movements); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"movements": movements})
+		})
+	}
+}
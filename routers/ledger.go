@@ -0,0 +1,209 @@
+package routers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func SetupLedgerRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	ledgerRoutes := router.Group("/ledger")
+	ledgerRoutes.Use(jwtAuthService.AuthMiddleware(), middlewares.IsAdminValidate())
+	{
+
+		// journal entries, optionally for a single account
+		ledgerRoutes.GET("", func(c *gin.Context) {
+			account := c.Query("account")
+			filterParam := c.Query("filter")
+			pageParam := c.Query("page")
+			pageSize := 100
+			page := 1
+
+			if pageParam != "" {
+				page, _ = strconv.Atoi(pageParam)
+			}
+
+			if page < 1 {
+				page = 1
+			}
+
+			filter := bson.M{}
+
+			if account != "" {
+				filter["lines.account"] = account
+			}
+
+			if branchParam := c.Query("branch_id"); branchParam != "" {
+				branchID, err := primitive.ObjectIDFromHex(branchParam)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch_id"})
+					return
+				}
+				filter["branch_id"] = branchID
+			}
+
+			now := time.Now()
+			switch filterParam {
+			case "today":
+				filter["created_at"] = bson.M{"$gte": time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+			case "week":
+				filter["created_at"] = bson.M{"$gte": now.AddDate(0, 0, -int(now.Weekday()))}
+			case "month":
+				filter["created_at"] = bson.M{"$gte": time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)}
+			case "year":
+				filter["created_at"] = bson.M{"$gte": time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)}
+			}
+
+			findOptions := options.Find().
+				SetSort(bson.D{{Key: "created_at", Value: -1}}).
+				SetSkip(int64((page - 1) * pageSize)).
+				SetLimit(int64(pageSize))
+
+			cursor, err := database.Database.Collection(models.Collection.Ledger).Find(c, filter, findOptions)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			entries := []models.JournalEntry{}
+
+			if err = cursor.All(c, &entries); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			response := gin.H{
+				"entries": entries,
+				"page":    page,
+			}
+
+			// the account's totals, one per currency it holds
+			if account != "" {
+				totals, err := ledger.AccountTotals(c, filter)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				accountTotals := []ledger.AccountTotal{}
+				for _, total := range totals {
+					if total.Account == account {
+						accountTotals = append(accountTotals, total)
+					}
+				}
+				response["account"] = accountTotals
+			}
+
+			c.JSON(http.StatusOK, response)
+		})
+
+		// debit, credit and balance of every account in each currency
+		ledgerRoutes.GET("/accounts", func(c *gin.Context) {
+			filter := bson.M{}
+
+			if branchParam := c.Query("branch_id"); branchParam != "" {
+				branchID, err := primitive.ObjectIDFromHex(branchParam)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch_id"})
+					return
+				}
+				filter["branch_id"] = branchID
+			}
+
+			totals, err := ledger.AccountTotals(c, filter)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			// accounts without lines are listed at zero in the default currency
+			posted := map[string]bool{}
+			for _, total := range totals {
+				posted[total.Account] = true
+			}
+			for _, account := range ledger.Accounts {
+				if !posted[account] {
+					zero := models.NewDecimal(0, 0)
+					totals = append(totals, ledger.AccountTotal{Account: account, Currency: models.DefaultCurrency, Debit: zero, Credit: zero, Balance: zero})
+				}
+			}
+
+			c.JSON(http.StatusOK, gin.H{"accounts": totals})
+		})
+
+		// recompute the cached balances of each branch and floats of each associate
+		ledgerRoutes.POST("/rebuild", func(c *gin.Context) {
+			var branches []models.Branch
+
+			cursor, err := database.FindDocuments(models.Collection.Branch, bson.D{})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err = cursor.All(c, &branches); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			balances := make([]models.Balance, 0, len(branches))
+
+			for _, branch := range branches {
+				branchBalances, err := ledger.Rebuild(c, branch.ID)
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				balances = append(balances, branchBalances...)
+			}
+
+			var associates []models.Associate
+
+			cursor, err = database.FindDocuments(models.Collection.Associate, bson.D{})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err = cursor.All(c, &associates); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			floats := make([]models.Float, 0, len(associates))
+
+			for _, associate := range associates {
+				associateFloats, err := ledger.RebuildFloat(c, associate.ID)
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				floats = append(floats, associateFloats...)
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"balances": balances,
+				"floats":   floats,
+				"message":  "Balances and floats rebuilt from the ledger",
+			})
+		})
+	}
+}
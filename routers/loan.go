@@ -1,25 +1,30 @@
 package routers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/DreamSoft-LLC/oryan/catalogue"
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/lending"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/pricing"
 	"github.com/DreamSoft-LLC/oryan/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func newLoanStruct(associate primitive.ObjectID) *models.Loan {
 	return &models.Loan{
 		AssociateID: associate,
 		ID:          primitive.NewObjectID(),
+		Currency:    models.DefaultCurrency,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -45,9 +50,27 @@ func SetupLoanRoutes(router *gin.Engine) {
 
 			offset := (page - 1) * pageSize
 
+			associate, err := authAssociate(context)
+
+			if err != nil {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(context, associate)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
 			// Create a filter for the MongoDB query
 			var filter = bson.D{}
 
+			if !branchID.IsZero() {
+				filter = append(filter, bson.E{Key: "branch_id", Value: branchID})
+			}
+
 			// Add the associate_id filter for non-admin users
 			// if authentication.Role != "admin" {
 			// 	filter = append(filter, bson.E{Key: "associate_id", Value: authentication.ID})
@@ -114,37 +137,26 @@ func SetupLoanRoutes(router *gin.Engine) {
 			//})
 		})
 
-		loanRoutes.POST("", func(context *gin.Context) {
-			auth, _ := context.Get("auth")
-			authentication := auth.(*utils.Authentication)
-
-			balanceDocumentID := os.Getenv("BALANCE_ID")
-
-			if balanceDocumentID == "" {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Balance document ID not set"})
-				return
-			}
-
-			idStr := authentication.ID
-			if strings.HasPrefix(idStr, "ObjectID(") && strings.HasSuffix(idStr, ")") {
-				idStr = idStr[9 : len(idStr)-1]
-			}
-			idStr = strings.Trim(idStr, "\"")
-
-			objectId, err := primitive.ObjectIDFromHex(idStr)
+		loanRoutes.POST("", middlewares.Idempotent(), func(context *gin.Context) {
+			associate, err := authAssociate(context)
 
 			if err != nil {
 				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
 				context.Abort()
 				return
 			}
-			newLoan := newLoanStruct(objectId)
+			newLoan := newLoanStruct(associate.ID)
 
 			if err := context.ShouldBindJSON(&newLoan); err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
+			stampRecord(associate, &newLoan.AssociateID, &newLoan.CreatedAt, &newLoan.Void)
+
+			// only a buy offset against the loan links a payoff to it
+			newLoan.TransactionID = nil
+
 			err = models.ValidateStruct.Struct(newLoan)
 
 			if err != nil {
@@ -153,65 +165,352 @@ func SetupLoanRoutes(router *gin.Engine) {
 				return
 			}
 
-			insertResult, err := database.InsertDocument(models.Collection.Loan, utils.ConvertStructPrimitive(newLoan))
-
-			newLoan.ID = insertResult.InsertedID.(primitive.ObjectID)
+			newLoan.BranchID, err = resolveBranch(associate, newLoan.BranchID)
 
 			if err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				context.Abort()
 				return
 			}
 
-			fixedID, _ := primitive.ObjectIDFromHex(balanceDocumentID)
-			var balanceData models.Balance
-			balanceDocument := database.FindDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}})
+			// only a credit carries a due date and terms
+			if newLoan.Type == lending.TypePayoff {
+				newLoan.DueAt, newLoan.Terms = nil, nil
+			}
 
-			err = balanceDocument.Decode(&balanceData)
+			// Open or repay the loan account, insert the loan and move the
+			// balance together
+			var insertResult *mongo.InsertOneResult
+			var account *models.LoanAccount
 
-			if err != nil {
+			err = database.WithTransaction(context, func(sessionContext mongo.SessionContext) error {
+				var err error
+				if err := ledger.EnsureOpen(sessionContext, newLoan.CreatedAt); err != nil {
+					return err
+				}
+
+				if newLoan.Type == lending.TypeCredit {
+					account, err = lending.Open(sessionContext, newLoan)
+				} else {
+					account, err = lending.Repay(sessionContext, newLoan)
+				}
+				if err != nil {
+					return err
+				}
+
+				insertResult, err = database.InsertDocumentContext(sessionContext, models.Collection.Loan, utils.ConvertStructPrimitive(newLoan))
+				if err != nil {
+					return err
+				}
+
+				return lending.Post(sessionContext, newLoan, account)
+			})
+
+			if errors.Is(err, lending.ErrAccountNotFound) || errors.Is(err, lending.ErrNoOpenAccount) || errors.Is(err, lending.ErrAccountMismatch) ||
+				errors.Is(err, lending.ErrAccountClosed) || errors.Is(err, lending.ErrOverpayment) || errors.Is(err, lending.ErrLoanToValue) ||
+				errors.Is(err, pricing.ErrNoBoardRate) || errors.Is(err, pricing.ErrUnknownScale) || errors.Is(err, catalogue.ErrUnknownMineral) ||
+				errors.Is(err, catalogue.ErrUnknownGrade) || errors.Is(err, catalogue.ErrGradeRequired) {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, ledger.ErrInsufficientFloat) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient float available, ask an admin to issue funds"})
+				return
+			}
+
+			// anything else, a write conflict among them, is not kept against
+			// the idempotency key so a retry goes through
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				context.Abort()
 				return
 			}
 
-			balanceAmountSpent, _ := strconv.ParseFloat(balanceData.Spent, 64)
-			loanAmount, _ := strconv.ParseFloat(newLoan.Amount, 64)
+			context.JSON(http.StatusOK, gin.H{
+				"created": insertResult,
+				"loan":    newLoan,
+				"account": account,
+				"message": "Successfully added a new transaction",
+			})
+		})
+
+		// loan accounts, latest first, narrowed by ?customer_id= and ?status=
+		loanRoutes.GET("/accounts", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(c, associate)
 
-			if newLoan.Type == "credit" {
-				s := fmt.Sprintf("%f", balanceAmountSpent+loanAmount)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			filter := bson.M{}
 
-				_, err := database.UpdateDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "spent", Value: s}}}})
+			if status := c.Query("status"); status != "" {
+				filter, err = lending.StatusFilter(status, time.Now())
 
 				if err != nil {
-					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					context.Abort()
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 					return
 				}
-
 			}
 
-			balanceAmount, _ := strconv.ParseFloat(balanceData.Amount, 64)
-			if newLoan.Type == "payoff" {
-				s := fmt.Sprintf("%f", balanceAmount+loanAmount)
+			if !branchID.IsZero() {
+				filter["branch_id"] = branchID
+			}
 
-				_, err := database.UpdateDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "amount", Value: s}}}})
+			if customer := c.Query("customer_id"); customer != "" {
+				customerID, err := primitive.ObjectIDFromHex(customer)
 
 				if err != nil {
-					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					context.Abort()
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer_id"})
 					return
 				}
 
+				filter["customer_id"] = customerID
 			}
 
-			context.JSON(http.StatusOK, gin.H{
-				"created": insertResult,
-				"loan":    newLoan,
-				"message": "Successfully added a new transaction",
+			accounts, err := lending.Accounts(c, filter)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+		})
+
+		// a loan account with the loan records that moved money on it
+		loanRoutes.GET("/accounts/:id", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			account, err := lending.AccountFor(c, id)
+
+			// another branch's loan is not the caller's to see
+			if errors.Is(err, lending.ErrAccountNotFound) || (err == nil && !inBranch(associate, account.BranchID)) {
+				c.JSON(http.StatusNotFound, gin.H{"error": lending.ErrAccountNotFound.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			cursor, err := database.FindManyDocuments(models.Collection.Loan, bson.M{"account_id": account.ID}, bson.D{{Key: "created_at", Value: 1}})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			loans := []models.Loan{}
+
+			if err := cursor.All(c, &loans); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"account": account, "loans": loans})
+		})
+
+		// the amortisation of a loan on terms: each instalment with the
+		// principal owed before it and how far it has been paid
+		loanRoutes.GET("/accounts/:id/schedule", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			account, err := lending.AccountFor(c, id)
+
+			// another branch's loan is not the caller's to see
+			if errors.Is(err, lending.ErrAccountNotFound) || (err == nil && !inBranch(associate, account.BranchID)) {
+				c.JSON(http.StatusNotFound, gin.H{"error": lending.ErrAccountNotFound.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"account_id":     account.ID,
+				"terms":          account.Terms,
+				"principal":      account.Principal,
+				"fees":           account.Fees,
+				"interest":       account.Interest,
+				"principal_paid": account.PrincipalPaid,
+				"fees_paid":      account.FeesPaid,
+				"interest_paid":  account.InterestPaid,
+				"outstanding":    account.Outstanding,
+				"next_due_at":    account.NextDueAt,
+				"schedule":       lending.Amortisation(account, time.Now()),
+			})
+		})
+
+		// give up on what is left of a loan; it is posted to expenses
+		loanRoutes.POST("/accounts/:id/write-off", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			var body struct {
+				Reason string `json:"reason" validate:"required"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			var account *models.LoanAccount
+
+			err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				var err error
+				account, err = lending.WriteOff(sessionContext, id, associate.ID, body.Reason)
+				return err
+			})
+
+			if errors.Is(err, lending.ErrAccountNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, lending.ErrAccountClosed) || errors.Is(err, lending.ErrCollateralHeld) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"account": account, "message": "Loan written off"})
+		})
+
+		// take the collateral of an overdue loan into stock and close it
+		loanRoutes.POST("/accounts/:id/forfeit", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			var body struct {
+				Reason string `json:"reason" validate:"required"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			var account *models.LoanAccount
+
+			err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				var err error
+				account, err = lending.Forfeit(sessionContext, id, associate.ID, body.Reason)
+				return err
 			})
+
+			if errors.Is(err, lending.ErrAccountNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, lending.ErrAccountClosed) || errors.Is(err, lending.ErrAccountChanged) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, lending.ErrNoCollateral) || errors.Is(err, lending.ErrNotInDefault) || errors.Is(err, pricing.ErrUnknownScale) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"account": account, "message": "Collateral forfeited into stock"})
 		})
 
+		// void a loan and reverse what it posted
+		loanRoutes.POST("/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.Loan, "created_at"))
 	}
 
 }
@@ -0,0 +1,332 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/catalogue"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errNoMeltInput   = errors.New("a melt needs transaction_ids or lots to melt")
+	errSerialTaken   = errors.New("a bar with this serial already exists")
+	errInvalidMelt   = errors.New("fineness must be at most 1000")
+	errNotMeltable   = errors.New("only minerals graded by fineness can be melted")
+	errBarNotForSale = errors.New("no bar in stock with this serial")
+)
+
+func newMeltBatchStruct(associate primitive.ObjectID) *models.MeltBatch {
+	return &models.MeltBatch{
+		ID:          primitive.NewObjectID(),
+		AssociateID: associate,
+		Inputs:      []models.MeltInput{},
+		CreatedAt:   time.Now(),
+	}
+}
+
+// findBar finds the live melt batch that made the bar with the given serial
+func findBar(c *gin.Context, serial string) (*models.MeltBatch, error) {
+	var batch models.MeltBatch
+
+	err := database.Database.Collection(models.Collection.MeltBatch).FindOne(c,
+		database.ExcludeVoided(bson.M{"serial": strings.ToUpper(strings.TrimSpace(serial))}),
+	).Decode(&batch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errBarNotForSale
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &batch, nil
+}
+
+func SetupMeltRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	meltRoutes := router.Group("/melts")
+	meltRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// melt batches, latest first, narrowed by ?serial=
+		meltRoutes.GET("", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			filter := bson.M{}
+
+			if !branchID.IsZero() {
+				filter["branch_id"] = branchID
+			}
+
+			// a voided bar is still found by the serial it gave up
+			if serial := c.Query("serial"); serial != "" {
+				serial = strings.ToUpper(serial)
+				filter["$or"] = bson.A{bson.M{"serial": serial}, bson.M{"voided_serial": serial}}
+			}
+
+			cursor, err := database.FindManyDocuments(models.Collection.MeltBatch, filter, bson.D{{Key: "created_at", Value: -1}})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			batches := []models.MeltBatch{}
+
+			if err := cursor.All(c, &batches); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"melts": batches})
+		})
+
+		// a melt batch traced back to the buys and customers that went into it
+		meltRoutes.GET("/:id", func(c *gin.Context) {
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			var batch models.MeltBatch
+
+			if err := database.Database.Collection(models.Collection.MeltBatch).FindOne(c, bson.M{"_id": id}).Decode(&batch); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Melt batch not found"})
+				return
+			}
+
+			transactionIDs := bson.A{}
+			customerIDs := bson.A{}
+
+			for _, input := range batch.Inputs {
+				if !input.TransactionID.IsZero() {
+					transactionIDs = append(transactionIDs, input.TransactionID)
+					customerIDs = append(customerIDs, input.CustomerID)
+				}
+			}
+
+			transactions := []models.Transaction{}
+			customers := []models.Customer{}
+
+			if len(transactionIDs) > 0 {
+				cursor, err := database.FindManyDocuments(models.Collection.Transaction, bson.M{"_id": bson.M{"$in": transactionIDs}}, bson.D{{Key: "created_at", Value: 1}})
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := cursor.All(c, &transactions); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				cursor, err = database.FindManyDocuments(models.Collection.Customer, bson.M{"_id": bson.M{"$in": customerIDs}}, bson.D{{Key: "name", Value: 1}})
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := cursor.All(c, &customers); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			c.JSON(http.StatusOK, gin.H{"melt": batch, "transactions": transactions, "customers": customers})
+		})
+
+		// melt buys, by transaction_ids, and loose stock, by lots of grade and
+		// weight, into a bar; the bar is stocked at the cost of what went in
+		meltRoutes.POST("", middlewares.Idempotent(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			batch := newMeltBatchStruct(associate.ID)
+
+			var body struct {
+				BranchID       primitive.ObjectID   `json:"branch_id"`
+				Mineral        string               `json:"mineral" validate:"required"`
+				TransactionIDs []primitive.ObjectID `json:"transaction_ids"`
+				Lots           []struct {
+					Grade  string         `json:"grade" validate:"required"`
+					Weight models.Decimal `json:"weight" validate:"required,positive"`
+				} `json:"lots" validate:"dive"`
+				Serial    string         `json:"serial" validate:"required"`
+				BarWeight models.Decimal `json:"bar_weight" validate:"required,positive"`
+				Fineness  models.Decimal `json:"fineness" validate:"required,positive"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if len(body.TransactionIDs) == 0 && len(body.Lots) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errNoMeltInput.Error()})
+				return
+			}
+
+			if body.Fineness.Cmp(models.NewDecimal(1000, 0)) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidMelt.Error()})
+				return
+			}
+
+			mineral, err := catalogue.MineralFor(c, body.Mineral)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if mineral.Grading != catalogue.GradingFineness {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errNotMeltable.Error()})
+				return
+			}
+
+			batch.BranchID, err = resolveBranch(associate, body.BranchID)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			batch.Mineral = mineral.Code
+			batch.Serial = strings.ToUpper(strings.TrimSpace(body.Serial))
+			batch.BarWeight = body.BarWeight
+			batch.Fineness = body.Fineness
+
+			batch.BarFineWeight, err = body.BarWeight.Mul(body.Fineness).Div(models.NewDecimal(1000, 0), 4)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if _, err := findBar(c, batch.Serial); err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errSerialTaken.Error()})
+				return
+			}
+
+			source := inventory.Source{
+				AssociateID:   associate.ID,
+				BranchID:      batch.BranchID,
+				ReferenceID:   batch.ID,
+				ReferenceType: models.Collection.MeltBatch,
+				OccurredAt:    batch.CreatedAt,
+			}
+
+			err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				batch.Inputs = []models.MeltInput{}
+
+				melt := func(input models.MeltInput) error {
+					movement, err := inventory.Issue(sessionContext, inventory.KindMelt, batch.Mineral, input.Grade, input.Weight, source)
+					if err != nil {
+						return err
+					}
+					input.FineWeight = movement.FineWeight.Neg()
+					input.Cost = movement.Cost.Neg()
+					batch.Inputs = append(batch.Inputs, input)
+					return nil
+				}
+
+				for _, id := range body.TransactionIDs {
+					transaction, weight, err := takeBuy(sessionContext, id, "melt_id", batch.ID, batch.BranchID, batch.Mineral, "")
+					if err != nil {
+						return err
+					}
+
+					if err := melt(models.MeltInput{TransactionID: transaction.ID, CustomerID: transaction.CustomerID, Grade: transaction.Grade, Weight: weight}); err != nil {
+						return err
+					}
+				}
+
+				for _, lot := range body.Lots {
+					if err := melt(models.MeltInput{Grade: strings.ToLower(lot.Grade), Weight: lot.Weight}); err != nil {
+						return err
+					}
+				}
+
+				zero := models.NewDecimal(0, 0)
+				batch.InputWeight, batch.InputFineWeight, batch.InputCost = zero, zero, zero
+				for _, input := range batch.Inputs {
+					batch.InputWeight = batch.InputWeight.Add(input.Weight)
+					batch.InputFineWeight = batch.InputFineWeight.Add(input.FineWeight)
+					batch.InputCost = batch.InputCost.Add(input.Cost)
+				}
+
+				batch.MeltLoss = batch.InputWeight.Sub(batch.BarWeight)
+				batch.FineLoss = batch.InputFineWeight.Sub(batch.BarFineWeight)
+				batch.LossPercent = zero
+				if batch.InputFineWeight.Sign() > 0 {
+					percent, err := batch.FineLoss.Mul(models.NewDecimal(100, 0)).Div(batch.InputFineWeight, 2)
+					if err != nil {
+						return err
+					}
+					batch.LossPercent = percent
+				}
+
+				if _, err := inventory.Receive(sessionContext, inventory.KindMelt, batch.Mineral, inventory.BarGrade(batch.Serial), batch.BarWeight, batch.BarFineWeight, batch.InputCost, models.DefaultCurrency, source); err != nil {
+					return err
+				}
+
+				// the unique index settles two melts racing for one serial
+				_, err := database.InsertDocumentContext(sessionContext, models.Collection.MeltBatch, utils.ConvertStructPrimitive(batch))
+				if mongo.IsDuplicateKeyError(err) {
+					return errSerialTaken
+				}
+				return err
+			})
+
+			if errors.Is(err, errNotFound) || errors.Is(err, errBuyTaken) || errors.Is(err, errUnknownScaleOf) ||
+				errors.Is(err, inventory.ErrInsufficientStock) || errors.Is(err, errSerialTaken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"melt": batch, "message": "Melt recorded, bar " + batch.Serial + " is in stock"})
+		})
+
+		// void a melt; its inputs go back to stock and the bar leaves it
+		meltRoutes.POST("/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.MeltBatch, "created_at"))
+	}
+}
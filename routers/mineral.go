@@ -0,0 +1,343 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/catalogue"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newMineralStruct() *models.Mineral {
+	return &models.Mineral{
+		ID:        primitive.NewObjectID(),
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+func newGradeStruct(mineral string) *models.Grade {
+	return &models.Grade{
+		ID:        primitive.NewObjectID(),
+		Mineral:   mineral,
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// catalogueEntry is a mineral together with its grades
+type catalogueEntry struct {
+	models.Mineral
+	Grades []models.Grade `json:"grades"`
+}
+
+func SetupMineralRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	mineralRoutes := router.Group("/minerals")
+	mineralRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// the catalogue, only active minerals and grades unless ?all=true
+		mineralRoutes.GET("", func(c *gin.Context) {
+			activeOnly := c.Query("all") != "true"
+
+			minerals, err := catalogue.Minerals(c, activeOnly)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			entries := make([]catalogueEntry, 0, len(minerals))
+
+			for _, mineral := range minerals {
+				grades, err := catalogue.Grades(c, mineral.Code, activeOnly)
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				entries = append(entries, catalogueEntry{Mineral: mineral, Grades: grades})
+			}
+
+			c.JSON(http.StatusOK, gin.H{"minerals": entries})
+		})
+
+		// add a mineral
+		mineralRoutes.POST("", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			body := newMineralStruct()
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.Code = strings.ToLower(body.Code)
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := database.FindDocument(models.Collection.Mineral, bson.D{{Key: "code", Value: body.Code}}).Err(); err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A mineral with this code already exists"})
+				return
+			}
+
+			insertResult, err := database.InsertDocument(models.Collection.Mineral, utils.ConvertStructPrimitive(body))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"created": insertResult,
+				"mineral": body,
+				"message": "Successfully added a new mineral",
+			})
+		})
+
+		// change a mineral's name, unit or active flag; its code and grading
+		// stay as records rely on them
+		mineralRoutes.PUT("/:code", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			var mineral models.Mineral
+
+			if err := database.FindDocument(models.Collection.Mineral, bson.D{{Key: "code", Value: c.Param("code")}}).Decode(&mineral); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mineral not found"})
+				return
+			}
+
+			var body struct {
+				Name   string `json:"name" validate:"required"`
+				Unit   string `json:"unit" validate:"required"`
+				Active bool   `json:"active"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			mineral.Name, mineral.Unit, mineral.Active = body.Name, body.Unit, body.Active
+			mineral.UpdatedAt = time.Now()
+
+			_, err := database.UpdateDocument(models.Collection.Mineral,
+				bson.D{{Key: "_id", Value: mineral.ID}},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "name", Value: mineral.Name},
+					{Key: "unit", Value: mineral.Unit},
+					{Key: "active", Value: mineral.Active},
+					{Key: "updated_at", Value: mineral.UpdatedAt},
+				}}},
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"mineral": mineral, "message": "Mineral updated"})
+		})
+
+		// add a purity grade to a mineral graded by fineness; karat or
+		// fineness is enough, the other is worked out
+		mineralRoutes.POST("/:code/grades", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			var mineral models.Mineral
+
+			if err := database.FindDocument(models.Collection.Mineral, bson.D{{Key: "code", Value: c.Param("code")}}).Decode(&mineral); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mineral not found"})
+				return
+			}
+
+			if mineral.Grading != catalogue.GradingFineness {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Only minerals graded by fineness have grades"})
+				return
+			}
+
+			body := newGradeStruct(mineral.Code)
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.Mineral = mineral.Code
+			body.Code = strings.ToLower(body.Code)
+
+			if err := catalogue.PreparePurity(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := database.FindDocument(models.Collection.Grade, bson.D{{Key: "mineral", Value: body.Mineral}, {Key: "code", Value: body.Code}}).Err(); err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A grade with this code already exists"})
+				return
+			}
+
+			insertResult, err := database.InsertDocument(models.Collection.Grade, utils.ConvertStructPrimitive(body))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"created": insertResult,
+				"grade":   body,
+				"message": "Successfully added a new grade",
+			})
+		})
+
+		// change a grade's name, purity or active flag
+		mineralRoutes.PUT("/:code/grades/:grade", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			var grade models.Grade
+
+			if err := database.FindDocument(models.Collection.Grade, bson.D{{Key: "mineral", Value: c.Param("code")}, {Key: "code", Value: c.Param("grade")}}).Decode(&grade); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Grade not found"})
+				return
+			}
+
+			var body struct {
+				Name     string         `json:"name" validate:"required"`
+				Karat    models.Decimal `json:"karat"`
+				Fineness models.Decimal `json:"fineness"`
+				Active   bool           `json:"active"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			grade.Name, grade.Karat, grade.Fineness, grade.Active = body.Name, body.Karat, body.Fineness, body.Active
+			grade.UpdatedAt = time.Now()
+
+			if err := catalogue.PreparePurity(&grade); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(grade); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			_, err := database.UpdateDocument(models.Collection.Grade,
+				bson.D{{Key: "_id", Value: grade.ID}},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "name", Value: grade.Name},
+					{Key: "karat", Value: grade.Karat},
+					{Key: "fineness", Value: grade.Fineness},
+					{Key: "active", Value: grade.Active},
+					{Key: "updated_at", Value: grade.UpdatedAt},
+				}}},
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"grade": grade, "message": "Grade updated"})
+		})
+
+		// the density table density tests of the mineral are read off
+		mineralRoutes.GET("/:code/densities", func(c *gin.Context) {
+			table, err := catalogue.DensityTable(c, c.Param("code"))
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"densities": table})
+		})
+
+		// replace the density table of a mineral graded by fineness
+		mineralRoutes.PUT("/:code/densities", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			var mineral models.Mineral
+
+			if err := database.FindDocument(models.Collection.Mineral, bson.D{{Key: "code", Value: c.Param("code")}}).Decode(&mineral); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mineral not found"})
+				return
+			}
+
+			if mineral.Grading != catalogue.GradingFineness {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Only minerals graded by fineness have a density table"})
+				return
+			}
+
+			var body struct {
+				Densities []models.DensityPoint `json:"densities" validate:"required,min=2,dive"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			points := make([]interface{}, 0, len(body.Densities))
+			for i := range body.Densities {
+				point := &body.Densities[i]
+				point.ID = primitive.NewObjectID()
+				point.Mineral = mineral.Code
+				point.CreatedAt = time.Now()
+
+				if point.Fineness.Cmp(models.NewDecimal(1000, 0)) > 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": catalogue.ErrInvalidPurity.Error()})
+					return
+				}
+
+				points = append(points, utils.ConvertStructPrimitive(point))
+			}
+
+			err := database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				collection := database.Database.Collection(models.Collection.Density)
+				if _, err := collection.DeleteMany(sessionContext, bson.M{"mineral": mineral.Code}); err != nil {
+					return err
+				}
+				_, err := collection.InsertMany(sessionContext, points)
+				return err
+			})
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			table, err := catalogue.DensityTable(c, mineral.Code)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"densities": table, "message": "Density table replaced"})
+		})
+	}
+}
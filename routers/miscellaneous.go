@@ -1,25 +1,28 @@
 package routers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/DreamSoft-LLC/oryan/models"
 	"github.com/DreamSoft-LLC/oryan/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func newMiscellaneousStruct(associate primitive.ObjectID) *models.Miscellaneous {
 	return &models.Miscellaneous{
 		ID:          primitive.NewObjectID(),
 		AssociateID: associate,
+		Currency:    models.DefaultCurrency,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -48,9 +51,27 @@ func SetupMiscellaneousRoutes(router *gin.Engine) {
 
 			offset := (page - 1) * pageSize
 
+			associate, err := authAssociate(context)
+
+			if err != nil {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(context, associate)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
 			// Create a filter for the MongoDB query
 			var filter = bson.D{}
 
+			if !branchID.IsZero() {
+				filter = append(filter, bson.E{Key: "branch_id", Value: branchID})
+			}
+
 			// Add the associate_id filter for non-admin users
 			// if authentication.Role != "admin" {
 			// 	filter = append(filter, bson.E{Key: "associate_id", Value: authentication.ID})
@@ -102,24 +123,9 @@ func SetupMiscellaneousRoutes(router *gin.Engine) {
 
 		})
 
-		miscellaneousRoutes.POST("/", func(context *gin.Context) {
-
-			balanceDocumentID := os.Getenv("BALANCE_ID")
-			if balanceDocumentID == "" {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Balance document ID not set"})
-				return
-			}
-
-			auth, _ := context.Get("auth")
-			authentication := auth.(*utils.Authentication)
+		miscellaneousRoutes.POST("/", middlewares.Idempotent(), func(context *gin.Context) {
 
-			idStr := authentication.ID
-			if strings.HasPrefix(idStr, "ObjectID(") && strings.HasSuffix(idStr, ")") {
-				idStr = idStr[9 : len(idStr)-1]
-			}
-			idStr = strings.Trim(idStr, "\"")
-
-			objectId, err := primitive.ObjectIDFromHex(idStr)
+			associate, err := authAssociate(context)
 
 			if err != nil {
 				context.JSON(http.StatusUnauthorized, gin.H{
@@ -131,7 +137,7 @@ func SetupMiscellaneousRoutes(router *gin.Engine) {
 			}
 
 			// Initialize the struct
-			body := newMiscellaneousStruct(objectId)
+			body := newMiscellaneousStruct(associate.ID)
 
 			// Bind incoming JSON to struct
 			if err := context.ShouldBindJSON(&body); err != nil {
@@ -139,66 +145,70 @@ func SetupMiscellaneousRoutes(router *gin.Engine) {
 				return
 			}
 
+			stampRecord(associate, &body.AssociateID, &body.CreatedAt, &body.Void)
+
 			// Validate the struct
 			if err := models.ValidateStruct.Struct(body); err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			// Insert document into Miscellaneous collection
-			insertResult, err := database.InsertDocument(models.Collection.Miscellaneous, utils.ConvertStructPrimitive(body))
+			body.BranchID, err = resolveBranch(associate, body.BranchID)
 			if err != nil {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				context.Abort()
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			// Retrieve the balance document by ID (ensure `balance_docucment_id` is initialized correctly)
-			var balanceDoc models.Balance
-			docResult := database.FindDocumentById(models.Collection.Balance, balanceDocumentID)
-			if err := docResult.Decode(&balanceDoc); err != nil {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				context.Abort()
-				return
-			}
+			// Insert the record and post the expense to the ledger together
+			var entry *models.JournalEntry
 
-			// Parse the amounts as floats for calculation
-			newAmount, err := strconv.ParseFloat(body.Amount, 64)
-			if err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount format"})
+			err = database.WithTransaction(context, func(sessionContext mongo.SessionContext) error {
+				if err := ledger.EnsureOpen(sessionContext, body.CreatedAt); err != nil {
+					return err
+				}
+
+				if _, err := database.InsertDocumentContext(sessionContext, models.Collection.Miscellaneous, utils.ConvertStructPrimitive(body)); err != nil {
+					return err
+				}
+
+				var err error
+				entry, err = ledger.Record(sessionContext, ledger.EventMiscellaneous, body.Amount, ledger.Source{
+					AssociateID:   body.AssociateID,
+					BranchID:      body.BranchID,
+					ReferenceID:   body.ID,
+					ReferenceType: models.Collection.Miscellaneous,
+					OccurredAt:    body.CreatedAt,
+					Currency:      body.Currency,
+					Description:   body.Description,
+				})
+				return err
+			})
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			balanceAmountSpent, err := strconv.ParseFloat(balanceDoc.Spent, 64)
-			if err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid balance amount format"})
+			if errors.Is(err, ledger.ErrInsufficientFunds) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": "Insuficient balance available contact admin"})
 				return
 			}
 
-			// Update the balance document by subtracting the new amount
-			s := fmt.Sprintf("%f", balanceAmountSpent+newAmount)
-
-			updateResult, err := database.UpdateDocument(
-				models.Collection.Balance,
-				bson.D{{Key: "_id", Value: balanceDocumentID}},
-				bson.D{{Key: "$set", Value: bson.D{{Key: "amount", Value: s}}}},
-			)
 			if err != nil {
 				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				context.Abort()
 				return
 			}
 
-			// Assign the inserted document ID to the body struct
-			body.ID = insertResult.InsertedID.(primitive.ObjectID)
-
 			// Return the response with the updated balance
 			context.JSON(http.StatusOK, gin.H{
 				"miscellaneous": body,
-				"updateResult":  updateResult,
+				"entry":         entry,
 			})
 
 		})
 
+		// void an expense and reverse what it posted
+		miscellaneousRoutes.POST("/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.Miscellaneous, "created_date"))
 	}
 }
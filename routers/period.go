@@ -0,0 +1,176 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func SetupPeriodRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	periodRoutes := router.Group("/periods")
+	periodRoutes.Use(jwtAuthService.AuthMiddleware(), middlewares.IsAdminValidate())
+	{
+
+		// closed and reopened periods, latest first
+		periodRoutes.GET("", func(c *gin.Context) {
+			cursor, err := database.Database.Collection(models.Collection.Period).Find(c, bson.D{},
+				options.Find().SetSort(bson.D{{Key: "start", Value: -1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			periods := []models.Period{}
+
+			if err = cursor.All(c, &periods); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"periods": periods})
+		})
+
+		// lock a day or month against new and changed records
+		periodRoutes.POST("/close", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			var body struct {
+				Kind   string `json:"kind" validate:"required,oneof=day month"`
+				Date   string `json:"date" validate:"required"` // YYYY-MM-DD, or YYYY-MM for a month
+				Reason string `json:"reason"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			date, err := time.Parse(dayLayout, body.Date)
+			if err != nil && body.Kind == ledger.PeriodMonth {
+				date, err = time.Parse("2006-01", body.Date)
+			}
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+				return
+			}
+
+			period, err := ledger.ClosePeriod(c, body.Kind, date, associate.ID, body.Reason)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"period":  period,
+				"message": "Period closed",
+			})
+		})
+
+		// unlock a closed period; the reason is kept in the audit trail
+		periodRoutes.POST("/:id/reopen", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			periodID, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period ID"})
+				return
+			}
+
+			var body struct {
+				Reason string `json:"reason" validate:"required"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			period, err := ledger.ReopenPeriod(c, periodID, associate.ID, body.Reason)
+
+			if errors.Is(err, ledger.ErrPeriodNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			if errors.Is(err, ledger.ErrPeriodOpen) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"period":  period,
+				"message": "Period reopened",
+			})
+		})
+
+		// who closed and reopened a period, and why
+		periodRoutes.GET("/:id/audit", func(c *gin.Context) {
+			periodID, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period ID"})
+				return
+			}
+
+			cursor, err := database.Database.Collection(models.Collection.PeriodAudit).Find(c,
+				bson.D{{Key: "period_id", Value: periodID}},
+				options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			audit := []models.PeriodAudit{}
+
+			if err = cursor.All(c, &audit); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"audit": audit})
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/DreamSoft-LLC/oryan/profit"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func SetupProfitRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	profitRoutes := router.Group("/profit")
+	profitRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// gross profit of the sales made between ?from= and ?to=, the last 30
+		// days by default, per sale, day, associate and scale; costs are
+		// matched by ?method=average (default) or ?method=fifo
+		profitRoutes.GET("/realised", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			filter, err := stockFilter(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			method := c.DefaultQuery("method", profit.MethodAverage)
+
+			from, to, err := balanceRange(c)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			report, err := profit.RealisedProfit(c, method, filter, from, to)
+
+			if errors.Is(err, profit.ErrUnknownMethod) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "profit": report})
+		})
+
+		// profit on the stock held were it sold at the current board rate
+		profitRoutes.GET("/unrealised", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			filter, err := stockFilter(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			method := c.DefaultQuery("method", profit.MethodAverage)
+
+			report, err := profit.UnrealisedProfit(c, method, filter)
+
+			if errors.Is(err, profit.ErrUnknownMethod) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"profit": report})
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/catalogue"
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/pricing"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newBoardRateStruct(associate primitive.ObjectID) *models.BoardRate {
+	return &models.BoardRate{
+		ID:        primitive.NewObjectID(),
+		Kind:      "buy",
+		Currency:  models.DefaultCurrency,
+		CreatedBy: associate,
+		CreatedAt: time.Now(),
+	}
+}
+
+// rateFilter narrows board rates by ?kind=, ?mineral=, ?grade=, ?scale= and ?currency=
+func rateFilter(c *gin.Context) bson.M {
+	filter := bson.M{}
+
+	for _, key := range []string{"kind", "mineral", "grade", "scale"} {
+		if value := c.Query(key); value != "" {
+			filter[key] = strings.ToLower(value)
+		}
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		filter["currency"] = strings.ToUpper(currency)
+	}
+
+	return filter
+}
+
+func SetupRateRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	rateRoutes := router.Group("/rates")
+	rateRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// the rate in force now for every mineral, grade and scale
+		rateRoutes.GET("/current", func(c *gin.Context) {
+			rates, err := pricing.CurrentRates(c, time.Now())
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			filter := rateFilter(c)
+			current := []models.BoardRate{}
+
+			for _, rate := range rates {
+				fields := map[string]string{"kind": rate.Kind, "mineral": rate.Mineral, "grade": rate.Grade, "scale": rate.Scale, "currency": rate.Currency}
+				matches := true
+				for key, value := range filter {
+					if fields[key] != value {
+						matches = false
+					}
+				}
+				if matches {
+					current = append(current, rate)
+				}
+			}
+
+			c.JSON(http.StatusOK, gin.H{"rates": current})
+		})
+
+		// every rate posted to the board, latest first
+		rateRoutes.GET("/history", func(c *gin.Context) {
+			cursor, err := database.Database.Collection(models.Collection.BoardRate).Find(c, rateFilter(c),
+				options.Find().SetSort(bson.D{{Key: "effective_from", Value: -1}, {Key: "_id", Value: -1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			rates := []models.BoardRate{}
+
+			if err = cursor.All(c, &rates); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"rates": rates})
+		})
+
+		// post a rate to the board, taking effect at effective_from, now when empty
+		rateRoutes.POST("", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			body := newBoardRateStruct(associate.ID)
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.CreatedBy = associate.ID
+			body.Currency = strings.ToUpper(body.Currency)
+			body.Scale = strings.ToLower(body.Scale)
+
+			if body.EffectiveFrom.IsZero() {
+				body.EffectiveFrom = body.CreatedAt
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			mineral, err := catalogue.MineralFor(c, body.Mineral)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.Mineral = mineral.Code
+
+			if body.Grade != "" {
+				grade, err := catalogue.GradeFor(c, mineral.Code, body.Grade)
+
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				body.Grade = grade.Code
+			}
+
+			if _, err := pricing.ScaleFor(c, body.Scale); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if _, err := database.InsertDocument(models.Collection.BoardRate, utils.ConvertStructPrimitive(body)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"rate": body, "message": "Rate posted to the board"})
+		})
+	}
+}
@@ -3,6 +3,7 @@ package routers
 import (
 	"time"
 
+	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -12,8 +13,8 @@ func SetupRouter() *gin.Engine {
 	router.Use(cors.New(cors.Config{
 		AllowAllOrigins:  true, // Allows all origins
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middlewares.IdempotencyHeader},
+		ExposeHeaders:    []string{"Content-Length", middlewares.ReplayedHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -27,5 +28,18 @@ func SetupRouter() *gin.Engine {
 	SetupBalancesRoutes(router)
 	SetupMiscellaneousRoutes(router)
 	SetupStashRoutes(router)
+	SetupLedgerRoutes(router)
+	SetupBranchRoutes(router)
+	SetupFloatRoutes(router)
+	SetupTillRoutes(router)
+	SetupPeriodRoutes(router)
+	SetupExchangeRateRoutes(router)
+	SetupScaleRoutes(router)
+	SetupMineralRoutes(router)
+	SetupRateRoutes(router)
+	SetupInventoryRoutes(router)
+	SetupProfitRoutes(router)
+	SetupAssayRoutes(router)
+	SetupMeltRoutes(router)
 	return router
 }
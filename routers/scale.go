@@ -0,0 +1,125 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/pricing"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newScaleStruct() *models.Scale {
+	return &models.Scale{
+		ID:        primitive.NewObjectID(),
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+func SetupScaleRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	scaleRoutes := router.Group("/scales")
+	scaleRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// scales, only the active ones unless ?all=true
+		scaleRoutes.GET("", func(c *gin.Context) {
+			scales, err := pricing.Scales(c, c.Query("all") != "true")
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"scales": scales})
+		})
+
+		// add a scale
+		scaleRoutes.POST("", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			body := newScaleStruct()
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			body.Code = strings.ToLower(body.Code)
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := database.FindDocument(models.Collection.Scale, bson.D{{Key: "code", Value: body.Code}}).Err(); err == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A scale with this code already exists"})
+				return
+			}
+
+			insertResult, err := database.InsertDocument(models.Collection.Scale, utils.ConvertStructPrimitive(body))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"created": insertResult,
+				"scale":   body,
+				"message": "Successfully added a new scale",
+			})
+		})
+
+		// change a scale's name, unit, factor, margin or active flag; the
+		// code stays as transactions refer to it, and they keep the factor
+		// they were weighed with
+		scaleRoutes.PUT("/:code", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			var scale models.Scale
+
+			if err := database.FindDocument(models.Collection.Scale, bson.D{{Key: "code", Value: c.Param("code")}}).Decode(&scale); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Scale not found"})
+				return
+			}
+
+			id, code, createdAt := scale.ID, scale.Code, scale.CreatedAt
+
+			if err := c.ShouldBindJSON(&scale); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			scale.ID, scale.Code, scale.CreatedAt = id, code, createdAt
+			scale.UpdatedAt = time.Now()
+
+			if err := models.ValidateStruct.Struct(scale); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			_, err := database.UpdateDocument(models.Collection.Scale,
+				bson.D{{Key: "_id", Value: scale.ID}},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "name", Value: scale.Name},
+					{Key: "unit", Value: scale.Unit},
+					{Key: "conversion_factor", Value: scale.Factor},
+					{Key: "margin", Value: scale.Margin},
+					{Key: "active", Value: scale.Active},
+					{Key: "updated_at", Value: scale.UpdatedAt},
+				}}},
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"scale": scale, "message": "Scale updated"})
+		})
+	}
+}
@@ -1,27 +1,149 @@
 package routers
 
 import (
-	"fmt"
+	"errors"
+	"io"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/DreamSoft-LLC/oryan/catalogue"
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/DreamSoft-LLC/oryan/models"
 	"github.com/DreamSoft-LLC/oryan/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Stash statuses, in the order a stash moves through them
+const (
+	stashOpen     = "open"     // buys are still being added to the lot
+	stashSealed   = "sealed"   // the lot is weighed and packed
+	stashShipped  = "shipped"  // the lot has left the branch
+	stashReceived = "received" // the buyer has weighed the lot
+	stashSettled  = "settled"  // the buyer has paid for the lot
+)
+
+// the weight each step records
+var stashStepWeights = map[string]string{
+	stashSealed:   "sealed_weight",
+	stashShipped:  "shipped_weight",
+	stashReceived: "received_weight",
+}
+
+var (
+	errStashNotOpen = errors.New("transactions can only be added to an open stash")
+	errStashStatus  = errors.New("the stash is not at the step this move starts from")
 )
 
 func newStashStruct(associate primitive.ObjectID) *models.Stash {
 	return &models.Stash{
-		AssociateID: associate,
-		ID:          primitive.NewObjectID(),
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		AssociateID:  associate,
+		ID:           primitive.NewObjectID(),
+		Currency:     models.DefaultCurrency,
+		Status:       stashOpen,
+		Transactions: []primitive.ObjectID{},
+		Steps:        []models.StashStep{},
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// findStash loads a stash by the :id parameter
+func findStash(c *gin.Context) (*models.Stash, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, errNotFound
+	}
+
+	var stash models.Stash
+	if err := database.Database.Collection(models.Collection.Stash).FindOne(c, bson.M{"_id": id}).Decode(&stash); err != nil {
+		return nil, errNotFound
+	}
+
+	return &stash, nil
+}
+
+// stashStep moves a stash from one status to the next, recording the weight
+// of the lot at that step; the weight of the step before is kept when none
+// is given, or when there is no body at all.
+func stashStep(from string, to string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		associate, err := authAssociate(c)
+
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+			return
+		}
+
+		stash, err := findStash(c)
+
+		// another branch's stash is not the caller's to move
+		if err == nil && !inBranch(associate, stash.BranchID) {
+			err = errNotFound
+		}
+
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		var body struct {
+			Weight models.Decimal `json:"weight" validate:"nonnegative"`
+			Note   string         `json:"note"`
+		}
+
+		if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := models.ValidateStruct.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !body.Weight.IsSet() {
+			switch from {
+			case stashOpen:
+				body.Weight = stash.Weight
+			case stashSealed:
+				body.Weight = stash.SealedWeight
+			case stashShipped:
+				body.Weight = stash.ShippedWeight
+			}
+		}
+
+		step := models.StashStep{Status: to, Weight: body.Weight, AssociateID: associate.ID, Note: body.Note, At: time.Now()}
+
+		result, err := database.UpdateDocument(models.Collection.Stash,
+			bson.D{{Key: "_id", Value: stash.ID}, {Key: "status", Value: from}, {Key: "void", Value: bson.M{"$exists": false}}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: to},
+					{Key: stashStepWeights[to], Value: body.Weight},
+					{Key: "updated_date", Value: step.At},
+				}},
+				{Key: "$push", Value: bson.D{{Key: "steps", Value: step}}},
+			},
+		)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": errStashStatus.Error(), "status": stash.Status})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": stash.ID, "status": to, "step": step, "message": "Stash " + to})
 	}
 }
 
@@ -42,8 +164,30 @@ func SetupStashRoutes(router *gin.Engine) {
 				page, _ = strconv.Atoi(pageParam)
 			}
 
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
 			var filter = bson.D{}
 
+			if !branchID.IsZero() {
+				filter = append(filter, bson.E{Key: "branch_id", Value: branchID})
+			}
+
+			if status := c.Query("status"); status != "" {
+				filter = append(filter, bson.E{Key: "status", Value: status})
+			}
+
 			offset := (page - 1) * pageSize
 
 			cursor, err := database.FindDocumentsQuery(models.Collection.Stash, filter, pageSize, offset)
@@ -74,27 +218,9 @@ func SetupStashRoutes(router *gin.Engine) {
 
 		})
 
-		stashRoutes.POST("", func(c *gin.Context) {
-
-			auth, _ := c.Get("auth")
-			authentication := auth.(*utils.Authentication)
-
-			balanceDocumentID := os.Getenv("BALANCE_ID")
-
-			if balanceDocumentID == "" {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Balance document ID not set"})
-				return
-			}
-
-			fixedID, _ := primitive.ObjectIDFromHex(balanceDocumentID)
-
-			idStr := authentication.ID
-			if strings.HasPrefix(idStr, "ObjectID(") && strings.HasSuffix(idStr, ")") {
-				idStr = idStr[9 : len(idStr)-1]
-			}
-			idStr = strings.Trim(idStr, "\"")
+		stashRoutes.POST("", middlewares.Idempotent(), func(c *gin.Context) {
 
-			objectId, err := primitive.ObjectIDFromHex(idStr)
+			associate, err := authAssociate(c)
 
 			if err != nil {
 				c.JSON(http.StatusUnauthorized, gin.H{
@@ -104,7 +230,7 @@ func SetupStashRoutes(router *gin.Engine) {
 				c.Abort()
 				return
 			}
-			newShash := newStashStruct(objectId)
+			newShash := newStashStruct(associate.ID)
 
 			if err := c.ShouldBindJSON(&newShash); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -112,6 +238,24 @@ func SetupStashRoutes(router *gin.Engine) {
 				return
 			}
 
+			stampRecord(associate, &newShash.AssociateID, &newShash.CreatedAt, &newShash.Void)
+
+			// every stash starts open
+			newShash.Status = stashOpen
+			newShash.Transactions = []primitive.ObjectID{}
+			newShash.SealedWeight, newShash.ShippedWeight, newShash.ReceivedWeight, newShash.Proceeds = models.Decimal{}, models.Decimal{}, models.Decimal{}, models.Decimal{}
+
+			// weight given up front is loose stock put straight in the lot,
+			// and an amount is money paid out for the stash
+			if !newShash.Weight.IsSet() {
+				newShash.Weight = models.NewDecimal(0, 0)
+			}
+			if !newShash.Amount.IsSet() {
+				newShash.Amount = models.NewDecimal(0, 0)
+			}
+
+			newShash.Steps = []models.StashStep{{Status: stashOpen, Weight: newShash.Weight, AssociateID: associate.ID, At: newShash.CreatedAt}}
+
 			err = models.ValidateStruct.Struct(newShash)
 
 			if err != nil {
@@ -121,10 +265,8 @@ func SetupStashRoutes(router *gin.Engine) {
 				return
 			}
 
-			var balanceData models.Balance
-			balanceDocument := database.FindDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}})
-
-			err = balanceDocument.Decode(&balanceData)
+			// a stash may hold mixed grades, so its grade is optional
+			newShash.Mineral, newShash.Grade, err = catalogue.Describe(c, newShash.Mineral, newShash.Grade, nil, true)
 
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -132,29 +274,90 @@ func SetupStashRoutes(router *gin.Engine) {
 				return
 			}
 
-			insertResult, err := database.InsertDocument(models.Collection.Stash, utils.ConvertStructPrimitive(newShash))
+			newShash.BranchID, err = resolveBranch(associate, newShash.BranchID)
 
 			if err != nil {
-				//TODO: return an error response of the required fields left empty
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				c.Abort()
 				return
 			}
 
-			newShash.ID = insertResult.InsertedID.(primitive.ObjectID)
-
-			// Update the balance
-
-			balanceTotalalAmountBalance, _ := strconv.ParseFloat(balanceData.Amount, 64)
+			// Insert the stash and post it to the ledger together
+			err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				if err := ledger.EnsureOpen(sessionContext, newShash.CreatedAt); err != nil {
+					return err
+				}
+
+				if _, err := database.InsertDocumentContext(sessionContext, models.Collection.Stash, utils.ConvertStructPrimitive(newShash)); err != nil {
+					return err
+				}
+
+				if newShash.Amount.Sign() > 0 {
+					_, err := ledger.Record(sessionContext, ledger.EventStash, newShash.Amount, ledger.Source{
+						AssociateID:   newShash.AssociateID,
+						BranchID:      newShash.BranchID,
+						ReferenceID:   newShash.ID,
+						ReferenceType: models.Collection.Stash,
+						OccurredAt:    newShash.CreatedAt,
+						Currency:      newShash.Currency,
+					})
+					if err != nil {
+						return err
+					}
+				}
+
+				if newShash.Weight.Sign() == 0 {
+					return nil
+				}
+
+				// the stash dispatches what it weighs out of the branch's stock
+				stockSource := inventory.Source{
+					AssociateID:   newShash.AssociateID,
+					BranchID:      newShash.BranchID,
+					ReferenceID:   newShash.ID,
+					ReferenceType: models.Collection.Stash,
+					OccurredAt:    newShash.CreatedAt,
+				}
+
+				var movements []models.StockMovement
+				if newShash.Grade == "" {
+					mixed, err := inventory.IssueMixed(sessionContext, inventory.KindStash, newShash.Mineral, newShash.Weight, stockSource)
+					if err != nil {
+						return err
+					}
+					movements = mixed
+				} else {
+					movement, err := inventory.Issue(sessionContext, inventory.KindStash, newShash.Mineral, newShash.Grade, newShash.Weight, stockSource)
+					if err != nil {
+						return err
+					}
+					movements = []models.StockMovement{*movement}
+				}
+
+				// what the stock cost moves from Inventory to the stash
+				return inventory.PostCost(sessionContext, ledger.EventStashDispatch, stockSource, movements...)
+			})
 
-			transactionAmount, _ := strconv.ParseFloat(newShash.Amount, 64)
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-			newBalance := fmt.Sprintf("%f", balanceTotalalAmountBalance-transactionAmount)
+			if errors.Is(err, inventory.ErrInsufficientStock) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-			_, err = database.UpdateDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "amount", Value: newBalance}}}})
+			if errors.Is(err, ledger.ErrInsufficientFunds) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Insuficient balance available contact admin"})
+				c.Abort()
+				return
+			}
 
+			// anything else, a write conflict among them, is not kept against
+			// the idempotency key so a retry goes through
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				c.Abort()
 				return
 			}
@@ -167,6 +370,248 @@ func SetupStashRoutes(router *gin.Engine) {
 			return
 
 		})
+
+		// a stash with the buys in its lot
+		stashRoutes.GET("/:id", func(c *gin.Context) {
+			stash, err := findStash(c)
+
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			transactions := []models.Transaction{}
+
+			if len(stash.Transactions) > 0 {
+				cursor, err := database.FindManyDocuments(models.Collection.Transaction, bson.M{"_id": bson.M{"$in": stash.Transactions}}, bson.D{{Key: "created_at", Value: 1}})
+
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				if err := cursor.All(c, &transactions); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			c.JSON(http.StatusOK, gin.H{"stash": stash, "transactions": transactions})
+		})
+
+		// add buys to the lot of an open stash; their weight leaves the
+		// branch's stock for the stash
+		stashRoutes.POST("/:id/transactions", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			stash, err := findStash(c)
+
+			// another branch's stash is not the caller's to add to
+			if err == nil && !inBranch(associate, stash.BranchID) {
+				err = errNotFound
+			}
+
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			var body struct {
+				TransactionIDs []primitive.ObjectID `json:"transaction_ids" validate:"required,min=1"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			added := models.NewDecimal(0, 0)
+
+			err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				added = models.NewDecimal(0, 0)
+
+				for _, id := range body.TransactionIDs {
+					transaction, weight, err := takeBuy(sessionContext, id, "stash_id", stash.ID, stash.BranchID, stash.Mineral, stash.Grade)
+					if err != nil {
+						return err
+					}
+
+					stockSource := inventory.Source{
+						AssociateID:   associate.ID,
+						BranchID:      stash.BranchID,
+						ReferenceID:   stash.ID,
+						ReferenceType: models.Collection.Stash,
+					}
+
+					movement, err := inventory.Issue(sessionContext, inventory.KindStash, transaction.Mineral, transaction.Grade, weight, stockSource)
+					if err != nil {
+						return err
+					}
+
+					if err := inventory.PostCost(sessionContext, ledger.EventStashDispatch, stockSource, *movement); err != nil {
+						return err
+					}
+
+					added = added.Add(weight)
+				}
+
+				result, err := database.Database.Collection(models.Collection.Stash).UpdateOne(sessionContext,
+					bson.M{"_id": stash.ID, "status": stashOpen, "void": bson.M{"$exists": false}},
+					bson.M{
+						"$inc":  bson.M{"weight": added},
+						"$push": bson.M{"transaction_ids": bson.M{"$each": body.TransactionIDs}},
+						"$set":  bson.M{"updated_date": time.Now()},
+					},
+				)
+				if err != nil {
+					return err
+				}
+				if result.MatchedCount == 0 {
+					return errStashNotOpen
+				}
+
+				return nil
+			})
+
+			if errors.Is(err, errNotFound) || errors.Is(err, errBuyTaken) || errors.Is(err, errUnknownScaleOf) ||
+				errors.Is(err, errStashNotOpen) || errors.Is(err, inventory.ErrInsufficientStock) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"id":      stash.ID,
+				"added":   body.TransactionIDs,
+				"weight":  stash.Weight.Add(added),
+				"message": "Transactions added to the stash",
+			})
+		})
+
+		// weigh and pack the lot, send it off, and record what the buyer weighed
+		stashRoutes.POST("/:id/seal", stashStep(stashOpen, stashSealed))
+		stashRoutes.POST("/:id/ship", stashStep(stashSealed, stashShipped))
+		stashRoutes.POST("/:id/receive", stashStep(stashShipped, stashReceived))
+
+		// record what the buyer paid for a received lot; the proceeds credit
+		// the branch's balance
+		stashRoutes.POST("/:id/settle", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			stash, err := findStash(c)
+
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			var body struct {
+				Proceeds models.Decimal `json:"proceeds" validate:"required,positive"`
+				Note     string         `json:"note"`
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			step := models.StashStep{Status: stashSettled, Weight: stash.ReceivedWeight, AssociateID: associate.ID, Note: body.Note, At: time.Now()}
+
+			var entry *models.JournalEntry
+
+			err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+				result, err := database.Database.Collection(models.Collection.Stash).UpdateOne(sessionContext,
+					bson.D{{Key: "_id", Value: stash.ID}, {Key: "status", Value: stashReceived}, {Key: "void", Value: bson.M{"$exists": false}}},
+					bson.D{
+						{Key: "$set", Value: bson.D{
+							{Key: "status", Value: stashSettled},
+							{Key: "proceeds", Value: body.Proceeds},
+							{Key: "updated_date", Value: step.At},
+						}},
+						{Key: "$push", Value: bson.D{{Key: "steps", Value: step}}},
+					},
+				)
+				if err != nil {
+					return err
+				}
+				if result.MatchedCount == 0 {
+					return errStashStatus
+				}
+
+				entry, err = ledger.Record(sessionContext, ledger.EventStashSettle, body.Proceeds, ledger.Source{
+					AssociateID:   associate.ID,
+					BranchID:      stash.BranchID,
+					ReferenceID:   stash.ID,
+					ReferenceType: models.Collection.Stash,
+					Description:   body.Note,
+					OccurredAt:    step.At,
+					Currency:      stash.Currency,
+				})
+				if err != nil {
+					return err
+				}
+
+				// the stash is sold, so what its stock cost is now a cost of sales
+				cost, err := inventory.CostOf(sessionContext, stash.ID)
+				if err != nil || cost.Sign() <= 0 {
+					return err
+				}
+
+				_, err = ledger.Record(sessionContext, ledger.EventStashCost, cost, ledger.Source{
+					AssociateID:   associate.ID,
+					BranchID:      stash.BranchID,
+					ReferenceID:   stash.ID,
+					ReferenceType: models.Collection.Stash,
+					OccurredAt:    step.At,
+					Currency:      models.DefaultCurrency,
+				})
+				return err
+			})
+
+			if errors.Is(err, errStashStatus) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": stash.Status})
+				return
+			}
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"id": stash.ID, "status": stashSettled, "step": step, "entry": entry, "message": "Stash settled"})
+		})
+
+		// void a stash and reverse what it posted
+		stashRoutes.POST("/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.Stash, "created_date"))
 	}
 
 }
@@ -0,0 +1,351 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const dayLayout = "2006-01-02"
+
+// parseDay reads a YYYY-MM-DD query value as the start of that day, today
+// when empty.
+func parseDay(value string) (time.Time, error) {
+	if value == "" {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse(dayLayout, value)
+}
+
+// expectedCash works out what an associate should be holding in a currency
+// at the end of day from the previous close and the records since it, so
+// days left unclosed roll into the next close.
+func expectedCash(ctx context.Context, associateID primitive.ObjectID, currency string, day time.Time) (*models.TillClose, error) {
+	zero := models.NewDecimal(0, 0)
+	tillClose := &models.TillClose{
+		AssociateID: associateID,
+		Date:        day,
+		Currency:    currency,
+		Opening:     zero,
+	}
+
+	during := bson.M{"$lt": day.AddDate(0, 0, 1)}
+
+	var previous models.TillClose
+	err := database.Database.Collection(models.Collection.TillClose).FindOne(ctx,
+		bson.M{"associate_id": associateID, "currency": currency, "date": bson.M{"$lt": day}},
+		options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}}),
+	).Decode(&previous)
+	if err == nil {
+		tillClose.Opening = previous.Counted
+		during["$gte"] = previous.Date.AddDate(0, 0, 1)
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	var funds, issued, returned models.Decimal
+
+	sums := []struct {
+		collection string
+		filter     bson.M
+		result     *models.Decimal
+	}{
+		{models.Collection.Fund, bson.M{"associate_id": associateID, "currency": currency, "created_at": during}, &funds},
+		{models.Collection.FloatTransfer, bson.M{"associate_id": associateID, "currency": currency, "kind": "issue", "created_at": during}, &issued},
+		{models.Collection.FloatTransfer, bson.M{"associate_id": associateID, "currency": currency, "kind": "return", "created_at": during}, &returned},
+		{models.Collection.Transaction, bson.M{"associate_id": associateID, "currency": currency, "kind": "buy", "created_at": during}, &tillClose.Buys},
+		{models.Collection.Transaction, bson.M{"associate_id": associateID, "currency": currency, "kind": "sell", "created_at": during}, &tillClose.Sells},
+		{models.Collection.Loan, bson.M{"associate_id": associateID, "currency": currency, "type": "credit", "created_at": during}, &tillClose.LoansOut},
+		{models.Collection.Loan, bson.M{"associate_id": associateID, "currency": currency, "type": "payoff", "created_at": during}, &tillClose.LoansIn},
+		{models.Collection.Miscellaneous, bson.M{"associate_id": associateID, "currency": currency, "created_date": during}, &tillClose.Miscellaneous},
+	}
+
+	for _, sum := range sums {
+		if err := database.SumDocuments(sum.collection, sum.filter, "amount", sum.result); err != nil {
+			return nil, err
+		}
+	}
+
+	tillClose.Funds = funds.Add(issued).Sub(returned)
+	tillClose.Expected = tillClose.Opening.
+		Add(tillClose.Funds).
+		Sub(tillClose.Buys).
+		Add(tillClose.Sells).
+		Sub(tillClose.LoansOut).
+		Add(tillClose.LoansIn).
+		Sub(tillClose.Miscellaneous)
+
+	return tillClose, nil
+}
+
+// tillStatus names the sign of a variance
+func tillStatus(variance models.Decimal) string {
+	switch variance.Sign() {
+	case 1:
+		return "over"
+	case -1:
+		return "short"
+	}
+	return "balanced"
+}
+
+func SetupTillRoutes(router *gin.Engine) {
+	jwtAuthService := utils.GetJWTAuthService()
+	tillRoutes := router.Group("/till")
+	tillRoutes.Use(jwtAuthService.AuthMiddleware())
+	{
+
+		// close the caller's till for a day with the cash they counted
+		tillRoutes.POST("/close", func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			var body struct {
+				Date          string                `json:"date"` // YYYY-MM-DD, today when empty
+				Counted       models.Decimal        `json:"counted"`
+				Denominations []models.Denomination `json:"denominations" validate:"dive"`
+				Note          string                `json:"note"`
+				Currency      string                `json:"currency" validate:"omitempty,iso4217"` // default currency when empty
+			}
+
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			day, err := parseDay(body.Date)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+				return
+			}
+
+			if today, _ := parseDay(""); day.After(today) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A till cannot be closed for a day still to come"})
+				return
+			}
+
+			// a count by denomination adds up to the counted cash
+			if len(body.Denominations) > 0 {
+				total := models.NewDecimal(0, 0)
+				for _, denomination := range body.Denominations {
+					total = total.Add(denomination.Value.Mul(models.NewDecimal(denomination.Count, 0)))
+				}
+
+				if body.Counted.IsSet() && body.Counted.Cmp(total) != 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Counted cash does not match the denominations, they add up to " + total.String()})
+					return
+				}
+				body.Counted = total
+			}
+
+			if !body.Counted.IsSet() || body.Counted.Sign() < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "counted cash or denominations are required"})
+				return
+			}
+
+			if body.Currency == "" {
+				body.Currency = models.DefaultCurrency
+			}
+
+			alreadyClosed := "The " + body.Currency + " till is already closed for " + day.Format(dayLayout)
+
+			if err := database.FindDocument(models.Collection.TillClose, bson.D{{Key: "associate_id", Value: associate.ID}, {Key: "currency", Value: body.Currency}, {Key: "date", Value: day}}).Err(); err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": alreadyClosed})
+				return
+			}
+
+			// a later close already counted the records up to it
+			if err := database.FindDocument(models.Collection.TillClose, bson.D{{Key: "associate_id", Value: associate.ID}, {Key: "currency", Value: body.Currency}, {Key: "date", Value: bson.M{"$gt": day}}}).Err(); err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "The " + body.Currency + " till is already closed for a later day"})
+				return
+			}
+
+			tillClose, err := expectedCash(c, associate.ID, body.Currency, day)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			tillClose.ID = primitive.NewObjectID()
+			tillClose.BranchID = associate.BranchID
+			tillClose.Counted = body.Counted
+			tillClose.Variance = body.Counted.Sub(tillClose.Expected)
+			tillClose.Denominations = body.Denominations
+			tillClose.Note = body.Note
+			tillClose.CreatedAt = time.Now()
+
+			if tillClose.Denominations == nil {
+				tillClose.Denominations = []models.Denomination{}
+			}
+
+			// the unique index turns away a close racing this one
+			_, err = database.InsertDocument(models.Collection.TillClose, utils.ConvertStructPrimitive(tillClose))
+
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": alreadyClosed})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"close":   tillClose,
+				"status":  tillStatus(tillClose.Variance),
+				"message": "Till closed for " + day.Format(dayLayout),
+			})
+		})
+
+		// over/short per associate per day
+		tillRoutes.GET("/reconciliation", middlewares.IsAdminValidate(), func(c *gin.Context) {
+			associate, err := authAssociate(c)
+
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(c, associate)
+
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			filter := bson.M{}
+
+			if !branchID.IsZero() {
+				filter["branch_id"] = branchID
+			}
+
+			if associateParam := c.Query("associate_id"); associateParam != "" {
+				associateID, err := primitive.ObjectIDFromHex(associateParam)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid associate_id"})
+					return
+				}
+				filter["associate_id"] = associateID
+			}
+
+			dates := bson.M{}
+			if from := c.Query("from"); from != "" {
+				day, err := time.Parse(dayLayout, from)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected YYYY-MM-DD"})
+					return
+				}
+				dates["$gte"] = day
+			}
+			if to := c.Query("to"); to != "" {
+				day, err := time.Parse(dayLayout, to)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected YYYY-MM-DD"})
+					return
+				}
+				dates["$lte"] = day
+			}
+			if len(dates) > 0 {
+				filter["date"] = dates
+			}
+
+			cursor, err := database.Database.Collection(models.Collection.TillClose).Find(c, filter,
+				options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "associate_id", Value: 1}}),
+			)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			var closes []models.TillClose
+
+			if err = cursor.All(c, &closes); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			type row struct {
+				models.TillClose
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			}
+
+			type associateTotal struct {
+				AssociateID primitive.ObjectID `json:"associate_id"`
+				Name        string             `json:"name"`
+				Days        int                `json:"days"`
+				Over        models.Decimal     `json:"over"`
+				Short       models.Decimal     `json:"short"`
+				Net         models.Decimal     `json:"net"`
+			}
+
+			names := map[primitive.ObjectID]string{}
+			totals := map[primitive.ObjectID]*associateTotal{}
+			order := []primitive.ObjectID{}
+			rows := make([]row, 0, len(closes))
+
+			for _, tillClose := range closes {
+				name, ok := names[tillClose.AssociateID]
+				if !ok {
+					var a models.Associate
+					_ = database.FindDocument(models.Collection.Associate, bson.D{{Key: "_id", Value: tillClose.AssociateID}}).Decode(&a)
+					name = a.Name
+					names[tillClose.AssociateID] = name
+				}
+
+				total, ok := totals[tillClose.AssociateID]
+				if !ok {
+					zero := models.NewDecimal(0, 0)
+					total = &associateTotal{AssociateID: tillClose.AssociateID, Name: name, Over: zero, Short: zero, Net: zero}
+					totals[tillClose.AssociateID] = total
+					order = append(order, tillClose.AssociateID)
+				}
+
+				total.Days++
+				total.Net = total.Net.Add(tillClose.Variance)
+				if tillClose.Variance.Sign() > 0 {
+					total.Over = total.Over.Add(tillClose.Variance)
+				} else {
+					total.Short = total.Short.Add(tillClose.Variance.Neg())
+				}
+
+				rows = append(rows, row{TillClose: tillClose, Name: name, Status: tillStatus(tillClose.Variance)})
+			}
+
+			associates := make([]*associateTotal, 0, len(order))
+			for _, id := range order {
+				associates = append(associates, totals[id])
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"closes":     rows,
+				"associates": associates,
+			})
+		})
+	}
+}
@@ -1,20 +1,28 @@
 package routers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"strings"
 
+	"github.com/DreamSoft-LLC/oryan/catalogue"
 	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/lending"
+	"github.com/DreamSoft-LLC/oryan/middlewares"
 	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/DreamSoft-LLC/oryan/pricing"
 	"github.com/DreamSoft-LLC/oryan/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/net/context"
 
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,11 +30,79 @@ func newTransactionStruct(associate primitive.ObjectID) *models.Transaction {
 	return &models.Transaction{
 		AssociateID: associate,
 		ID:          primitive.NewObjectID(),
+		Currency:    models.DefaultCurrency,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 }
 
+// testPurity works out a density test of weight and, when no grade was
+// given, grades the item by the fineness measured.
+func testPurity(ctx *gin.Context, mineral string, grade string, purity *models.Purity, weight models.Decimal) (string, error) {
+	if err := catalogue.MeasurePurity(ctx, mineral, purity, weight); err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(grade) != "" {
+		return grade, nil
+	}
+
+	found, err := catalogue.GradeForFineness(ctx, strings.ToLower(strings.TrimSpace(mineral)), purity.Fineness)
+	if err != nil {
+		return "", err
+	}
+
+	return found.Code, nil
+}
+
+// rateGrade is the grade to look a board rate up by; a density-tested item
+// is priced by its fine weight, at the rate for every grade.
+func rateGrade(grade string, purity *models.Purity) string {
+	if purity != nil {
+		return ""
+	}
+	return grade
+}
+
+// pricedWeight is the weight a transaction is priced by, its fine weight
+// when it was density tested.
+func pricedWeight(weight models.Decimal, purity *models.Purity) models.Decimal {
+	if purity != nil {
+		return purity.FineWeight
+	}
+	return weight
+}
+
+// scaleMargins reads the profit margin of every scale, by code.
+func scaleMargins(ctx *gin.Context) (map[string]models.Decimal, error) {
+	scales, err := pricing.Scales(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	margins := make(map[string]models.Decimal, len(scales))
+	for _, scale := range scales {
+		margins[scale.Code] = scale.Margin
+	}
+
+	return margins, nil
+}
+
+// scaleProfitResponse lists the profit of each scale both under
+// "<code>_profit" and in a "scale_profit" map.
+func scaleProfitResponse(scaleProfit map[string]models.Decimal) gin.H {
+	response := gin.H{}
+	rounded := make(map[string]models.Decimal, len(scaleProfit))
+
+	for code, profit := range scaleProfit {
+		rounded[code] = profit.Round(2)
+		response[code+"_profit"] = rounded[code]
+	}
+	response["scale_profit"] = rounded
+
+	return response
+}
+
 func SetupTransactionRoutes(router *gin.Engine) {
 	jwtAuthService := utils.GetJWTAuthService()
 	transactionRoutes := router.Group("/transactions")
@@ -51,9 +127,27 @@ func SetupTransactionRoutes(router *gin.Engine) {
 
 			offset := (page - 1) * pageSize
 
+			associate, err := authAssociate(context)
+
+			if err != nil {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			branchID, err := listBranch(context, associate)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
 			// Create a filter for the MongoDB query
 			var filter = bson.D{}
 
+			if !branchID.IsZero() {
+				filter = append(filter, bson.E{Key: "branch_id", Value: branchID})
+			}
+
 			// Add the associate_id filter for non-admin users
 			// if authentication.Role != "admin" {
 			// 	// Assuming there is an associate_id field in your document
@@ -82,11 +176,14 @@ func SetupTransactionRoutes(router *gin.Engine) {
 			if searchTerm != "" {
 				searchFilter := bson.M{
 					"$or": []bson.M{
-						{"amount": bson.M{"$regex": searchTerm, "$options": "i"}}, // case-insensitive search
-						{"mineral": bson.M{"$regex": searchTerm, "$options": "i"}},
+						{"mineral": bson.M{"$regex": searchTerm, "$options": "i"}}, // case-insensitive search
 						{"scale": bson.M{"$regex": searchTerm, "$options": "i"}},
 					},
 				}
+				// amounts are decimals now, so match them exactly instead of by regex
+				if amount, err := models.ParseDecimal(searchTerm); err == nil {
+					searchFilter["$or"] = append(searchFilter["$or"].([]bson.M), bson.M{"amount": amount})
+				}
 				filter = append(filter, bson.E{Key: "$and", Value: bson.A{searchFilter}})
 			}
 
@@ -121,113 +218,514 @@ func SetupTransactionRoutes(router *gin.Engine) {
 		})
 
 		// Route to create new transaction
-		transactionRoutes.POST("/", func(context *gin.Context) {
+		transactionRoutes.POST("/", middlewares.Idempotent(), func(context *gin.Context) {
 			// TODO: create a new transaction
-			auth, _ := context.Get("auth")
-			authentication := auth.(*utils.Authentication)
+			associate, err := authAssociate(context)
 
-			balanceDocumentID := os.Getenv("BALANCE_ID")
+			if err != nil {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				context.Abort()
+				return
+			}
+
+			newtransaction := newTransactionStruct(associate.ID)
 
-			if balanceDocumentID == "" {
-				context.JSON(http.StatusInternalServerError, gin.H{"error": "Balance document ID not set"})
+			//get body in new transaction
+			if err := context.ShouldBindJSON(&newtransaction); err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			fixedID, _ := primitive.ObjectIDFromHex(balanceDocumentID)
+			stampRecord(associate, &newtransaction.AssociateID, &newtransaction.CreatedAt, &newtransaction.Void)
+
+			// what the server works out is not taken from the client
+			newtransaction.RateReview = nil
+			newtransaction.NetAmount, newtransaction.RepaymentIDs = models.Decimal{}, nil
 
-			idStr := authentication.ID
-			if strings.HasPrefix(idStr, "ObjectID(") && strings.HasSuffix(idStr, ")") {
-				idStr = idStr[9 : len(idStr)-1]
+			// a density-tested item is weighed in air
+			if newtransaction.Purity != nil && !newtransaction.Weight.IsSet() {
+				newtransaction.Weight = newtransaction.Purity.AirWeight
 			}
-			idStr = strings.Trim(idStr, "\"")
 
-			objectId, err := primitive.ObjectIDFromHex(idStr)
+			// a bar from a melt is sold whole and priced by its fine weight
+			var bar *models.MeltBatch
+			newtransaction.StashID, newtransaction.MeltID = nil, nil
+
+			if newtransaction.Bar != "" {
+				if newtransaction.Kind != "sell" {
+					context.JSON(http.StatusBadRequest, gin.H{"error": "Only a sell can carry a bar"})
+					return
+				}
+
+				bar, err = findBar(context, newtransaction.Bar)
+
+				if err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				// the bar is weighed in the mineral's unit, so it carries a
+				// factor of 1 whatever scale the sale names
+				newtransaction.Bar = bar.Serial
+				newtransaction.Mineral = bar.Mineral
+				newtransaction.Weight = bar.BarWeight
+				newtransaction.Purity, newtransaction.Quality = nil, nil
+			}
+
+			// the amount is worked out below and the rate may come from the board
+			err = models.ValidateStruct.StructExcept(newtransaction, "Amount", "Rate")
 
 			if err != nil {
-				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
-				context.Abort()
+				//TODO: return an error response of the required fields left empty
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			var balanceData models.Balance
-			balanceDocument := database.FindDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}})
+			if newtransaction.Purity != nil {
+				newtransaction.Grade, err = testPurity(context, newtransaction.Mineral, newtransaction.Grade, newtransaction.Purity, newtransaction.Weight)
+
+				if err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			boardGrade, weightPriced := rateGrade(newtransaction.Grade, newtransaction.Purity), pricedWeight(newtransaction.Weight, newtransaction.Purity)
 
-			err = balanceDocument.Decode(&balanceData)
+			if bar != nil {
+				newtransaction.Grade = inventory.BarGrade(bar.Serial)
+				boardGrade, weightPriced = "", bar.BarFineWeight
+			} else {
+				newtransaction.Mineral, newtransaction.Grade, err = catalogue.Describe(context, newtransaction.Mineral, newtransaction.Grade, newtransaction.Quality, false)
+
+				if err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				boardGrade = rateGrade(newtransaction.Grade, newtransaction.Purity)
+			}
+
+			newtransaction.Scale = strings.ToLower(newtransaction.Scale)
+
+			// the board rate is used when none is given; a rate given by hand is
+			// flagged for review when it strays beyond the board's band. The
+			// board is read as it stands now, even for a backdated record.
+			board, err := pricing.BoardRateFor(context, newtransaction.Kind, newtransaction.Mineral, boardGrade, newtransaction.Scale, newtransaction.Currency, time.Now())
+
+			switch {
+			case errors.Is(err, pricing.ErrNoBoardRate):
+				if !newtransaction.Rate.IsSet() {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error() + ", rate is required"})
+					return
+				}
+			case err != nil:
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			case !newtransaction.Rate.IsSet():
+				newtransaction.Rate = board.Rate
+			default:
+				newtransaction.RateReview, err = pricing.Review(board, newtransaction.Rate)
+				if err != nil {
+					context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			var quote *pricing.Quote
+			if bar != nil {
+				quote, err = pricing.PriceFine(context, newtransaction.Scale, newtransaction.Mineral, weightPriced, newtransaction.Rate)
+			} else {
+				quote, err = pricing.Price(context, newtransaction.Scale, newtransaction.Mineral, weightPriced, newtransaction.Rate)
+			}
 
 			if err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				context.Abort()
 				return
 			}
 
-			newtransaction := newTransactionStruct(objectId)
+			// a client amount is only a cross-check of the computed price
+			if newtransaction.Amount.IsSet() {
+				if err := quote.Check(newtransaction.Amount); err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "quote": quote})
+					return
+				}
+			}
+			newtransaction.Amount = quote.Amount
+			newtransaction.Scale, newtransaction.Factor = quote.Scale, quote.Factor
 
-			//get body in new transaction
-			if err := context.ShouldBindJSON(&newtransaction); err != nil {
+			if err := models.ValidateStruct.Struct(newtransaction); err != nil {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			err = models.ValidateStruct.Struct(newtransaction)
+			newtransaction.BranchID, err = resolveBranch(associate, newtransaction.BranchID)
 
-			balanceTotalalAmountBalance, _ := strconv.ParseFloat(balanceData.Amount, 64)
-			transactionAmount, _ := strconv.ParseFloat(newtransaction.Amount, 64)
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			event := ledger.EventBuy
+			if newtransaction.Kind == "sell" {
+				event = ledger.EventSell
+			}
+
+			// stock is kept in the mineral's unit; what a density test
+			// measured is the fine weight, else the grade's fineness gives it
+			stockWeight := newtransaction.Weight.Mul(newtransaction.Factor)
+			fineWeight := quote.PricedWeight
+
+			if newtransaction.Purity == nil && bar == nil {
+				fineWeight, err = inventory.FineWeightOf(context, newtransaction.Mineral, newtransaction.Grade, stockWeight)
+
+				if err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			// Insert the transaction and move the balance together; a buy the
+			// till cannot cover rolls both back. Part of a buy kept to repay
+			// the customer's loans is taken off them first and only the net
+			// is paid out.
+			var insertResult *mongo.InsertOneResult
+			var repayments []models.Loan
+			var accounts []*models.LoanAccount
+			offset, requested := lending.Offsets(newtransaction), newtransaction.LoanOffset
+
+			err = database.WithTransaction(context, func(sessionContext mongo.SessionContext) error {
+				var err error
+				if err := ledger.EnsureOpen(sessionContext, newtransaction.CreatedAt); err != nil {
+					return err
+				}
 
-			if transactionAmount > balanceTotalalAmountBalance {
-				context.JSON(http.StatusBadRequest, gin.H{"error": "Insuficient balance available contact admin"})
+				newtransaction.LoanOffset, newtransaction.NetAmount, newtransaction.RepaymentIDs = requested, newtransaction.Amount, nil
+				if offset {
+					repayments, accounts, err = lending.Offset(sessionContext, newtransaction)
+					if err != nil {
+						return err
+					}
+				}
+
+				insertResult, err = database.InsertDocumentContext(sessionContext, models.Collection.Transaction, utils.ConvertStructPrimitive(newtransaction))
+				if err != nil {
+					return err
+				}
+
+				if newtransaction.NetAmount.Sign() > 0 {
+					_, err = ledger.Record(sessionContext, event, newtransaction.NetAmount, ledger.Source{
+						AssociateID:   newtransaction.AssociateID,
+						BranchID:      newtransaction.BranchID,
+						ReferenceID:   newtransaction.ID,
+						ReferenceType: models.Collection.Transaction,
+						OccurredAt:    newtransaction.CreatedAt,
+						Currency:      newtransaction.Currency,
+					})
+					if err != nil {
+						return err
+					}
+				}
+
+				stockSource := inventory.Source{
+					AssociateID:   newtransaction.AssociateID,
+					BranchID:      newtransaction.BranchID,
+					ReferenceID:   newtransaction.ID,
+					ReferenceType: models.Collection.Transaction,
+					OccurredAt:    newtransaction.CreatedAt,
+				}
+
+				if newtransaction.Kind == "sell" {
+					movement, err := inventory.Issue(sessionContext, inventory.KindSell, newtransaction.Mineral, newtransaction.Grade, stockWeight, stockSource)
+					if err != nil {
+						return err
+					}
+
+					// what the stock sold cost comes out of Inventory
+					if err := inventory.PostCost(sessionContext, ledger.EventSellCost, stockSource, *movement); err != nil || bar == nil {
+						return err
+					}
+
+					// the bar is traced to the sale that took it
+					result, err := database.Database.Collection(models.Collection.MeltBatch).UpdateOne(sessionContext,
+						bson.M{"_id": bar.ID, "sold_by": bson.M{"$exists": false}},
+						bson.M{"$set": bson.M{"sold_by": newtransaction.ID}},
+					)
+					if err != nil {
+						return err
+					}
+					if result.MatchedCount == 0 {
+						return errBarNotForSale
+					}
+					return nil
+				}
+
+				_, err = inventory.Receive(sessionContext, inventory.KindBuy, newtransaction.Mineral, newtransaction.Grade, stockWeight, fineWeight, newtransaction.Amount, newtransaction.Currency, stockSource)
+				return err
+			})
+
+			if errors.Is(err, ledger.ErrPeriodClosed) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			if err != nil {
-				//TODO: return an error response of the required fields left empty
+			if errors.Is(err, inventory.ErrInsufficientStock) || errors.Is(err, errBarNotForSale) || errors.Is(err, ledger.ErrNoRate) {
 				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			insertResult, err := database.InsertDocument(models.Collection.Transaction, utils.ConvertStructPrimitive(newtransaction))
+			if errors.Is(err, lending.ErrOffsetNotBuy) || errors.Is(err, lending.ErrOffsetExceedsBuy) || errors.Is(err, lending.ErrAccountNotFound) ||
+				errors.Is(err, lending.ErrNoOpenAccount) || errors.Is(err, lending.ErrAccountMismatch) || errors.Is(err, lending.ErrAccountClosed) ||
+				errors.Is(err, lending.ErrOverpayment) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-			newtransaction.ID = insertResult.InsertedID.(primitive.ObjectID)
+			if errors.Is(err, ledger.ErrInsufficientFloat) {
+				context.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient float available, ask an admin to issue funds"})
+				return
+			}
 
+			// anything else, a write conflict among them, is not kept against
+			// the idempotency key so a retry goes through
 			if err != nil {
-				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				context.Abort()
 				return
 			}
 
-			balanceAmountSpent, _ := strconv.ParseFloat(balanceData.Spent, 64)
+			response := gin.H{
+				"created":     insertResult,
+				"transaction": newtransaction,
+				"quote":       quote,
+				"message":     "Successfully added a new transaction",
+			}
+
+			// the receipt shows what the gold fetched, what went to the loans
+			// and the cash paid out
+			if offset {
+				response["loan_offset"] = gin.H{
+					"amount":     newtransaction.Amount,
+					"offset":     newtransaction.LoanOffset,
+					"net_amount": newtransaction.NetAmount,
+					"repayments": repayments,
+					"accounts":   accounts,
+				}
+			}
+
+			context.JSON(http.StatusOK, response)
+			return
+
+		})
+
+		// price a transaction without recording it; an amount, when given, is
+		// checked against the computed price
+		transactionRoutes.POST("/quote", func(context *gin.Context) {
+			body := struct {
+				Kind     string          `json:"kind" validate:"required,oneof=buy sell"`
+				Scale    string          `json:"scale" validate:"required"`
+				Mineral  string          `json:"mineral" validate:"required"`
+				Grade    string          `json:"grade"`
+				Quality  *models.Quality `json:"quality"`
+				Currency string          `json:"currency" validate:"required,iso4217"`
+				Weight   models.Decimal  `json:"weight" validate:"required,positive"`
+				Purity   *models.Purity  `json:"purity"`
+				Rate     models.Decimal  `json:"rate"` // the board rate when empty
+				Amount   models.Decimal  `json:"amount"`
+			}{Kind: "buy", Currency: models.DefaultCurrency}
+
+			if err := context.ShouldBindJSON(&body); err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if body.Purity != nil && !body.Weight.IsSet() {
+				body.Weight = body.Purity.AirWeight
+			}
 
-			if newtransaction.Kind == "buy" {
-				s := fmt.Sprintf("%f", balanceAmountSpent+transactionAmount)
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-				_, err := database.UpdateDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "spent", Value: s}}}})
+			if body.Purity != nil {
+				var err error
+				body.Grade, err = testPurity(context, body.Mineral, body.Grade, body.Purity, body.Weight)
 
 				if err != nil {
 					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					context.Abort()
 					return
 				}
+			}
+
+			mineral, grade, err := catalogue.Describe(context, body.Mineral, body.Grade, body.Quality, true)
 
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
 			}
-			if newtransaction.Kind == "sell" {
-				newBalance := fmt.Sprintf("%f", balanceTotalalAmountBalance+transactionAmount)
 
-				_, err := database.UpdateDocument(models.Collection.Balance, bson.D{{Key: "_id", Value: fixedID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "amount", Value: newBalance}}}})
+			response := gin.H{"tolerance_percent": pricing.Tolerance()}
+
+			board, err := pricing.BoardRateFor(context, body.Kind, mineral, rateGrade(grade, body.Purity), strings.ToLower(body.Scale), body.Currency, time.Now())
 
+			switch {
+			case errors.Is(err, pricing.ErrNoBoardRate):
+				if !body.Rate.IsSet() {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error() + ", rate is required"})
+					return
+				}
+			case err != nil:
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			default:
+				response["board_rate"] = board
+				if !body.Rate.IsSet() {
+					body.Rate = board.Rate
+				}
+				review, err := pricing.Review(board, body.Rate)
 				if err != nil {
-					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					context.Abort()
+					context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return
 				}
+				response["needs_review"] = review != nil
+			}
 
+			quote, err := pricing.Price(context, body.Scale, mineral, pricedWeight(body.Weight, body.Purity), body.Rate)
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
 			}
 
-			context.JSON(http.StatusOK, gin.H{
-				"created":     insertResult,
-				"transaction": newtransaction,
-				"message":     "Successfully added a new transaction",
-			})
-			return
+			response["quote"] = quote
+			response["grade"] = grade
+			if body.Purity != nil {
+				response["purity"] = body.Purity
+			}
+
+			if body.Amount.IsSet() {
+				response["difference"] = body.Amount.Sub(quote.Amount)
+				response["accepted"] = quote.Check(body.Amount) == nil
+			}
+
+			context.JSON(http.StatusOK, response)
+		})
+
+		// transactions whose rate strayed from the board, awaiting review
+		transactionRoutes.GET("/reviews", middlewares.IsAdminValidate(), func(context *gin.Context) {
+			status := context.DefaultQuery("status", "pending")
+
+			cursor, err := database.FindManyDocuments(models.Collection.Transaction, bson.M{"rate_review.status": status}, bson.D{{Key: "created_at", Value: -1}})
+
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			transactions := []models.Transaction{}
 
+			if err := cursor.All(context, &transactions); err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			context.JSON(http.StatusOK, gin.H{"transactions": transactions})
+		})
+
+		// approve or reject a flagged rate; a rejected transaction is then
+		// voided through its void route
+		transactionRoutes.POST("/:id/review", middlewares.IsAdminValidate(), func(context *gin.Context) {
+			associate, err := authAssociate(context)
+
+			if err != nil {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+				return
+			}
+
+			id, err := primitive.ObjectIDFromHex(context.Param("id"))
+
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+				return
+			}
+
+			var body struct {
+				Decision string `json:"decision" validate:"required,oneof=approved rejected"`
+				Note     string `json:"note"`
+			}
+
+			if err := context.ShouldBindJSON(&body); err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := models.ValidateStruct.Struct(body); err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			result, err := database.UpdateDocument(models.Collection.Transaction,
+				bson.D{{Key: "_id", Value: id}, {Key: "rate_review.status", Value: "pending"}},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "rate_review.status", Value: body.Decision},
+					{Key: "rate_review.reviewed_by", Value: associate.ID},
+					{Key: "rate_review.reviewed_at", Value: time.Now()},
+					{Key: "rate_review.note", Value: body.Note},
+				}}},
+			)
+
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if result.MatchedCount == 0 {
+				context.JSON(http.StatusNotFound, gin.H{"error": "No transaction awaiting review with this ID"})
+				return
+			}
+
+			context.JSON(http.StatusOK, gin.H{"id": id, "decision": body.Decision, "message": "Rate reviewed"})
+		})
+
+		// volume and value bought and sold per mineral and grade
+		transactionRoutes.GET("/minerals", middlewares.IsAdminValidate(), func(context *gin.Context) {
+			filterParam := context.Query("filter")
+			filter := bson.M{}
+
+			if branchParam := context.Query("branch_id"); branchParam != "" {
+				branchID, err := primitive.ObjectIDFromHex(branchParam)
+				if err != nil {
+					context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch_id"})
+					return
+				}
+				filter["branch_id"] = branchID
+			}
+
+			if mineral := context.Query("mineral"); mineral != "" {
+				filter["mineral"] = mineral
+			}
+
+			now := time.Now()
+			switch filterParam {
+			case "today":
+				filter["created_at"] = bson.M{"$gte": time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+			case "week":
+				filter["created_at"] = bson.M{"$gte": now.AddDate(0, 0, -int(now.Weekday()))}
+			case "month":
+				filter["created_at"] = bson.M{"$gte": time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)}
+			case "year":
+				filter["created_at"] = bson.M{"$gte": time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)}
+			}
+
+			totals, err := database.SumByMineralGrade(context, filter)
+
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			context.JSON(http.StatusOK, gin.H{"minerals": totals})
 		})
 
 		transactionRoutes.GET("/scales", func(context *gin.Context) {
@@ -267,25 +765,49 @@ func SetupTransactionRoutes(router *gin.Engine) {
 				}
 			}
 
-			// Initialize a map to hold the results for each scale (using lowercase)
-			results := make(map[string]primitive.Decimal128)
+			scales, err := pricing.Scales(context, false)
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
 
-			// Sum all scale transactions with the filter
-			err := database.SumAllScaleTransactions(models.Collection.Transaction, filter, "amount", results)
+			currency, err := queryCurrency(context)
 			if err != nil {
-				context.JSON(http.StatusInternalServerError, gin.H{
-					"message": "Error fetching scale transactions",
-					"error":   err.Error(),
-				})
+				context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			// Return the results as JSON (lowercase keys)
-			context.JSON(http.StatusOK, gin.H{
-				"bb":   results["bb"],   // lowercase for bb
-				"mini": results["mini"], // lowercase for mini
-				"gb":   results["gb"],   // lowercase for gb
-			})
+			book, err := ledger.LoadRateBook(context, currency)
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			// each scale's amounts in ?currency=, converted at the rate of
+			// their day, zero when it has no transactions
+			results := make(map[string]models.Decimal, len(scales))
+			for _, scale := range scales {
+				scaleFilter := bson.M{"scale": bson.M{"$regex": "^" + regexp.QuoteMeta(scale.Code) + "$", "$options": "i"}}
+				for key, value := range filter {
+					scaleFilter[key] = value
+				}
+
+				results[scale.Code], err = ledger.ConvertedSum(context, book, models.Collection.Transaction, scaleFilter, "amount", "created_at")
+				if errors.Is(err, ledger.ErrNoRate) {
+					context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				if err != nil {
+					context.JSON(http.StatusInternalServerError, gin.H{
+						"message": "Error fetching scale transactions",
+						"error":   err.Error(),
+					})
+					return
+				}
+			}
+
+			// Return the results as JSON, one key per scale code
+			context.JSON(http.StatusOK, results)
 		})
 
 		transactionRoutes.GET("/profit", func(ctx *gin.Context) {
@@ -302,9 +824,22 @@ func SetupTransactionRoutes(router *gin.Engine) {
 				},
 			}
 
+			currency, err := queryCurrency(ctx)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			// amounts are reported in ?currency= at the rate of the day they were made
+			book, err := ledger.LoadRateBook(ctx, currency)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
 			var transactions []models.Transaction
 
-			cursor, err := database.FindManyDocuments(models.Collection.Transaction, filter, bson.D{{Key: "created_at", Value: 1}})
+			cursor, err := database.FindManyDocuments(models.Collection.Transaction, database.ExcludeVoided(filter), bson.D{{Key: "created_at", Value: 1}})
 			if err != nil {
 				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
 				return
@@ -315,59 +850,56 @@ func SetupTransactionRoutes(router *gin.Engine) {
 				return
 			}
 
+			margins, err := scaleMargins(ctx)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
 			// Initialize a map to store profit per day
-			profitPerDay := make(map[string]float64)
-			var bbTransactionProfitTotal, miniTransactionProfitTotal float64
+			profitPerDay := make(map[string]models.Decimal)
+			scaleProfit := make(map[string]models.Decimal)
+			for code := range margins {
+				scaleProfit[code] = models.NewDecimal(0, 0)
+			}
 
 			// Loop over the transactions and calculate the daily profit
 			for _, transaction := range transactions {
+				amount, err := book.Convert(transaction.Amount, transaction.Currency, transaction.CreatedAt)
+				if err != nil {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
 				// Format the date to group by day
 				day := transaction.CreatedAt.Format("2006-01-02")
 
-				// Convert the amount to float
-				amount, err := strconv.ParseFloat(transaction.Amount, 64)
-
-				if err != nil {
-					// Skip invalid transactions
-					continue
+				if _, ok := profitPerDay[day]; !ok {
+					profitPerDay[day] = models.NewDecimal(0, 0)
 				}
 
-				// weight, err := strconv.ParseFloat(transaction.Weight, 64)
-
-				// if err != nil {
-				// 	// Skip invalid transactions
-				// 	continue
-				// }
-
-				// rate, err := strconv.ParseFloat(transaction.Rate, 64)
-
-				// if err != nil {
-				// 	// Skip invalid transactions
-				// 	continue
-				// }
-
 				if transaction.Kind == "buy" {
 
-					if transaction.Scale == "bb" {
-						// add 4.60 to weight
-						bbTransactionProfitTotal += (amount * (15.81 / 100))
-
-					} else if transaction.Scale == "mini" {
-						// add 2.70 to weight
-						miniTransactionProfitTotal += (amount * (9.28 / 100))
+					// each scale's margin of the amount paid counts as profit
+					scale := strings.ToLower(transaction.Scale)
+					if margin, ok := margins[scale]; ok {
+						scaleProfit[scale] = scaleProfit[scale].Add(amount.Mul(margin))
 					}
 
-					profitPerDay[day] -= amount
+					profitPerDay[day] = profitPerDay[day].Sub(amount)
 
 				} else if transaction.Kind == "sell" {
 
-					profitPerDay[day] += amount
+					profitPerDay[day] = profitPerDay[day].Add(amount)
 
 				}
 
 			}
 
-			ctx.JSON(http.StatusOK, gin.H{"profit_per_day": profitPerDay, "bb_profit": bbTransactionProfitTotal, "mini_profit": miniTransactionProfitTotal, "gb_profit": 0.00})
+			response := scaleProfitResponse(scaleProfit)
+			response["currency"] = currency
+			response["profit_per_day"] = profitPerDay
+
+			ctx.JSON(http.StatusOK, response)
 
 		})
 
@@ -395,9 +927,22 @@ func SetupTransactionRoutes(router *gin.Engine) {
 				}
 			}
 
+			currency, err := queryCurrency(ctx)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			// amounts are reported in ?currency= at the rate of the day they were made
+			book, err := ledger.LoadRateBook(ctx, currency)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
 			var transactions []models.Transaction
 
-			cursor, err := database.FindManyDocuments(models.Collection.Transaction, filter, bson.D{{Key: "created_at", Value: 1}})
+			cursor, err := database.FindManyDocuments(models.Collection.Transaction, database.ExcludeVoided(filter), bson.D{{Key: "created_at", Value: 1}})
 			if err != nil {
 				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
 				return
@@ -408,50 +953,45 @@ func SetupTransactionRoutes(router *gin.Engine) {
 				return
 			}
 
-			var bbTransactionProfitTotal, miniTransactionProfitTotal float64
+			margins, err := scaleMargins(ctx)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			scaleProfit := make(map[string]models.Decimal)
+			for code := range margins {
+				scaleProfit[code] = models.NewDecimal(0, 0)
+			}
 
 			// Loop over the transactions and calculate the daily profit
 			for _, transaction := range transactions {
-
-				// Convert the amount to float
-				amount, err := strconv.ParseFloat(transaction.Amount, 64)
-
+				amount, err := book.Convert(transaction.Amount, transaction.Currency, transaction.CreatedAt)
 				if err != nil {
-					// Skip invalid transactions
-					continue
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
 				}
 
-				// weight, err := strconv.ParseFloat(transaction.Weight, 64)
-
-				// if err != nil {
-				// 	// Skip invalid transactions
-				// 	continue
-				// }
-
-				// rate, err := strconv.ParseFloat(transaction.Rate, 64)
-
-				// if err != nil {
-				// 	// Skip invalid transactions
-				// 	continue
-				// }
-
 				if transaction.Kind == "buy" {
 
-					if transaction.Scale == "bb" {
-						// add 4.60 to weight
-						bbTransactionProfitTotal += (amount * (15.81 / 100))
-
-					} else if transaction.Scale == "mini" {
-						// add 2.70 to weight
-						miniTransactionProfitTotal += (amount * (9.28 / 100))
+					// each scale's margin of the amount paid counts as profit
+					scale := strings.ToLower(transaction.Scale)
+					if margin, ok := margins[scale]; ok {
+						scaleProfit[scale] = scaleProfit[scale].Add(amount.Mul(margin))
 					}
 
 				}
 
 			}
 
-			ctx.JSON(http.StatusOK, gin.H{"bb_profit": bbTransactionProfitTotal, "mini_profit": miniTransactionProfitTotal, "gb_profit": 0.00})
+			response := scaleProfitResponse(scaleProfit)
+			response["currency"] = currency
+
+			ctx.JSON(http.StatusOK, response)
 
 		})
+
+		// void a transaction and reverse what it posted
+		transactionRoutes.POST("/:id/void", middlewares.IsAdminValidate(), voidHandler(models.Collection.Transaction, "created_at"))
 	}
 }
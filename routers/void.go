@@ -0,0 +1,280 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DreamSoft-LLC/oryan/database"
+	"github.com/DreamSoft-LLC/oryan/inventory"
+	"github.com/DreamSoft-LLC/oryan/ledger"
+	"github.com/DreamSoft-LLC/oryan/lending"
+	"github.com/DreamSoft-LLC/oryan/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errAlreadyVoided = errors.New("record is already voided")
+	errNotFound      = errors.New("record not found")
+	errInStash       = errors.New("record is in a stash lot, void the stash first")
+	errInMelt        = errors.New("record went into a melt, void the melt first")
+	errOffset        = errors.New("record repays a loan out of a buy, void the buy instead")
+)
+
+// reverseEntries reverses every journal entry a record posted that is not
+// already reversed.
+func reverseEntries(ctx context.Context, id primitive.ObjectID, associateID primitive.ObjectID, description string) ([]*models.JournalEntry, error) {
+	cursor, err := database.Database.Collection(models.Collection.Ledger).Find(ctx, bson.M{
+		"reference_id": id,
+		"reversal_of":  bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.JournalEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	reversals := []*models.JournalEntry{}
+	for i := range entries {
+		reversal, err := ledger.Reverse(ctx, &entries[i], associateID, description)
+		if err != nil {
+			return nil, err
+		}
+		reversals = append(reversals, reversal)
+	}
+
+	return reversals, nil
+}
+
+// voidRepayment voids a payoff record a buy was offset against along with
+// the buy: it comes off its loan account and what it posted is reversed.
+func voidRepayment(ctx context.Context, loanID primitive.ObjectID, void *models.Void, associateID primitive.ObjectID, description string) ([]*models.JournalEntry, error) {
+	var loan models.Loan
+	err := database.Database.Collection(models.Collection.Loan).FindOne(ctx,
+		bson.M{"_id": loanID, "void": bson.M{"$exists": false}},
+	).Decode(&loan)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lending.Unwind(ctx, loan.ID, loan.AccountID, void); err != nil {
+		return nil, err
+	}
+
+	_, err = database.Database.Collection(models.Collection.Loan).UpdateOne(ctx,
+		bson.M{"_id": loan.ID},
+		bson.M{"$set": bson.M{"void": void}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return reverseEntries(ctx, loan.ID, associateID, description)
+}
+
+// voidHandler voids a record of collection: the record is kept but marked
+// with who voided it and why, and every journal entry it posted is reversed.
+// dateField names the record's creation date, which must not be in a closed
+// period.
+func voidHandler(collection string, dateField string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		associate, err := authAssociate(c)
+
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "You do not have permission to the resource"})
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+			return
+		}
+
+		var body struct {
+			Reason string `json:"reason" validate:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := models.ValidateStruct.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		void := &models.Void{
+			VoidedBy: associate.ID,
+			VoidedAt: time.Now(),
+			Reason:   body.Reason,
+		}
+
+		reversals := []*models.JournalEntry{}
+		var stockReversals []models.StockMovement
+
+		err = database.WithTransaction(c, func(sessionContext mongo.SessionContext) error {
+			var record bson.M
+			err := database.Database.Collection(collection).FindOne(sessionContext, bson.D{{Key: "_id", Value: id}}).Decode(&record)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return errNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			if _, voided := record["void"]; voided {
+				return errAlreadyVoided
+			}
+
+			// a buy in a stash lot or a melt has left stock with it
+			if _, stashed := record["stash_id"]; stashed {
+				return errInStash
+			}
+			if _, melted := record["melt_id"]; melted {
+				return errInMelt
+			}
+
+			// a repayment out of a buy goes with the buy
+			if _, offset := record["transaction_id"]; offset {
+				return errOffset
+			}
+
+			// a loan record comes off its loan account
+			if accountID, ok := record["account_id"].(primitive.ObjectID); ok {
+				if err := lending.Unwind(sessionContext, id, accountID, void); err != nil {
+					return err
+				}
+			}
+
+			// voiding changes the record, so its own period must still be open
+			if createdAt, ok := record[dateField].(primitive.DateTime); ok {
+				if err := ledger.EnsureOpen(sessionContext, createdAt.Time()); err != nil {
+					return err
+				}
+			}
+
+			result, err := database.Database.Collection(collection).UpdateOne(sessionContext,
+				bson.D{{Key: "_id", Value: id}, {Key: "void", Value: bson.M{"$exists": false}}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "void", Value: void}}}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errAlreadyVoided
+			}
+
+			reversals, err = reverseEntries(sessionContext, id, associate.ID, "Void: "+body.Reason)
+			if err != nil {
+				return err
+			}
+
+			// the loans a buy repaid are owed again
+			if repaymentIDs, ok := record["repayment_ids"].(primitive.A); ok {
+				for _, value := range repaymentIDs {
+					loanID, ok := value.(primitive.ObjectID)
+					if !ok {
+						continue
+					}
+					repaid, err := voidRepayment(sessionContext, loanID, void, associate.ID, "Void: "+body.Reason)
+					if err != nil {
+						return err
+					}
+					reversals = append(reversals, repaid...)
+				}
+			}
+
+			// and the stock it moved
+			stockReversals, err = inventory.Reverse(sessionContext, id, associate.ID)
+			if err != nil {
+				return err
+			}
+
+			// the buys in a voided stash's lot or melt are free to use again
+			for _, field := range []string{"stash_id", "melt_id"} {
+				_, err = database.Database.Collection(models.Collection.Transaction).UpdateMany(sessionContext,
+					bson.M{field: id},
+					bson.M{"$unset": bson.M{field: ""}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+
+			// a voided melt gives up its bar's serial so it can be used again
+			if _, ok := record["serial"]; ok && collection == models.Collection.MeltBatch {
+				_, err = database.Database.Collection(models.Collection.MeltBatch).UpdateOne(sessionContext,
+					bson.M{"_id": id},
+					bson.M{"$rename": bson.M{"serial": "voided_serial"}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+
+			// as is the bar of a voided sale
+			_, err = database.Database.Collection(models.Collection.MeltBatch).UpdateMany(sessionContext,
+				bson.M{"sold_by": id},
+				bson.M{"$unset": bson.M{"sold_by": ""}},
+			)
+			return err
+		})
+
+		if errors.Is(err, errNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, errAlreadyVoided) || errors.Is(err, errInStash) || errors.Is(err, errInMelt) || errors.Is(err, errOffset) ||
+			errors.Is(err, lending.ErrHasRepayments) || errors.Is(err, lending.ErrAccountClosed) || errors.Is(err, lending.ErrCollateralReleased) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, ledger.ErrPeriodClosed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Insuficient balance available to reverse this record"})
+			return
+		}
+
+		if errors.Is(err, inventory.ErrInsufficientStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The stock this record added has already left the branch"})
+			return
+		}
+
+		if errors.Is(err, ledger.ErrInsufficientFloat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The associate is no longer holding the float this record added"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"id":        id,
+			"void":      void,
+			"reversals": reversals,
+			"stock":     stockReversals,
+			"message":   "Record voided",
+		})
+	}
+}